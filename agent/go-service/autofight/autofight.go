@@ -1,25 +1,86 @@
 package autofight
 
 import (
+	"encoding/json"
 	"fmt"
 	"image"
-	"image/png"
-	"os"
-	"path/filepath"
+	"image/color"
+	"slices"
 	"sort"
+	"sync"
 	"time"
 
+	"github.com/MaaXYZ/MaaEnd/agent/go-service/pkg/minicv"
 	"github.com/MaaXYZ/maa-framework-go/v4"
 	"github.com/rs/zerolog/log"
 )
 
-func getCharactorLevelShow(ctx *maa.Context, arg *maa.CustomRecognitionArg) bool {
-	detail, err := ctx.RunRecognition("__AutoFightRecognitionCharactorLevelShow", arg.Img)
-	if err != nil || detail == nil {
-		log.Error().Err(err).Msg("Failed to run recognition for combo notice")
-		return false
+// frameChangeMaxDiff 区域缩略图像素差异不超过该值时视为画面未变化
+const frameChangeMaxDiff = 8.0
+
+var (
+	frameChanges  = minicv.NewFrameChangeDetector(frameChangeMaxDiff)
+	cacheMu       sync.Mutex
+	cachedResults = map[string]any{}
+	cachedRois    = map[string]image.Rectangle{} // 各识别结果对应的 roi，用于调试图标注
+)
+
+// runCached 在 roi 区域画面相比上次识别时未变化时直接复用上次的识别结果，跳过重复的 RunRecognition。
+// roi 需与识别节点的 roi 一致，roi 为空时不缓存；识别出错时不缓存结果。
+func runCached[T any](key string, img image.Image, roi image.Rectangle, run func() (T, error)) T {
+	changed := frameChanges.Changed(key, img, roi)
+	cacheMu.Lock()
+	cachedRois[key] = roi
+	result, ok := cachedResults[key]
+	cacheMu.Unlock()
+	if !changed && ok {
+		return result.(T)
+	}
+
+	fresh, err := run()
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	if err != nil {
+		delete(cachedResults, key)
+		frameChanges.Forget(key)
+		return fresh
 	}
-	return detail.Hit
+	cachedResults[key] = fresh
+	return fresh
+}
+
+// getNodeRoi 从节点定义中读取识别的 roi，读取失败时返回空区域
+func getNodeRoi(ctx *maa.Context, nodeName string) image.Rectangle {
+	raw, err := ctx.GetNodeJSON(nodeName)
+	if err != nil {
+		log.Warn().Err(err).Str("node", nodeName).Msg("Failed to get node for roi")
+		return image.Rectangle{}
+	}
+	var node struct {
+		Recognition struct {
+			Param struct {
+				Roi []int `json:"roi"`
+			} `json:"param"`
+		} `json:"recognition"`
+	}
+	if err := json.Unmarshal([]byte(raw), &node); err != nil || len(node.Recognition.Param.Roi) != 4 {
+		log.Warn().Err(err).Str("node", nodeName).Msg("Failed to parse roi of node")
+		return image.Rectangle{}
+	}
+	r := node.Recognition.Param.Roi
+	return image.Rect(r[0], r[1], r[0]+r[2], r[1]+r[3])
+}
+
+func getCharactorLevelShow(ctx *maa.Context, arg *maa.CustomRecognitionArg) bool {
+	roi := getNodeRoi(ctx, "__AutoFightRecognitionCharactorLevelShow")
+	return runCached("CharactorLevelShow", arg.Img, roi, func() (bool, error) {
+		detail, err := ctx.RunRecognition("__AutoFightRecognitionCharactorLevelShow", arg.Img)
+		if err != nil || detail == nil {
+			log.Error().Err(err).Msg("Failed to run recognition for combo notice")
+			return false, err
+		}
+		return detail.Hit, nil
+	})
 }
 
 func getComboUsable(ctx *maa.Context, arg *maa.CustomRecognitionArg, index int) bool {
@@ -38,34 +99,47 @@ func getComboUsable(ctx *maa.Context, arg *maa.CustomRecognitionArg, index int)
 		return false
 	}
 
-	override := map[string]any{
-		"__AutoFightRecognitionComboUsable": map[string]any{
-			"roi": maa.Rect{roiX, 657, 56, 4},
-		},
-	}
-	detail, err := ctx.RunRecognition("__AutoFightRecognitionComboUsable", arg.Img, override)
-	if err != nil {
-		log.Error().Err(err).Int("index", index).Msg("Failed to run recognition for combo usable")
+	// 节点中的 roi 为第一个连携技的位置，其他连携技只平移 x
+	base := getNodeRoi(ctx, "__AutoFightRecognitionComboUsable")
+	if base.Empty() {
 		return false
 	}
-	return detail != nil && detail.Hit
+	roi := base.Add(image.Pt(roiX-base.Min.X, 0))
+	return runCached(fmt.Sprintf("ComboUsable%d", index), arg.Img, roi, func() (bool, error) {
+		override := map[string]any{
+			"__AutoFightRecognitionComboUsable": map[string]any{
+				"roi": maa.Rect{roi.Min.X, roi.Min.Y, roi.Dx(), roi.Dy()},
+			},
+		}
+		detail, err := ctx.RunRecognition("__AutoFightRecognitionComboUsable", arg.Img, override)
+		if err != nil {
+			log.Error().Err(err).Int("index", index).Msg("Failed to run recognition for combo usable")
+			return false, err
+		}
+		return detail != nil && detail.Hit, nil
+	})
 }
 
 func getEndSkillUsable(ctx *maa.Context, arg *maa.CustomRecognitionArg) []int {
+	roi := getNodeRoi(ctx, "__AutoFightRecognitionEndSkill")
+	return runCached("EndSkillUsable", arg.Img, roi, func() ([]int, error) {
+		return findEndSkillUsable(ctx, arg, roi)
+	})
+}
+
+func findEndSkillUsable(ctx *maa.Context, arg *maa.CustomRecognitionArg, roi image.Rectangle) ([]int, error) {
 	usableIndexes := []int{}
-	const roiX, roiWidth = 1010, 270
-	override := map[string]any{
-		"__AutoFightRecognitionEndSkill": map[string]any{
-			"roi": maa.Rect{roiX, 535, roiWidth, 65},
-		},
+	if roi.Empty() {
+		return usableIndexes, fmt.Errorf("roi of __AutoFightRecognitionEndSkill not found")
 	}
-	detail, err := ctx.RunRecognition("__AutoFightRecognitionEndSkill", arg.Img, override)
+	roiX, roiWidth := roi.Min.X, roi.Dx()
+	detail, err := ctx.RunRecognition("__AutoFightRecognitionEndSkill", arg.Img)
 	if err != nil || detail == nil {
 		log.Error().Err(err).Msg("Failed to run recognition for end skill")
-		return usableIndexes
+		return usableIndexes, err
 	}
 	if !detail.Hit || detail.Results == nil || len(detail.Results.Filtered) == 0 {
-		return usableIndexes
+		return usableIndexes, nil
 	}
 
 	quarterWidth := roiWidth / 4
@@ -92,7 +166,7 @@ func getEndSkillUsable(ctx *maa.Context, arg *maa.CustomRecognitionArg) []int {
 		}
 		usableIndexes = append(usableIndexes, idx)
 	}
-	return usableIndexes
+	return usableIndexes, nil
 }
 
 func hasComboShow(ctx *maa.Context, arg *maa.CustomRecognitionArg) bool {
@@ -123,25 +197,28 @@ func hasEnemyInScreen(ctx *maa.Context, arg *maa.CustomRecognitionArg) bool {
 }
 
 func getEnergyLevel(ctx *maa.Context, arg *maa.CustomRecognitionArg) int {
-	// 第一格能量满
-	detail, err := ctx.RunRecognition("__AutoFightRecognitionEnergyLevel1", arg.Img)
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to run recognition for AutoFightRecognitionEnergyLevel1")
-		return -1
-	}
-	if detail != nil && detail.Hit {
-		return 1
-	}
+	roi := getNodeRoi(ctx, "__AutoFightRecognitionEnergyLevel1").Union(getNodeRoi(ctx, "__AutoFightRecognitionEnergyLevel0"))
+	return runCached("EnergyLevel", arg.Img, roi, func() (int, error) {
+		// 第一格能量满
+		detail, err := ctx.RunRecognition("__AutoFightRecognitionEnergyLevel1", arg.Img)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to run recognition for AutoFightRecognitionEnergyLevel1")
+			return -1, err
+		}
+		if detail != nil && detail.Hit {
+			return 1, nil
+		}
 
-	// 第一格能量空
-	detail, err = ctx.RunRecognition("__AutoFightRecognitionEnergyLevel0", arg.Img)
-	if err != nil {
-		return -1
-	}
-	if detail != nil && detail.Hit {
-		return 0
-	}
-	return -1
+		// 第一格能量空
+		detail, err = ctx.RunRecognition("__AutoFightRecognitionEnergyLevel0", arg.Img)
+		if err != nil {
+			return -1, err
+		}
+		if detail != nil && detail.Hit {
+			return 0, nil
+		}
+		return -1, nil
+	})
 }
 
 func hasCharacterBar(ctx *maa.Context, arg *maa.CustomRecognitionArg) bool {
@@ -215,26 +292,40 @@ func (r *AutoFightEntryRecognition) Run(ctx *maa.Context, arg *maa.CustomRecogni
 
 var pauseNotInFightSince time.Time
 
-// saveExitImage 将当前画面保存到 debug/autofight_exit 目录，用于排查退出时的画面。
+// saveExitImage 将当前画面标注各识别区域及其最近一次的识别结果后保存到 debug/autofight_exit 目录，用于排查退出时的画面。
+// 命中的区域为绿色，未命中为红色，其他结果为黄色。
 func saveExitImage(img image.Image, reason string) {
 	if img == nil {
 		return
 	}
-	dir := filepath.Join("debug", "autofight_exit")
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		log.Debug().Err(err).Str("dir", dir).Msg("Failed to create debug dir for exit image")
-		return
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	annotated := minicv.ImageCopyRGBA(img)
+	keys := make([]string, 0, len(cachedRois))
+	for key := range cachedRois {
+		keys = append(keys, key)
 	}
-	name := fmt.Sprintf("%s_%s.png", reason, time.Now().Format("20060102_150405"))
-	path := filepath.Join(dir, name)
-	f, err := os.Create(path)
-	if err != nil {
-		log.Debug().Err(err).Str("path", path).Msg("Failed to create file for exit image")
-		return
+	slices.Sort(keys)
+	for _, key := range keys {
+		result, ok := cachedResults[key]
+		if !ok {
+			continue
+		}
+		c := color.RGBA{255, 220, 0, 255}
+		if hit, ok := result.(bool); ok {
+			if hit {
+				c = color.RGBA{0, 255, 0, 255}
+			} else {
+				c = color.RGBA{255, 0, 0, 255}
+			}
+		}
+		rect := cachedRois[key]
+		minicv.DrawRect(annotated, rect, c, 1)
+		minicv.DrawLabel(annotated, rect.Min.X, rect.Min.Y-14, fmt.Sprintf("%s: %v", key, result), c)
 	}
-	defer f.Close()
-	if err := png.Encode(f, img); err != nil {
-		log.Debug().Err(err).Str("path", path).Msg("Failed to encode exit image")
+	path, err := minicv.SaveDebugImage(annotated, "autofight_exit", reason)
+	if err != nil {
+		log.Debug().Err(err).Msg("Failed to save exit image")
 		return
 	}
 	log.Info().Str("path", path).Str("reason", reason).Msg("Saved exit frame to disk")
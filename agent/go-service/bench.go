@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	maptracker "github.com/MaaXYZ/MaaEnd/agent/go-service/map-tracker"
+	"github.com/rs/zerolog/log"
+)
+
+// benchColumns are the required columns of the ground-truth CSV
+var benchColumns = []string{"image", "map", "x", "y", "rot"}
+
+// runBench runs the offline map-tracker accuracy benchmark.
+// Usage: go-service bench <dir> [-truth file] [-resource dir] [-precisions a,b] [-threshold v] [-regex r] [-hit-radius v] [-pyramid] [-out file]
+func runBench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	truthFlag := fs.String("truth", "", "ground-truth CSV with columns image,map,x,y,rot (default: <dir>/truth.csv)")
+	resourceFlag := fs.String("resource", "resource", "resource directory containing image/MapTracker")
+	precisionsFlag := fs.String("precisions", "0.3,0.5,0.7,1.0", "comma-separated precision values to benchmark")
+	thresholdFlag := fs.Float64("threshold", maptracker.DEFAULT_INFERENCE_PARAM.Threshold, "minimum confidence for an inference to count")
+	regexFlag := fs.String("regex", maptracker.DEFAULT_INFERENCE_PARAM.MapNameRegex, "map name regex used for inference")
+	hitRadiusFlag := fs.Float64("hit-radius", 10.0, "maximum location error in px for a location to count as a hit")
+	pyramidFlag := fs.Bool("pyramid", false, "match coarse to fine in the full search")
+	outFlag := fs.String("out", "", "JSON report path (default: debug/bench_<time>.json)")
+
+	// Allow the screenshot directory to be given either before or after the flags
+	var dir string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		dir, args = args[0], args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if dir == "" {
+		dir = fs.Arg(0)
+	}
+	if dir == "" {
+		return fmt.Errorf("screenshot directory is required")
+	}
+
+	truthPath := *truthFlag
+	if truthPath == "" {
+		truthPath = filepath.Join(dir, "truth.csv")
+	}
+	samples, err := loadBenchSamples(dir, truthPath)
+	if err != nil {
+		return err
+	}
+	precisions, err := parseBenchPrecisions(*precisionsFlag)
+	if err != nil {
+		return err
+	}
+
+	outPath := *outFlag
+	if outPath == "" {
+		outPath = filepath.Join("debug", fmt.Sprintf("bench_%s.json", time.Now().Format("20060102_150405")))
+	}
+
+	maptracker.SetResourcePath(*resourceFlag)
+
+	log.Info().
+		Str("dir", dir).
+		Int("samples", len(samples)).
+		Floats64("precisions", precisions).
+		Msg("Starting map-tracker benchmark")
+
+	stats, err := maptracker.RunBenchmark(samples, maptracker.BenchOptions{
+		Precisions:   precisions,
+		Threshold:    *thresholdFlag,
+		MapNameRegex: *regexFlag,
+		HitRadius:    *hitRadiusFlag,
+		Pyramid:      *pyramidFlag,
+	})
+	if err != nil {
+		return err
+	}
+
+	printBenchStats(stats)
+
+	if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+		return fmt.Errorf("failed to create report directory: %w", err)
+	}
+	data, err := json.MarshalIndent(stats, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal report: %w", err)
+	}
+	if err := os.WriteFile(outPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	log.Info().Str("out", outPath).Msg("Benchmark finished")
+	return nil
+}
+
+// loadBenchSamples reads the ground-truth CSV. Image paths are relative to dir.
+func loadBenchSamples(dir, truthPath string) ([]maptracker.BenchSample, error) {
+	file, err := os.Open(truthPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ground-truth CSV: %w", err)
+	}
+	defer file.Close()
+
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ground-truth CSV: %w", err)
+	}
+	if len(rows) < 2 {
+		return nil, fmt.Errorf("ground-truth CSV has no samples")
+	}
+
+	// Locate the columns by the header row
+	index := make(map[string]int)
+	for i, name := range rows[0] {
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range benchColumns {
+		if _, ok := index[name]; !ok {
+			return nil, fmt.Errorf("ground-truth CSV is missing column %q", name)
+		}
+	}
+
+	samples := make([]maptracker.BenchSample, 0, len(rows)-1)
+	for line, row := range rows[1:] {
+		field := func(name string) string {
+			return strings.TrimSpace(row[index[name]])
+		}
+		nums := make(map[string]int)
+		for _, name := range []string{"x", "y", "rot"} {
+			v, err := strconv.Atoi(field(name))
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s at line %d of ground-truth CSV: %w", name, line+2, err)
+			}
+			nums[name] = v
+		}
+		samples = append(samples, maptracker.BenchSample{
+			Image:   filepath.Join(dir, field("image")),
+			MapName: field("map"),
+			X:       nums["x"],
+			Y:       nums["y"],
+			Rot:     nums["rot"],
+		})
+	}
+	return samples, nil
+}
+
+// parseBenchPrecisions parses a comma-separated list of precision values
+func parseBenchPrecisions(s string) ([]float64, error) {
+	precisions := make([]float64, 0)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || v <= 0 || v > 1 {
+			return nil, fmt.Errorf("invalid precision value: %s", part)
+		}
+		precisions = append(precisions, v)
+	}
+	if len(precisions) == 0 {
+		return nil, fmt.Errorf("at least one precision value is required")
+	}
+	return precisions, nil
+}
+
+// printBenchStats prints the benchmark statistics as a table
+func printBenchStats(stats []maptracker.BenchStats) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "precision\tmap\tsamples\thit rate\tloc err (px)\trot err (deg)\tloc time (ms)\trot time (ms)\t")
+	for _, s := range stats {
+		fmt.Fprintf(w, "%.2f\t%s\t%d\t%.1f%%\t%.2f\t%.2f\t%.1f\t%.1f\t\n",
+			s.Precision, s.MapName, s.Samples, s.LocHitRate*100, s.LocErrorPx, s.RotErrorDeg, s.LocTimeMs, s.RotTimeMs)
+	}
+	w.Flush()
+}
+
+// runBenchMatch runs the offline map-tracker matching speed benchmark on the shipped maps.
+// Usage: go-service bench-match [-resource dir] [-precisions a,b] [-regex r] [-needles n] [-out file]
+func runBenchMatch(args []string) error {
+	fs := flag.NewFlagSet("bench-match", flag.ContinueOnError)
+	resourceFlag := fs.String("resource", "resource", "resource directory containing image/MapTracker")
+	precisionsFlag := fs.String("precisions", "0.3,0.5,0.7,1.0", "comma-separated precision values to benchmark")
+	regexFlag := fs.String("regex", maptracker.DEFAULT_INFERENCE_PARAM.MapNameRegex, "map name regex of the maps to benchmark")
+	needlesFlag := fs.Int("needles", 3, "number of needles cut from each map")
+	outFlag := fs.String("out", "", "JSON report path (default: debug/bench_match_<time>.json)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	precisions, err := parseBenchPrecisions(*precisionsFlag)
+	if err != nil {
+		return err
+	}
+	if *needlesFlag <= 0 {
+		return fmt.Errorf("needles must be positive")
+	}
+
+	outPath := *outFlag
+	if outPath == "" {
+		outPath = filepath.Join("debug", fmt.Sprintf("bench_match_%s.json", time.Now().Format("20060102_150405")))
+	}
+
+	maptracker.SetResourcePath(*resourceFlag)
+
+	log.Info().
+		Floats64("precisions", precisions).
+		Int("needles", *needlesFlag).
+		Msg("Starting map-tracker matching benchmark")
+
+	stats, err := maptracker.RunMatchBenchmark(maptracker.MatchBenchOptions{
+		Precisions:   precisions,
+		MapNameRegex: *regexFlag,
+		Needles:      *needlesFlag,
+	})
+	if err != nil {
+		return err
+	}
+
+	printMatchBenchStats(stats)
+
+	if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+		return fmt.Errorf("failed to create report directory: %w", err)
+	}
+	data, err := json.MarshalIndent(stats, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal report: %w", err)
+	}
+	if err := os.WriteFile(outPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	log.Info().Str("out", outPath).Msg("Matching benchmark finished")
+	return nil
+}
+
+// printMatchBenchStats prints the matching benchmark statistics as a table
+func printMatchBenchStats(stats []maptracker.MatchBenchStats) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "precision\tmap\tsize\tneedles\tspatial (ms)\tfft (ms)\tspeedup\tauto\tauto (ms)\tagreement\t")
+	for _, s := range stats {
+		size := "-"
+		if s.Width > 0 {
+			size = fmt.Sprintf("%dx%d", s.Width, s.Height)
+		}
+		auto := s.Auto
+		if auto == "" {
+			auto = "-"
+		}
+		fmt.Fprintf(w, "%.2f\t%s\t%s\t%d\t%.1f\t%.1f\t%.2fx\t%s\t%.1f\t%.0f%%\t\n",
+			s.Precision, s.MapName, size, s.Needles, s.SpatialMs, s.FFTMs, s.Speedup, auto, s.AutoMs, s.Agreement*100)
+	}
+	w.Flush()
+}
@@ -1,6 +1,7 @@
 package dailyrewards
 
 import (
+	"github.com/MaaXYZ/MaaEnd/agent/go-service/pkg/minicv"
 	"github.com/MaaXYZ/maa-framework-go/v4"
 	"github.com/rs/zerolog/log"
 )
@@ -22,6 +23,7 @@ type DailyEventUnreadItemInitRecognition struct{}
 
 func (r *DailyEventUnreadItemInitRecognition) Run(ctx *maa.Context, arg *maa.CustomRecognitionArg) (*maa.CustomRecognitionResult, bool) {
 	dailyEventUnreadItems = nil
+	rewardsPopupChanges.Reset()
 
 	// 在左侧区域查找所有红点图标
 	overrideParamRedDot := map[string]any{
@@ -228,3 +230,44 @@ func (r *DailyEventUnreadDetailPickRecognition) Run(ctx *maa.Context, arg *maa.C
 		Detail: `{"custom": "pick unread detail"}`,
 	}, true
 }
+
+const (
+	// rewardsPopupChangeMaxDiff 画面缩略图像素差异不超过该值时视为画面未变化
+	rewardsPopupChangeMaxDiff = 8.0
+	// rewardsPopupChangeMaxHashDist 画面 pHash 汉明距离不超过该值时视为画面未变化，用于发现像素差异较小的整体变化
+	rewardsPopupChangeMaxHashDist = 4
+)
+
+// 等待奖励弹窗时，画面相比上次识别未命中时未变化则弹窗仍未出现，无需重复识别
+var rewardsPopupChanges = func() *minicv.FrameChangeDetector {
+	d := minicv.NewFrameChangeDetector(rewardsPopupChangeMaxDiff)
+	d.MaxHashDist = rewardsPopupChangeMaxHashDist
+	return d
+}()
+
+type DailyEventRewardsPopupRecognition struct{}
+
+func (r *DailyEventRewardsPopupRecognition) Run(ctx *maa.Context, arg *maa.CustomRecognitionArg) (*maa.CustomRecognitionResult, bool) {
+	const key = "RewardsPopup"
+	// 上次未识别到弹窗且画面相比当时未变化，跳过本次识别
+	if !rewardsPopupChanges.Changed(key, arg.Img, arg.Img.Bounds()) {
+		return nil, false
+	}
+
+	detail, err := ctx.RunRecognition("DailyEventRecognitionRewardsPopup", arg.Img)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to run recognition for rewards popup")
+		rewardsPopupChanges.Forget(key)
+		return nil, false
+	}
+	if detail == nil || !detail.Hit {
+		return nil, false
+	}
+
+	// 弹窗关闭后需要重新识别
+	rewardsPopupChanges.Forget(key)
+	return &maa.CustomRecognitionResult{
+		Box:    detail.Box,
+		Detail: `{"custom": "rewards popup"}`,
+	}, true
+}
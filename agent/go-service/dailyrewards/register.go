@@ -7,6 +7,7 @@ var (
 	_ maa.CustomRecognitionRunner = &DailyEventUnreadItemSwitchRecognition{}
 	_ maa.CustomRecognitionRunner = &DailyEventUnreadDetailInitRecognition{}
 	_ maa.CustomRecognitionRunner = &DailyEventUnreadDetailPickRecognition{}
+	_ maa.CustomRecognitionRunner = &DailyEventRewardsPopupRecognition{}
 )
 
 // Register registers all custom recognition and action components for dailyrewards package
@@ -15,4 +16,5 @@ func Register() {
 	maa.AgentServerRegisterCustomRecognition("DailyEventUnreadItemSwitchRecognition", &DailyEventUnreadItemSwitchRecognition{})
 	maa.AgentServerRegisterCustomRecognition("DailyEventUnreadDetailInitRecognition", &DailyEventUnreadDetailInitRecognition{})
 	maa.AgentServerRegisterCustomRecognition("DailyEventUnreadDetailPickRecognition", &DailyEventUnreadDetailPickRecognition{})
+	maa.AgentServerRegisterCustomRecognition("DailyEventRewardsPopupRecognition", &DailyEventRewardsPopupRecognition{})
 }
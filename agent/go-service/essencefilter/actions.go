@@ -3,12 +3,15 @@ package essencefilter
 import (
 	"encoding/json"
 	"fmt"
+	"image"
+	"image/color"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 
+	"github.com/MaaXYZ/MaaEnd/agent/go-service/pkg/minicv"
 	maa "github.com/MaaXYZ/maa-framework-go/v4"
 	"github.com/rs/zerolog/log"
 )
@@ -345,7 +348,7 @@ func (a *EssenceFilterCheckItemLevelAction) Run(ctx *maa.Context, arg *maa.Custo
 	return false
 }
 
-// EssenceFilterRowCollectAction - collect boxes in a row (TemplateMatch detail) + ColorMatch filter, click first
+// EssenceFilterRowCollectAction - collect boxes in a row (TemplateMatch detail) + color filter on one frame, click first
 type EssenceFilterRowCollectAction struct{}
 
 func (a *EssenceFilterRowCollectAction) Run(ctx *maa.Context, arg *maa.CustomActionArg) bool {
@@ -371,8 +374,10 @@ func (a *EssenceFilterRowCollectAction) Run(ctx *maa.Context, arg *maa.CustomAct
 		log.Error().Err(err).Msg("<EssenceFilter> RowCollect: get screenshot failed")
 		return false
 	}
+	rgba := minicv.ImageConvertRGBA(img)
 
 	rowBoxes = rowBoxes[:0]
+	var rejectedBoxes [][4]int // 未通过颜色过滤的格子，仅用于调试图
 	for _, res := range results {
 		tm, ok := res.AsTemplateMatch()
 		if !ok {
@@ -390,28 +395,22 @@ func (a *EssenceFilterRowCollectAction) Run(ctx *maa.Context, arg *maa.CustomAct
 			continue // skip invalid ROIs
 		}
 
-		roi := maa.Rect{colorMatchROIX, colorMatchROIY, colorMatchROIW, colorMatchROIH}
+		roi := image.Rect(colorMatchROIX, colorMatchROIY, colorMatchROIX+colorMatchROIW, colorMatchROIY+colorMatchROIH)
 
+		// 颜色范围内的最大连通区域足够大即视为该类基质
+		matched := false
 		for _, et := range EssenceTypes {
-			ColorMatchOverrideParam := map[string]any{
-				"EssenceColorMatch": map[string]any{
-					"roi":   roi,
-					"lower": et.Range.Lower,
-					"upper": et.Range.Upper,
-				},
-			}
-			cDetail, err := ctx.RunRecognition("EssenceColorMatch", img, ColorMatchOverrideParam)
-
-			if err != nil {
-				log.Error().Err(err).Ints("box", boxArr[:]).Msg("<EssenceFilter> RowCollect: ColorMatch failed")
-				continue
-			}
-
-			if cDetail != nil && cDetail.Hit {
-				rowBoxes = append(rowBoxes, boxArr)
+			mask := minicv.InRangeHSV(rgba, roi, minicv.HSVRangeFromCV(et.Range.Lower, et.Range.Upper))
+			if comps := minicv.ConnectedComponents(mask); len(comps) > 0 && comps[0].Area >= essenceColorMinArea {
+				matched = true
 				break
 			}
 		}
+		if matched {
+			rowBoxes = append(rowBoxes, boxArr)
+		} else {
+			rejectedBoxes = append(rejectedBoxes, boxArr)
+		}
 	}
 	// sort rowboxes by Y coordinate then X coordinate
 	sort.Slice(rowBoxes, func(i, j int) bool {
@@ -443,6 +442,7 @@ func (a *EssenceFilterRowCollectAction) Run(ctx *maa.Context, arg *maa.CustomAct
 	// 在非尾扫的情况下，如果符合条件的box数量超过单行最大可处理数量，直接结束当前行的处理，避免误操作；如果是尾扫，则不论数量多少都继续处理
 	if (len(rowBoxes) > maxItemsPerRow) && !isFallbackScan {
 		log.Error().Int("count", len(rowBoxes)).Msg("<EssenceFilter> RowCollect: boxes > maxItemsPerRow, abort")
+		saveRowDebugImage(rgba, rowBoxes, rejectedBoxes, arg.CurrentTaskName)
 		ctx.OverrideNext(arg.CurrentTaskName, []maa.NextItem{
 			{Name: "EssenceFilterFinish"},
 		})
@@ -463,6 +463,26 @@ func (a *EssenceFilterRowCollectAction) Run(ctx *maa.Context, arg *maa.CustomAct
 	return true
 }
 
+// saveRowDebugImage 将本行识别到的格子标注后保存到 debug 目录：通过颜色过滤的为绿色并标注序号，未通过的为红色
+func saveRowDebugImage(img *image.RGBA, kept, rejected [][4]int, nodeName string) {
+	annotated := minicv.ImageCopyRGBA(img)
+	keptColor := color.RGBA{0, 255, 0, 255}
+	rejectedColor := color.RGBA{255, 0, 0, 255}
+	for _, b := range rejected {
+		minicv.DrawRect(annotated, image.Rect(b[0], b[1], b[0]+b[2], b[1]+b[3]), rejectedColor, 1)
+	}
+	for i, b := range kept {
+		minicv.DrawRect(annotated, image.Rect(b[0], b[1], b[0]+b[2], b[1]+b[3]), keptColor, 2)
+		minicv.DrawLabel(annotated, b[0]+2, b[1]+2, strconv.Itoa(i+1), keptColor)
+	}
+	path, err := minicv.SaveDebugImage(annotated, "", nodeName)
+	if err != nil {
+		log.Warn().Err(err).Msg("<EssenceFilter> RowCollect: save debug image failed")
+		return
+	}
+	log.Info().Str("path", path).Msg("<EssenceFilter> RowCollect: debug image saved")
+}
+
 // EssenceFilterRowNextItemAction - proceed to next box or swipe/finish
 type EssenceFilterRowNextItemAction struct{}
 
@@ -76,6 +76,7 @@ type EssenceFilterOptions struct {
 	Slot3MinLevel            int  `json:"slot3_min_level"`
 }
 
+// ColorRange is an HSV range in the 8-bit scale of OpenCV, as used by ColorMatch
 type ColorRange struct {
 	Lower [3]int
 	Upper [3]int
@@ -138,3 +139,6 @@ var (
 
 	EssenceTypes []EssenceMeta
 )
+
+// 基质颜色范围内的最小连通区域像素数
+const essenceColorMinArea = 100
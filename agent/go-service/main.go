@@ -22,7 +22,47 @@ func main() {
 		Msg("MaaEnd Agent Service")
 
 	if len(os.Args) < 2 {
-		log.Fatal().Msg("Usage: go-service <identifier>")
+		log.Fatal().Msg("Usage: go-service <identifier> | go-service replay <dir> [flags] | go-service bench <dir> [flags] | go-service bench-match [flags] | go-service walkmask [flags]")
+	}
+
+	// Offline replay mode feeds saved screenshots through recognitions without a client
+	if os.Args[1] == "replay" {
+		if err := runReplay(os.Args[2:]); err != nil {
+			log.Fatal().
+				Err(err).
+				Msg("Replay failed")
+		}
+		return
+	}
+
+	// Offline benchmark mode measures map-tracker accuracy against ground-truth screenshots
+	if os.Args[1] == "bench" {
+		if err := runBench(os.Args[2:]); err != nil {
+			log.Fatal().
+				Err(err).
+				Msg("Benchmark failed")
+		}
+		return
+	}
+
+	// Offline benchmark mode measures the speed of the map-tracker matching methods on the shipped maps
+	if os.Args[1] == "bench-match" {
+		if err := runBenchMatch(os.Args[2:]); err != nil {
+			log.Fatal().
+				Err(err).
+				Msg("Matching benchmark failed")
+		}
+		return
+	}
+
+	// Offline tool writing draft walkable masks for map-tracker path planning
+	if os.Args[1] == "walkmask" {
+		if err := runWalkMask(os.Args[2:]); err != nil {
+			log.Fatal().
+				Err(err).
+				Msg("Walkable mask generation failed")
+		}
+		return
 	}
 
 	identifier := os.Args[1]
@@ -30,7 +70,33 @@ func main() {
 		Str("identifier", identifier).
 		Msg("Starting agent server")
 
-	// Initialize MAA framework first (required before any other MAA calls)
+	initMaaFramework()
+	defer maa.Release()
+
+	// Register all custom components and sinks
+	registerAll()
+
+	// Start the agent server
+	if err := maa.AgentServerStartUp(identifier); err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("Failed to start agent server")
+	}
+	log.Info().
+		Msg("Agent server started")
+
+	// Wait for the server to finish
+	maa.AgentServerJoin()
+
+	// Shutdown
+	maa.AgentServerShutDown()
+	log.Info().
+		Msg("Agent server shutdown")
+}
+
+// initMaaFramework initializes MAA framework and toolkit config option.
+// It must be called before any other MAA calls.
+func initMaaFramework() {
 	// MAA DLL 位于工作目录下的 maafw 子目录
 	libDir := filepath.Join(getCwd(), "maafw")
 	log.Info().
@@ -41,7 +107,6 @@ func main() {
 			Err(err).
 			Msg("Failed to initialize MAA framework")
 	}
-	defer maa.Release()
 	log.Info().
 		Msg("MAA framework initialized")
 
@@ -57,26 +122,6 @@ func main() {
 			Str("userPath", userPath).
 			Msg("Toolkit config option initialized")
 	}
-
-	// Register all custom components and sinks
-	registerAll()
-
-	// Start the agent server
-	if err := maa.AgentServerStartUp(identifier); err != nil {
-		log.Fatal().
-			Err(err).
-			Msg("Failed to start agent server")
-	}
-	log.Info().
-		Msg("Agent server started")
-
-	// Wait for the server to finish
-	maa.AgentServerJoin()
-
-	// Shutdown
-	maa.AgentServerShutDown()
-	log.Info().
-		Msg("Agent server shutdown")
 }
 
 func getCwd() string {
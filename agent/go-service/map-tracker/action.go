@@ -0,0 +1,118 @@
+// Copyright (c) 2026 Harry Huang
+package maptracker
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/MaaXYZ/maa-framework-go/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// Waypoint action types
+const (
+	WAYPOINT_ACTION_INTERACT = "interact" // Press the interact key (F)
+	WAYPOINT_ACTION_WAIT     = "wait"     // Wait for a duration
+	WAYPOINT_ACTION_RUN      = "run"      // Run a pipeline node
+	WAYPOINT_ACTION_ASSERT   = "assert"   // Assert the current location
+)
+
+// WaypointAction is an action performed after a waypoint is reached
+type WaypointAction struct {
+	// Type is the kind of the action: "interact", "wait", "run" or "assert".
+	Type string `json:"type"`
+	// Duration is the time in milliseconds to wait ("wait" only).
+	Duration int64 `json:"duration,omitempty"`
+	// Node is the name of the pipeline node to run ("run" only).
+	Node string `json:"node,omitempty"`
+	// Target is the [x, y, w, h] area the player must be in ("assert" only).
+	// If omitted, the player must be within the arrival threshold of the waypoint.
+	Target *[4]int `json:"target,omitempty"`
+}
+
+// validate checks that the action has the fields its type requires
+func (wa *WaypointAction) validate() error {
+	switch wa.Type {
+	case WAYPOINT_ACTION_INTERACT:
+	case WAYPOINT_ACTION_WAIT:
+		if wa.Duration <= 0 {
+			return fmt.Errorf("duration must be positive for %q action", wa.Type)
+		}
+	case WAYPOINT_ACTION_RUN:
+		if wa.Node == "" {
+			return fmt.Errorf("node is required for %q action", wa.Type)
+		}
+	case WAYPOINT_ACTION_ASSERT:
+		if wa.Target != nil && (wa.Target[2] <= 0 || wa.Target[3] <= 0) {
+			return fmt.Errorf("width and height in target must be positive for %q action", wa.Type)
+		}
+	default:
+		return fmt.Errorf("unknown waypoint action type %q", wa.Type)
+	}
+	return nil
+}
+
+// validateWaypointActions checks the actions of all waypoints of a path
+func validateWaypointActions(path []Waypoint) error {
+	for i, w := range path {
+		for j := range w.Actions {
+			if err := w.Actions[j].validate(); err != nil {
+				return fmt.Errorf("waypoint %d, action %d: %w", i, j, err)
+			}
+		}
+	}
+	return nil
+}
+
+// runWaypointActions performs the actions of a reached waypoint in order.
+// Returns an error if an action fails or the task is stopping.
+func runWaypointActions(ctx *maa.Context, ctrl *maa.Controller, mb MovementBackend, param *MapTrackerMoveParam, target Waypoint, targetMap string) error {
+	for i, wa := range target.Actions {
+		if ctx.GetTasker().Stopping() {
+			return fmt.Errorf("task is stopping")
+		}
+		log.Info().Int("index", i).Str("type", wa.Type).Msg("Running waypoint action")
+
+		switch wa.Type {
+		case WAYPOINT_ACTION_INTERACT:
+			mb.Interact(500)
+
+		case WAYPOINT_ACTION_WAIT:
+			deadline := time.Now().Add(time.Duration(wa.Duration) * time.Millisecond)
+			for remaining := time.Until(deadline); remaining > 0; remaining = time.Until(deadline) {
+				if ctx.GetTasker().Stopping() {
+					return fmt.Errorf("task is stopping")
+				}
+				time.Sleep(min(remaining, time.Duration(INFER_INTERVAL_MS)*time.Millisecond))
+			}
+
+		case WAYPOINT_ACTION_RUN:
+			detail, err := ctx.RunTask(wa.Node)
+			if err != nil {
+				return fmt.Errorf("failed to run node %s: %w", wa.Node, err)
+			}
+			if detail == nil || !detail.Status.Success() {
+				return fmt.Errorf("node %s did not succeed", wa.Node)
+			}
+
+		case WAYPOINT_ACTION_ASSERT:
+			result, err := doInfer(ctx, ctrl, param)
+			if err != nil {
+				return fmt.Errorf("failed to infer location for assertion: %w", err)
+			}
+			if result.MapName != targetMap {
+				return fmt.Errorf("location assertion failed, expected map %s, got %s", targetMap, result.MapName)
+			}
+			if wa.Target != nil {
+				x, y, w, h := wa.Target[0], wa.Target[1], wa.Target[2], wa.Target[3]
+				if result.X < x || result.X >= x+w || result.Y < y || result.Y >= y+h {
+					return fmt.Errorf("location assertion failed, [%d, %d] is not in %v", result.X, result.Y, *wa.Target)
+				}
+			} else if dist := math.Hypot(float64(result.X-target.X), float64(result.Y-target.Y)); dist >= param.ArrivalThreshold {
+				return fmt.Errorf("location assertion failed, [%d, %d] is %.1f away from the waypoint", result.X, result.Y, dist)
+			}
+		}
+	}
+	return nil
+}
@@ -13,15 +13,9 @@ import (
 
 type MapTrackerAssertLocation struct{}
 
-// LocationCondition represents a single condition to check
-type LocationCondition struct {
-	MapName string `json:"map_name"`
-	Target  [4]int `json:"target"` // [x, y, w, h]
-}
-
 // MapTrackerAssertLocationParam represents the parameters for AssertLocation
 type MapTrackerAssertLocationParam struct {
-	// Expected is a list of conditions to check, using OR logic.
+	// Expected is a list of conditions to check, using OR logic. See LocationCondition for the clauses of a condition.
 	Expected []LocationCondition `json:"expected"`
 	// Precision controls the inference precision/speed tradeoff.
 	Precision float64 `json:"precision,omitempty"`
@@ -29,6 +23,13 @@ type MapTrackerAssertLocationParam struct {
 	Threshold float64 `json:"threshold,omitempty"`
 	// Whether to enable fast mode for matching.
 	FastMode bool `json:"fast_mode,omitempty"`
+	// Session names the tracking state used for inference.
+	// If omitted, each assertion starts from a fresh tracking state of ASSERT_LOCATION_SESSION.
+	Session string `json:"session,omitempty"`
+	// MinPeakRatio is the minimum peak ratio of the inferred location; ambiguous locations never satisfy the assertion.
+	MinPeakRatio float64 `json:"min_peak_ratio,omitempty"`
+
+	oneShot bool // Whether the session was omitted
 }
 
 var _ maa.CustomRecognitionRunner = &MapTrackerAssertLocation{}
@@ -45,22 +46,24 @@ func (r *MapTrackerAssertLocation) Run(ctx *maa.Context, arg *maa.CustomRecognit
 	mapNameRegex := ".*"
 	if param.FastMode {
 		// Build map_name_regex based on expected conditions to focus the search
-		mapNamesMap := make(map[string]struct{})
-		var mapNames []string
-		for _, condition := range param.Expected {
-			if _, exists := mapNamesMap[condition.MapName]; !exists {
-				mapNamesMap[condition.MapName] = struct{}{}
-				mapNames = append(mapNames, regexp.QuoteMeta(condition.MapName))
-			}
-		}
-		if len(mapNames) == 0 {
+		names, ok := anyMapNames(param.Expected)
+		if !ok || len(names) == 0 {
 			log.Error().Msg("Failed to extract map names from expected conditions")
 			return nil, false
 		}
+		mapNames := make([]string, 0, len(names))
+		for _, name := range names {
+			mapNames = append(mapNames, regexp.QuoteMeta(name))
+		}
 
 		mapNameRegex = "^(" + strings.Join(mapNames, "|") + ")$"
 	}
 
+	// One-shot assertions must not coast on a location tracked before, e.g. on the map before a map change
+	if param.oneShot {
+		getInferState(ctx, param.Session).resetTracking()
+	}
+
 	// Prepare and run MapTrackerInfer
 	nodeName := "MapTrackerAssertLocation_Infer"
 	config := map[string]any{
@@ -71,6 +74,8 @@ func (r *MapTrackerAssertLocation) Run(ctx *maa.Context, arg *maa.CustomRecognit
 				"map_name_regex": mapNameRegex,
 				"precision":      param.Precision,
 				"threshold":      param.Threshold,
+				"session":        param.Session,
+				"min_peak_ratio": param.MinPeakRatio,
 			},
 		},
 	}
@@ -102,20 +107,22 @@ func (r *MapTrackerAssertLocation) Run(ctx *maa.Context, arg *maa.CustomRecognit
 		return nil, false
 	}
 
+	if result.Ambiguous {
+		log.Info().Float64("peakRatio", result.PeakRatio).Msg("Location assertion not satisfied, location is ambiguous")
+		return nil, false
+	}
+
 	// Check if current location satisfies any of the expected conditions
 	for _, condition := range param.Expected {
-		if result.MapName == condition.MapName {
-			x, y, w, h := condition.Target[0], condition.Target[1], condition.Target[2], condition.Target[3]
-			if result.X >= x && result.X < x+w && result.Y >= y && result.Y < y+h {
-				log.Info().
-					Interface("expected", condition).
-					Msg("Location assertion satisfied")
-
-				return &maa.CustomRecognitionResult{
-					Box:    arg.Roi,
-					Detail: res.DetailJson,
-				}, true
-			}
+		if condition.match(&result) {
+			log.Info().
+				Interface("expected", condition).
+				Msg("Location assertion satisfied")
+
+			return &maa.CustomRecognitionResult{
+				Box:    arg.Roi,
+				Detail: res.DetailJson,
+			}, true
 		}
 	}
 
@@ -134,18 +141,30 @@ func (r *MapTrackerAssertLocation) parseParam(paramStr string) (*MapTrackerAsser
 	if len(param.Expected) == 0 {
 		return nil, fmt.Errorf("expected conditions must be provided")
 	}
-	for i, condition := range param.Expected {
-		if condition.MapName == "" {
-			return nil, fmt.Errorf("map_name must be provided for expected condition at index %d", i)
-		}
-		if len(condition.Target) != 4 {
-			return nil, fmt.Errorf("target must have 4 integers [x, y, w, h] for expected condition at index %d", i)
+	for i := range param.Expected {
+		if err := param.Expected[i].validate(); err != nil {
+			return nil, fmt.Errorf("invalid expected condition at index %d: %w", i, err)
 		}
-		if condition.Target[2] <= 0 || condition.Target[3] <= 0 {
-			return nil, fmt.Errorf("width and height in target must be positive for expected condition at index %d", i)
+	}
+	if param.FastMode {
+		if _, ok := anyMapNames(param.Expected); !ok {
+			return nil, fmt.Errorf("fast_mode requires every expected condition to restrict map_name")
 		}
 	}
 	// Precision and Threshold will be validated in MapTrackerInfer, omitted here
 
+	if param.MinPeakRatio < 0 {
+		return nil, fmt.Errorf("min_peak_ratio must be non-negative")
+	} else if param.MinPeakRatio == 0 {
+		param.MinPeakRatio = DEFAULT_ASSERT_LOCATION_PARAM.MinPeakRatio
+	} else if param.MinPeakRatio < 1 {
+		return nil, fmt.Errorf("min_peak_ratio must be at least 1")
+	}
+
+	if param.Session == "" {
+		param.Session = ASSERT_LOCATION_SESSION
+		param.oneShot = true
+	}
+
 	return &param, nil
 }
@@ -0,0 +1,202 @@
+// Copyright (c) 2026 Harry Huang
+package maptracker
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+
+	"github.com/MaaXYZ/maa-framework-go/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// MoveDirection is a movement direction relative to the camera
+type MoveDirection int
+
+const (
+	MOVE_FORWARD MoveDirection = iota
+	MOVE_BACKWARD
+	MOVE_LEFT
+	MOVE_RIGHT
+)
+
+// Movement backend names
+const (
+	BACKEND_AUTO  = "auto"
+	BACKEND_WIN32 = "win32"
+	BACKEND_TOUCH = "touch"
+)
+
+// MovementBackend drives the player's movement and the camera with a specific input method
+type MovementBackend interface {
+	// MoveStart starts moving in the given direction, or keeps moving if already doing so
+	MoveStart(dir MoveDirection, delayMillis int)
+	// MoveStop stops moving
+	MoveStop(delayMillis int)
+	// Sprint performs a sprint
+	Sprint(delayMillis int)
+	// Jump performs a jump
+	Jump(delayMillis int)
+	// Interact performs an interaction (e.g. picking up or talking)
+	Interact(delayMillis int)
+	// RotateCamera rotates the camera horizontally by dx pixels of mouse movement
+	RotateCamera(dx int, durationMillis int, delayMillis int)
+}
+
+// newMovementBackend creates the movement backend with the given name.
+// BACKEND_AUTO selects the backend from the type of the controller.
+func newMovementBackend(aw *ActionWrapper, name string) MovementBackend {
+	if name == BACKEND_AUTO {
+		name = detectMovementBackend(aw.ctrl)
+	}
+	switch name {
+	case BACKEND_TOUCH:
+		return &touchBackend{aw: aw}
+	default:
+		return &win32Backend{aw: aw}
+	}
+}
+
+// detectMovementBackend selects a backend from the controller type: touch for ADB, otherwise Win32
+func detectMovementBackend(ctrl *maa.Controller) string {
+	infoJSON, err := ctrl.GetInfo()
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to get controller info, using Win32 movement backend")
+		return BACKEND_WIN32
+	}
+	var info struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal([]byte(infoJSON), &info); err != nil {
+		log.Warn().Err(err).Msg("Failed to parse controller info, using Win32 movement backend")
+		return BACKEND_WIN32
+	}
+
+	backend := BACKEND_WIN32
+	if strings.Contains(strings.ToLower(info.Type), "adb") {
+		backend = BACKEND_TOUCH
+	}
+	log.Info().Str("controller", info.Type).Str("backend", backend).Msg("Movement backend selected")
+	return backend
+}
+
+/* ******** Win32 Backend ******** */
+
+// win32Backend moves with WASD keys and rotates the camera with the mouse
+type win32Backend struct {
+	aw      *ActionWrapper
+	heldKey int // Movement key currently held, 0 if none
+}
+
+var _ MovementBackend = &win32Backend{}
+
+var win32MoveKeys = map[MoveDirection]int{
+	MOVE_FORWARD:  KEY_W,
+	MOVE_BACKWARD: KEY_S,
+	MOVE_LEFT:     KEY_A,
+	MOVE_RIGHT:    KEY_D,
+}
+
+func (b *win32Backend) MoveStart(dir MoveDirection, delayMillis int) {
+	key := win32MoveKeys[dir]
+	if b.heldKey != 0 && b.heldKey != key {
+		b.aw.KeyUpSync(b.heldKey, 0)
+	}
+	b.heldKey = key
+	b.aw.KeyDownSync(key, delayMillis)
+}
+
+func (b *win32Backend) MoveStop(delayMillis int) {
+	key := b.heldKey
+	if key == 0 {
+		key = KEY_W
+	}
+	b.heldKey = 0
+	b.aw.KeyUpSync(key, delayMillis)
+}
+
+func (b *win32Backend) Sprint(delayMillis int) {
+	b.aw.KeyTypeSync(KEY_SHIFT, delayMillis)
+}
+
+func (b *win32Backend) Jump(delayMillis int) {
+	b.aw.KeyTypeSync(KEY_SPACE, delayMillis)
+}
+
+func (b *win32Backend) Interact(delayMillis int) {
+	b.aw.KeyTypeSync(KEY_F, delayMillis)
+}
+
+func (b *win32Backend) RotateCamera(dx int, durationMillis int, delayMillis int) {
+	b.aw.RotateCamera(dx, durationMillis, delayMillis)
+}
+
+/* ******** Touch Backend ******** */
+
+// touchBackend moves with a virtual joystick drag and rotates the camera
+// by swiping on the right half of the screen
+type touchBackend struct {
+	aw     *ActionWrapper
+	moving bool
+	dir    MoveDirection
+}
+
+var _ MovementBackend = &touchBackend{}
+
+// joystickOffset returns the joystick drag offset for the given direction
+func joystickOffset(dir MoveDirection) (int, int) {
+	switch dir {
+	case MOVE_BACKWARD:
+		return 0, TOUCH_JOYSTICK_RADIUS
+	case MOVE_LEFT:
+		return -TOUCH_JOYSTICK_RADIUS, 0
+	case MOVE_RIGHT:
+		return TOUCH_JOYSTICK_RADIUS, 0
+	default:
+		return 0, -TOUCH_JOYSTICK_RADIUS
+	}
+}
+
+func (b *touchBackend) MoveStart(dir MoveDirection, delayMillis int) {
+	if b.moving && b.dir == dir {
+		return
+	}
+	dx, dy := joystickOffset(dir)
+	if !b.moving {
+		b.aw.TouchDownSync(TOUCH_JOYSTICK_CONTACT, TOUCH_JOYSTICK_X, TOUCH_JOYSTICK_Y, 50)
+	}
+	b.aw.TouchMoveSync(TOUCH_JOYSTICK_CONTACT, TOUCH_JOYSTICK_X+dx, TOUCH_JOYSTICK_Y+dy, delayMillis)
+	b.moving, b.dir = true, dir
+}
+
+func (b *touchBackend) MoveStop(delayMillis int) {
+	b.moving = false
+	b.aw.TouchUpSync(TOUCH_JOYSTICK_CONTACT, delayMillis)
+}
+
+func (b *touchBackend) Sprint(delayMillis int) {
+	b.aw.ClickSync(TOUCH_BUTTON_CONTACT, TOUCH_SPRINT_X, TOUCH_SPRINT_Y, 50)
+	b.aw.Sleep(delayMillis)
+}
+
+func (b *touchBackend) Jump(delayMillis int) {
+	b.aw.ClickSync(TOUCH_BUTTON_CONTACT, TOUCH_JUMP_X, TOUCH_JUMP_Y, 50)
+	b.aw.Sleep(delayMillis)
+}
+
+func (b *touchBackend) Interact(delayMillis int) {
+	b.aw.ClickSync(TOUCH_BUTTON_CONTACT, TOUCH_INTERACT_X, TOUCH_INTERACT_Y, 50)
+	b.aw.Sleep(delayMillis)
+}
+
+func (b *touchBackend) RotateCamera(dx int, durationMillis int, delayMillis int) {
+	// Split long rotations into several swipes that fit in the right half of the screen
+	remaining := int(math.Round(float64(dx) * TOUCH_CAMERA_SCALE))
+	for remaining != 0 {
+		step := max(-TOUCH_CAMERA_MAX_SWIPE, min(TOUCH_CAMERA_MAX_SWIPE, remaining))
+		x := TOUCH_CAMERA_X - step/2
+		b.aw.TouchSwipeSync(TOUCH_CAMERA_CONTACT, x, TOUCH_CAMERA_Y, x+step, TOUCH_CAMERA_Y, durationMillis)
+		remaining -= step
+	}
+	b.aw.Sleep(delayMillis)
+}
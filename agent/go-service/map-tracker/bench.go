@@ -0,0 +1,300 @@
+// Copyright (c) 2026 Harry Huang
+package maptracker
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/rand/v2"
+	"os"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/MaaXYZ/MaaEnd/agent/go-service/pkg/minicv"
+	"github.com/rs/zerolog/log"
+)
+
+// BenchSample is a screenshot with its ground-truth location and rotation
+type BenchSample struct {
+	Image   string // Screenshot file path
+	MapName string // Ground-truth map name
+	X, Y    int    // Ground-truth location on the map
+	Rot     int    // Ground-truth rotation in degrees
+}
+
+// BenchOptions controls an offline accuracy benchmark
+type BenchOptions struct {
+	// Precisions are the inference precision values to benchmark.
+	Precisions []float64
+	// Threshold is the minimum confidence for an inference to count.
+	Threshold float64
+	// MapNameRegex filters which maps to consider during inference.
+	MapNameRegex string
+	// HitRadius is the maximum location error in pixels for a location to count as a hit.
+	HitRadius float64
+	// Pyramid enables coarse-to-fine matching in the full search.
+	Pyramid bool
+}
+
+// BenchStats holds the benchmark statistics of one map at one precision.
+// MapName is "*" for the statistics over all maps.
+type BenchStats struct {
+	Precision float64 `json:"precision"`
+	MapName   string  `json:"mapName"`
+	Samples   int     `json:"samples"`
+
+	LocHits     int     `json:"locHits"`     // Locations on the right map within the hit radius
+	LocHitRate  float64 `json:"locHitRate"`  // LocHits / Samples
+	LocErrorPx  float64 `json:"locErrorPx"`  // Mean location error in px, over locations on the right map
+	RotHits     int     `json:"rotHits"`     // Rotations above the confidence threshold
+	RotErrorDeg float64 `json:"rotErrorDeg"` // Mean rotation error in degrees, over RotHits
+	LocTimeMs   float64 `json:"locTimeMs"`   // Mean location inference time in ms
+	RotTimeMs   float64 `json:"rotTimeMs"`   // Mean rotation inference time in ms
+
+	locErrorCount int
+}
+
+// RunBenchmark runs location and rotation inference on each sample at each precision,
+// and returns the statistics per precision and per ground-truth map.
+// Every sample is inferred with a fresh tracking state, so that samples do not affect each other.
+func RunBenchmark(samples []BenchSample, opts BenchOptions) ([]BenchStats, error) {
+	mapNameRegex, err := regexp.Compile(opts.MapNameRegex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid map name regex: %w", err)
+	}
+
+	infer := &MapTrackerInfer{}
+	infer.initMaps(nil)
+	infer.initPointer(nil)
+	if infer.mapsErr != nil {
+		return nil, fmt.Errorf("failed to initialize maps: %w", infer.mapsErr)
+	}
+	if infer.pointerErr != nil {
+		return nil, fmt.Errorf("failed to initialize pointer: %w", infer.pointerErr)
+	}
+
+	result := make([]BenchStats, 0)
+	for _, precision := range opts.Precisions {
+		param := &MapTrackerInferParam{
+			MapNameRegex: opts.MapNameRegex,
+			Precision:    precision,
+			Threshold:    opts.Threshold,
+			Pyramid:      opts.Pyramid,
+		}
+		rotStep := rotStepForPrecision(precision)
+
+		statsByMap := make(map[string]*BenchStats)
+		total := &BenchStats{Precision: precision, MapName: "*"}
+		for _, sample := range samples {
+			img, err := loadBenchImage(sample.Image)
+			if err != nil {
+				return nil, err
+			}
+			screenImg := minicv.ImageConvertRGBA(img)
+			state := &InferState{}
+			geom := state.minimapGeometry(screenImg)
+			loc := infer.inferLocation(screenImg, geom, mapNameRegex, param, state)
+			rot := infer.inferRotation(screenImg, geom, rotStep)
+
+			stats, ok := statsByMap[sample.MapName]
+			if !ok {
+				stats = &BenchStats{Precision: precision, MapName: sample.MapName}
+				statsByMap[sample.MapName] = stats
+			}
+			for _, s := range []*BenchStats{stats, total} {
+				s.add(sample, loc, rot, opts)
+			}
+		}
+		log.Info().Float64("precision", precision).Int("samples", len(samples)).Msg("Benchmark precision finished")
+
+		mapNames := make([]string, 0, len(statsByMap))
+		for name := range statsByMap {
+			mapNames = append(mapNames, name)
+		}
+		slices.Sort(mapNames)
+		for _, name := range mapNames {
+			result = append(result, statsByMap[name].finish())
+		}
+		result = append(result, total.finish())
+	}
+	return result, nil
+}
+
+// loadBenchImage loads a screenshot of a benchmark sample
+func loadBenchImage(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open screenshot: %w", err)
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode screenshot %s: %w", path, err)
+	}
+	return img, nil
+}
+
+// add accumulates the inference results of one sample
+func (s *BenchStats) add(sample BenchSample, loc *InferLocationRawResult, rot *InferRotationRawResult, opts BenchOptions) {
+	s.Samples++
+	if loc != nil {
+		s.LocTimeMs += float64(loc.elapsedTimeMs)
+		if loc.conf > opts.Threshold && loc.mapName == sample.MapName {
+			dist := math.Hypot(float64(loc.x-sample.X), float64(loc.y-sample.Y))
+			s.LocErrorPx += dist
+			s.locErrorCount++
+			if dist <= opts.HitRadius {
+				s.LocHits++
+			}
+		}
+	}
+	if rot != nil {
+		s.RotTimeMs += float64(rot.elapsedTimeMs)
+		if rot.conf > opts.Threshold {
+			s.RotErrorDeg += math.Abs(normalizeDeltaAngle(rot.rot - float64(sample.Rot)))
+			s.RotHits++
+		}
+	}
+}
+
+// finish turns the accumulated sums into means
+func (s *BenchStats) finish() BenchStats {
+	if s.Samples > 0 {
+		s.LocHitRate = float64(s.LocHits) / float64(s.Samples)
+		s.LocTimeMs /= float64(s.Samples)
+		s.RotTimeMs /= float64(s.Samples)
+	}
+	if s.locErrorCount > 0 {
+		s.LocErrorPx /= float64(s.locErrorCount)
+	}
+	if s.RotHits > 0 {
+		s.RotErrorDeg /= float64(s.RotHits)
+	}
+	return *s
+}
+
+// MatchBenchOptions controls a template matching speed benchmark
+type MatchBenchOptions struct {
+	// Precisions are the inference precision values to benchmark, which set the scale of the maps.
+	Precisions []float64
+	// MapNameRegex filters which maps to benchmark.
+	MapNameRegex string
+	// Needles is the number of minimap-sized needles cut from each map.
+	Needles int
+}
+
+// MatchBenchStats holds the timing of the full search matching methods on one map at one precision.
+// MapName is "*" for the statistics over all maps.
+type MatchBenchStats struct {
+	Precision float64 `json:"precision"`
+	MapName   string  `json:"mapName"`
+	Width     int     `json:"width"`  // Width of the scaled map
+	Height    int     `json:"height"` // Height of the scaled map
+	Needles   int     `json:"needles"`
+
+	SpatialMs float64 `json:"spatialMs"` // Mean time of the spatial method in ms
+	FFTMs     float64 `json:"fftMs"`     // Mean time of the FFT method in ms
+	Speedup   float64 `json:"speedup"`   // SpatialMs / FFTMs
+	Auto      string  `json:"auto"`      // Method picked automatically for this map, "" for "*"
+	AutoMs    float64 `json:"autoMs"`    // Mean time of the automatically picked method in ms
+	Agreement float64 `json:"agreement"` // Part of the needles for which both methods find the same best position
+}
+
+// RunMatchBenchmark times the spatial and FFT matching methods of the full search on the maps,
+// using needles cut from the maps themselves, and checks that both methods agree on the best position.
+func RunMatchBenchmark(opts MatchBenchOptions) ([]MatchBenchStats, error) {
+	mapNameRegex, err := regexp.Compile(opts.MapNameRegex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid map name regex: %w", err)
+	}
+
+	infer := &MapTrackerInfer{}
+	infer.initMaps(nil)
+	if infer.mapsErr != nil {
+		return nil, fmt.Errorf("failed to initialize maps: %w", infer.mapsErr)
+	}
+
+	result := make([]MatchBenchStats, 0)
+	for _, precision := range opts.Precisions {
+		scale := precision
+		radius := max(int(LOC_RADIUS*scale), 1)
+		suppressRadius := max(int(LOC_PEAK_SUPPRESS_RADIUS*scale), 1)
+		mask := minicv.NewCircleMask(2*radius+1, 2*radius+1)
+		total := MatchBenchStats{Precision: precision, MapName: "*"}
+		agreed := 0
+
+		for _, m := range infer.getScaledMaps(scale) {
+			if !mapNameRegex.MatchString(m.Name) {
+				continue
+			}
+			w, h := m.Img.Rect.Dx(), m.Img.Rect.Dy()
+			if w <= 2*radius+1 || h <= 2*radius+1 {
+				continue
+			}
+			stats := MatchBenchStats{Precision: precision, MapName: m.Name, Width: w, Height: h}
+			stats.Auto = resolveMatchMethod(MATCH_METHOD_AUTO, w, h, 2*radius+1, 2*radius+1, 3, mask)
+
+			// The same needles in every run, avoiding flat areas outside the playable area
+			rng := rand.New(rand.NewPCG(uint64(w), uint64(h)))
+			for tries := 0; stats.Needles < opts.Needles && tries < opts.Needles*20; tries++ {
+				cx, cy := radius+rng.IntN(w-2*radius), radius+rng.IntN(h-2*radius)
+				needle := minicv.ImageCropSquareByRadius(m.Img, cx, cy, radius)
+				if isMinimapBlank(needle) {
+					continue
+				}
+				nStats := minicv.GetMaskedImageStats(needle, mask)
+
+				t0 := time.Now()
+				spatial := matchTemplateTopK(m.Img, m.Integral, needle, mask, nStats, LOC_TOP_K, suppressRadius, MATCH_METHOD_SPATIAL)
+				spatialMs := float64(time.Since(t0).Microseconds()) / 1000
+				t0 = time.Now()
+				fft := matchTemplateTopK(m.Img, m.Integral, needle, mask, nStats, LOC_TOP_K, suppressRadius, MATCH_METHOD_FFT)
+				fftMs := float64(time.Since(t0).Microseconds()) / 1000
+
+				stats.Needles++
+				stats.SpatialMs += spatialMs
+				stats.FFTMs += fftMs
+				if stats.Auto == MATCH_METHOD_FFT {
+					stats.AutoMs += fftMs
+				} else {
+					stats.AutoMs += spatialMs
+				}
+				if len(spatial) > 0 && len(fft) > 0 && spatial[0].X == fft[0].X && spatial[0].Y == fft[0].Y {
+					stats.Agreement++
+				}
+			}
+			if stats.Needles == 0 {
+				continue
+			}
+
+			total.Needles += stats.Needles
+			total.SpatialMs += stats.SpatialMs
+			total.FFTMs += stats.FFTMs
+			total.AutoMs += stats.AutoMs
+			agreed += int(stats.Agreement)
+			result = append(result, stats.finish())
+		}
+		log.Info().Float64("precision", precision).Int("needles", total.Needles).Msg("Match benchmark precision finished")
+
+		total.Agreement = float64(agreed)
+		total.Auto = ""
+		result = append(result, total.finish())
+	}
+	return result, nil
+}
+
+// finish turns the accumulated sums into means
+func (s *MatchBenchStats) finish() MatchBenchStats {
+	if s.Needles > 0 {
+		s.SpatialMs /= float64(s.Needles)
+		s.FFTMs /= float64(s.Needles)
+		s.AutoMs /= float64(s.Needles)
+		s.Agreement /= float64(s.Needles)
+	}
+	if s.FFTMs > 0 {
+		s.Speedup = s.SpatialMs / s.FFTMs
+	}
+	return *s
+}
@@ -0,0 +1,394 @@
+// Copyright (c) 2026 Harry Huang
+package maptracker
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/MaaXYZ/MaaEnd/agent/go-service/pkg/maafocus"
+	"github.com/MaaXYZ/maa-framework-go/v4"
+	"github.com/rs/zerolog/log"
+)
+
+type MapTrackerCalibrate struct{}
+
+// MapTrackerCalibrateParam represents the custom_action_param for MapTrackerCalibrate
+type MapTrackerCalibrateParam struct {
+	// Swipes are the camera rotation amounts in pixels to measure, in ascending order.
+	Swipes []int `json:"swipes,omitempty"`
+	// Repeats is the number of measurements per swipe amount and direction.
+	Repeats int `json:"repeats,omitempty"`
+	// SettleDelay is the time in milliseconds to wait after each rotation before measuring.
+	SettleDelay int64 `json:"settle_delay,omitempty"`
+	// Backend selects the input method to calibrate: "auto", "win32" or "touch".
+	Backend string `json:"backend,omitempty"`
+	// NoPrint controls whether to suppress printing calibration status to the GUI.
+	NoPrint bool `json:"no_print,omitempty"`
+	// Session names the tracking state used during calibration.
+	Session string `json:"session,omitempty"`
+}
+
+// RotationCalibration maps camera rotation angles to rotation pixels for one controller and backend
+type RotationCalibration struct {
+	// PixelsPerDegree is the least-squares linear fit of the measurements.
+	PixelsPerDegree float64 `json:"pixelsPerDegree"`
+	// Points are measured [degrees, pixels] pairs in ascending order, used to follow non-linear responses.
+	Points [][2]float64 `json:"points"`
+	// UpdatedAt is the time of the calibration.
+	UpdatedAt string `json:"updatedAt"`
+}
+
+// calibrationFile is the file format of the persisted calibrations
+type calibrationFile struct {
+	Version     int                            `json:"version"`
+	Controllers map[string]RotationCalibration `json:"controllers"` // Keyed by calibrationKey
+}
+
+// calibrationFileMu serializes reads and writes of the calibration file
+var calibrationFileMu sync.Mutex
+
+//go:embed messages/calibration_finished.html
+var calibrationFinishedHTML string
+
+var _ maa.CustomActionRunner = &MapTrackerCalibrate{}
+
+// Run implements maa.CustomActionRunner
+func (a *MapTrackerCalibrate) Run(ctx *maa.Context, arg *maa.CustomActionArg) bool {
+	param, err := a.parseParam(arg.CustomActionParam)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to parse parameters for MapTrackerCalibrate")
+		return false
+	}
+
+	ctrl := ctx.GetTasker().GetController()
+	aw := NewActionWrapper(ctx, ctrl)
+	if param.Backend == BACKEND_AUTO {
+		param.Backend = detectMovementBackend(ctrl)
+	}
+	mb := newMovementBackend(aw, param.Backend)
+	log.Info().Ints("swipes", param.Swipes).Int("repeats", param.Repeats).Str("backend", param.Backend).Msg("Camera rotation calibration started")
+
+	// Measure each swipe amount in both directions, so that the camera ends up where it started
+	samples := make(map[int][]float64)
+	pixelsPerDegree := 0.0
+	for _, swipe := range param.Swipes {
+		// Skip swipes that may rotate more than half a turn, since their angle cannot be told apart
+		if pixelsPerDegree > 0 && float64(swipe)/pixelsPerDegree > CALIBRATION_MAX_DEGREES {
+			log.Info().Int("swipe", swipe).Float64("pixelsPerDegree", pixelsPerDegree).Msg("Swipe would rotate too far, skipped")
+			continue
+		}
+		for range param.Repeats {
+			for _, sign := range []int{1, -1} {
+				if ctx.GetTasker().Stopping() {
+					log.Warn().Msg("Task is stopping, aborting calibration")
+					return false
+				}
+				deg, err := measureRotation(ctx, ctrl, mb, param, sign*swipe)
+				if err != nil {
+					log.Warn().Err(err).Int("swipe", sign*swipe).Msg("Rotation measurement failed")
+					continue
+				}
+				// Rotations the other way, or too small to measure, do not fit the model
+				if deg*float64(sign) < CALIBRATION_MIN_DEGREES {
+					log.Warn().Int("swipe", sign*swipe).Float64("deg", deg).Msg("Rotation measurement discarded")
+					continue
+				}
+				samples[swipe] = append(samples[swipe], math.Abs(deg))
+			}
+		}
+		if cal, err := fitRotationCalibration(samples); err == nil {
+			pixelsPerDegree = cal.PixelsPerDegree
+		}
+	}
+
+	cal, err := fitRotationCalibration(samples)
+	if err != nil {
+		log.Error().Err(err).Msg("Camera rotation calibration failed")
+		return false
+	}
+	key := calibrationKey(ctrl, param.Backend)
+	if err := saveRotationCalibration(key, cal); err != nil {
+		log.Error().Err(err).Msg("Failed to save camera rotation calibration")
+		return false
+	}
+
+	log.Info().Str("key", key).Float64("pixelsPerDegree", cal.PixelsPerDegree).Interface("points", cal.Points).
+		Msg("Camera rotation calibration finished")
+	if !param.NoPrint {
+		maafocus.NodeActionStarting(ctx, fmt.Sprintf(calibrationFinishedHTML, cal.PixelsPerDegree, len(cal.Points)))
+	}
+	return true
+}
+
+func (a *MapTrackerCalibrate) parseParam(paramStr string) (*MapTrackerCalibrateParam, error) {
+	var param MapTrackerCalibrateParam
+	if paramStr != "" {
+		if err := json.Unmarshal([]byte(paramStr), &param); err != nil {
+			return nil, fmt.Errorf("failed to parse parameters: %w", err)
+		}
+	}
+
+	if param.Swipes == nil {
+		param.Swipes = DEFAULT_CALIBRATE_PARAM.Swipes
+	}
+	if len(param.Swipes) == 0 {
+		return nil, fmt.Errorf("swipes must not be empty")
+	}
+	for _, swipe := range param.Swipes {
+		if swipe <= 0 {
+			return nil, fmt.Errorf("swipes must be positive")
+		}
+	}
+	param.Swipes = slices.Clone(param.Swipes)
+	slices.Sort(param.Swipes)
+
+	if param.Repeats < 0 {
+		return nil, fmt.Errorf("repeats must be non-negative")
+	} else if param.Repeats == 0 {
+		param.Repeats = DEFAULT_CALIBRATE_PARAM.Repeats
+	}
+
+	if param.SettleDelay < 0 {
+		return nil, fmt.Errorf("settle_delay must be non-negative")
+	} else if param.SettleDelay == 0 {
+		param.SettleDelay = DEFAULT_CALIBRATE_PARAM.SettleDelay
+	}
+
+	switch param.Backend {
+	case "":
+		param.Backend = BACKEND_AUTO
+	case BACKEND_AUTO, BACKEND_WIN32, BACKEND_TOUCH:
+	default:
+		return nil, fmt.Errorf("unknown backend %q, must be one of auto, win32 or touch", param.Backend)
+	}
+
+	if param.Session == "" {
+		param.Session = CALIBRATE_SESSION
+	}
+
+	return &param, nil
+}
+
+// measureRotation rotates the camera by the given pixels and returns the measured rotation in degrees.
+// The player takes a short step before each reading, so that the pointer follows the camera.
+func measureRotation(ctx *maa.Context, ctrl *maa.Controller, mb MovementBackend, param *MapTrackerCalibrateParam, dx int) (float64, error) {
+	step := func() {
+		mb.MoveStart(MOVE_FORWARD, CALIBRATION_STEP_MS)
+		mb.MoveStop(int(param.SettleDelay))
+	}
+	read := func() (int, error) {
+		// Start over every time, so that the heading filter does not smooth the rotation away
+		resetInferStates(ctx, param.Session, false)
+		result, err := runInfer(ctx, ctrl, "MapTrackerCalibrate_Infer", map[string]any{
+			"map_name_regex": DEFAULT_INFERENCE_PARAM.MapNameRegex,
+			"precision":      DEFAULT_INFERENCE_PARAM_FOR_MOVE.Precision,
+			"threshold":      DEFAULT_INFERENCE_PARAM_FOR_MOVE.Threshold,
+			"session":        param.Session,
+		})
+		if err != nil {
+			return 0, err
+		}
+		return result.Rot, nil
+	}
+
+	step()
+	before, err := read()
+	if err != nil {
+		return 0, err
+	}
+	mb.RotateCamera(dx, 100, 100)
+	step()
+	after, err := read()
+	if err != nil {
+		return 0, err
+	}
+
+	deg := float64(calcDeltaRotation(before, after))
+	log.Debug().Int("swipe", dx).Int("before", before).Int("after", after).Float64("deg", deg).Msg("Rotation measured")
+	return deg, nil
+}
+
+// fitRotationCalibration fits a calibration to the measured degrees of each swipe amount
+func fitRotationCalibration(samples map[int][]float64) (*RotationCalibration, error) {
+	swipes := make([]int, 0, len(samples))
+	for swipe, degs := range samples {
+		if len(degs) > 0 {
+			swipes = append(swipes, swipe)
+		}
+	}
+	slices.Sort(swipes)
+	if len(swipes) < 2 {
+		return nil, fmt.Errorf("too few successful measurements, got %d swipe amounts, need at least 2", len(swipes))
+	}
+
+	// One point per swipe amount, at the median of its measurements.
+	// The response must grow with the swipe, so points that do not are dropped.
+	points := make([][2]float64, 0, len(swipes))
+	for _, swipe := range swipes {
+		degs := slices.Clone(samples[swipe])
+		slices.Sort(degs)
+		deg := degs[len(degs)/2]
+		if len(degs)%2 == 0 {
+			deg = (degs[len(degs)/2-1] + degs[len(degs)/2]) / 2
+		}
+		if len(points) > 0 && deg <= points[len(points)-1][0] {
+			log.Warn().Int("swipe", swipe).Float64("deg", deg).Msg("Non-monotonic rotation response, point dropped")
+			continue
+		}
+		points = append(points, [2]float64{deg, float64(swipe)})
+	}
+	if len(points) < 2 {
+		return nil, fmt.Errorf("rotation response is not monotonic")
+	}
+
+	// Least-squares slope through the origin
+	var sxy, sxx float64
+	for _, p := range points {
+		sxy += p[0] * p[1]
+		sxx += p[0] * p[0]
+	}
+
+	return &RotationCalibration{
+		PixelsPerDegree: sxy / sxx,
+		Points:          points,
+		UpdatedAt:       time.Now().Format(time.RFC3339),
+	}, nil
+}
+
+// pixels returns the rotation pixels for the given angle in degrees,
+// interpolating between the measured points and extrapolating along the outer segments
+func (c *RotationCalibration) pixels(deg float64) int {
+	sign := 1.0
+	if deg < 0 {
+		sign, deg = -1.0, -deg
+	}
+	pts := c.Points
+	if len(pts) < 2 {
+		return int(math.Round(sign * deg * c.PixelsPerDegree))
+	}
+
+	var px float64
+	switch {
+	case deg <= pts[0][0]:
+		px = deg * pts[0][1] / pts[0][0]
+	case deg >= pts[len(pts)-1][0]:
+		a, b := pts[len(pts)-2], pts[len(pts)-1]
+		px = b[1] + (deg-b[0])*(b[1]-a[1])/(b[0]-a[0])
+	default:
+		i := 1
+		for pts[i][0] < deg {
+			i++
+		}
+		a, b := pts[i-1], pts[i]
+		px = a[1] + (deg-a[0])*(b[1]-a[1])/(b[0]-a[0])
+	}
+	return int(math.Round(sign * px))
+}
+
+// calibrationKey identifies a controller and movement backend in the calibration file
+func calibrationKey(ctrl *maa.Controller, backend string) string {
+	uuid, err := ctrl.GetUUID()
+	if err != nil || uuid == "" {
+		log.Warn().Err(err).Msg("Failed to get controller UUID, using a shared calibration")
+		uuid = "default"
+	}
+	return backend + "/" + uuid
+}
+
+// loadCalibrationFile reads the calibration file, returning an empty one if it does not exist
+func loadCalibrationFile() (*calibrationFile, error) {
+	file := &calibrationFile{Version: CALIBRATION_VERSION, Controllers: make(map[string]RotationCalibration)}
+	data, err := os.ReadFile(CALIBRATION_PATH)
+	if os.IsNotExist(err) {
+		return file, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read calibration file: %w", err)
+	}
+	if err := json.Unmarshal(data, file); err != nil {
+		return nil, fmt.Errorf("failed to parse calibration file: %w", err)
+	}
+	if file.Version != CALIBRATION_VERSION {
+		return nil, fmt.Errorf("unsupported calibration file version %d", file.Version)
+	}
+	if file.Controllers == nil {
+		file.Controllers = make(map[string]RotationCalibration)
+	}
+	return file, nil
+}
+
+// saveRotationCalibration stores the calibration of the given key, keeping those of other controllers
+func saveRotationCalibration(key string, cal *RotationCalibration) error {
+	calibrationFileMu.Lock()
+	defer calibrationFileMu.Unlock()
+
+	file, err := loadCalibrationFile()
+	if err != nil {
+		log.Warn().Err(err).Msg("Existing calibration file is discarded")
+		file = &calibrationFile{Version: CALIBRATION_VERSION, Controllers: make(map[string]RotationCalibration)}
+	}
+	file.Controllers[key] = *cal
+
+	if err := os.MkdirAll(filepath.Dir(CALIBRATION_PATH), 0755); err != nil {
+		return fmt.Errorf("failed to create calibration directory: %w", err)
+	}
+	data, err := json.MarshalIndent(file, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal calibration file: %w", err)
+	}
+	if err := os.WriteFile(CALIBRATION_PATH, data, 0644); err != nil {
+		return fmt.Errorf("failed to write calibration file: %w", err)
+	}
+	return nil
+}
+
+// getRotationCalibration returns the persisted calibration of the given key, or nil if there is none
+func getRotationCalibration(key string) *RotationCalibration {
+	calibrationFileMu.Lock()
+	defer calibrationFileMu.Unlock()
+
+	file, err := loadCalibrationFile()
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to load calibration file")
+		return nil
+	}
+	cal, ok := file.Controllers[key]
+	if !ok || len(cal.Points) == 0 || cal.PixelsPerDegree <= 0 {
+		return nil
+	}
+	return &cal
+}
+
+// rotationModel converts camera rotation angles to rotation pixels
+type rotationModel struct {
+	speed float64              // Pixels per degree, used without calibration
+	cal   *RotationCalibration // Calibration, nil if not used
+}
+
+// newRotationModel uses the explicit rotation speed if given, then the calibration of the controller, then the default speed
+func newRotationModel(ctrl *maa.Controller, backend string, speed float64) *rotationModel {
+	if speed > 0 {
+		return &rotationModel{speed: speed}
+	}
+	key := calibrationKey(ctrl, backend)
+	if cal := getRotationCalibration(key); cal != nil {
+		log.Info().Str("key", key).Float64("pixelsPerDegree", cal.PixelsPerDegree).Str("updatedAt", cal.UpdatedAt).
+			Msg("Using camera rotation calibration")
+		return &rotationModel{cal: cal}
+	}
+	return &rotationModel{speed: DEFAULT_MOVING_PARAM.RotationSpeed}
+}
+
+// pixels returns the rotation pixels for the given angle in degrees
+func (m *rotationModel) pixels(deg float64) int {
+	if m.cal != nil {
+		return m.cal.pixels(deg)
+	}
+	return int(deg * m.speed)
+}
@@ -0,0 +1,187 @@
+// Copyright (c) 2026 Harry Huang
+package maptracker
+
+import (
+	"fmt"
+	"math"
+	"slices"
+)
+
+// LocationCondition represents a condition on the player's location and orientation.
+// All clauses present in a condition must hold for it to be satisfied.
+type LocationCondition struct {
+	// MapName is the name of the map the player must be on.
+	MapName string `json:"map_name,omitempty"`
+	// Target is an [x, y, w, h] rectangle the player must be in.
+	Target *[4]int `json:"target,omitempty"`
+	// Polygon is a list of [x, y] vertices of a polygon the player must be in.
+	Polygon [][2]int `json:"polygon,omitempty"`
+	// Circle is an [x, y, r] circle the player must be in.
+	Circle *[3]int `json:"circle,omitempty"`
+	// Rot is a [from, to] heading range in degrees, going clockwise from "from" to "to".
+	Rot *[2]int `json:"rot,omitempty"`
+	// Not is a condition that must not hold.
+	Not *LocationCondition `json:"not,omitempty"`
+	// All is a list of conditions that must all hold.
+	All []LocationCondition `json:"all,omitempty"`
+	// Any is a list of conditions of which at least one must hold.
+	Any []LocationCondition `json:"any,omitempty"`
+}
+
+// validate checks the condition and its sub-conditions
+func (c *LocationCondition) validate() error {
+	areas := 0
+	if c.Target != nil {
+		if c.Target[2] <= 0 || c.Target[3] <= 0 {
+			return fmt.Errorf("width and height in target must be positive")
+		}
+		areas++
+	}
+	if c.Polygon != nil {
+		if len(c.Polygon) < 3 {
+			return fmt.Errorf("polygon must have at least 3 vertices")
+		}
+		areas++
+	}
+	if c.Circle != nil {
+		if c.Circle[2] <= 0 {
+			return fmt.Errorf("radius in circle must be positive")
+		}
+		areas++
+	}
+	if areas > 1 {
+		return fmt.Errorf("target, polygon and circle are mutually exclusive")
+	}
+	if areas > 0 && c.MapName == "" {
+		return fmt.Errorf("map_name must be provided with target, polygon or circle")
+	}
+
+	if c.Rot != nil {
+		for _, v := range c.Rot {
+			if v < 0 || v >= 360 {
+				return fmt.Errorf("rot must be in [0, 360), got %v", *c.Rot)
+			}
+		}
+	}
+
+	if c.MapName == "" && areas == 0 && c.Rot == nil && c.Not == nil && c.All == nil && c.Any == nil {
+		return fmt.Errorf("condition is empty")
+	}
+
+	if c.Not != nil {
+		if err := c.Not.validate(); err != nil {
+			return fmt.Errorf("not: %w", err)
+		}
+	}
+	for i := range c.All {
+		if err := c.All[i].validate(); err != nil {
+			return fmt.Errorf("all[%d]: %w", i, err)
+		}
+	}
+	if c.Any != nil && len(c.Any) == 0 {
+		return fmt.Errorf("any must not be empty")
+	}
+	for i := range c.Any {
+		if err := c.Any[i].validate(); err != nil {
+			return fmt.Errorf("any[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// match reports whether the inference result satisfies the condition
+func (c *LocationCondition) match(result *MapTrackerInferResult) bool {
+	if c.MapName != "" && result.MapName != c.MapName {
+		return false
+	}
+	if c.Target != nil {
+		x, y, w, h := c.Target[0], c.Target[1], c.Target[2], c.Target[3]
+		if result.X < x || result.X >= x+w || result.Y < y || result.Y >= y+h {
+			return false
+		}
+	}
+	if c.Polygon != nil && !pointInPolygon(result.X, result.Y, c.Polygon) {
+		return false
+	}
+	if c.Circle != nil {
+		dist := math.Hypot(float64(result.X-c.Circle[0]), float64(result.Y-c.Circle[1]))
+		if dist > float64(c.Circle[2]) {
+			return false
+		}
+	}
+	if c.Rot != nil && !rotInRange(result.Rot, c.Rot[0], c.Rot[1]) {
+		return false
+	}
+	if c.Not != nil && c.Not.match(result) {
+		return false
+	}
+	for i := range c.All {
+		if !c.All[i].match(result) {
+			return false
+		}
+	}
+	if len(c.Any) > 0 && !slices.ContainsFunc(c.Any, func(sub LocationCondition) bool { return sub.match(result) }) {
+		return false
+	}
+	return true
+}
+
+// mapNames returns the maps a location must be on to satisfy the condition.
+// Returns false if the condition can be satisfied on any map.
+func (c *LocationCondition) mapNames() ([]string, bool) {
+	if c.MapName != "" {
+		return []string{c.MapName}, true
+	}
+	// Any map constraint of an AND group constrains the whole group
+	for i := range c.All {
+		if names, ok := c.All[i].mapNames(); ok {
+			return names, true
+		}
+	}
+	if len(c.Any) > 0 {
+		return anyMapNames(c.Any)
+	}
+	return nil, false
+}
+
+// anyMapNames returns the maps a location must be on to satisfy at least one of the conditions.
+// Returns false if any condition can be satisfied on any map.
+func anyMapNames(conditions []LocationCondition) ([]string, bool) {
+	names := make([]string, 0)
+	for i := range conditions {
+		sub, ok := conditions[i].mapNames()
+		if !ok {
+			return nil, false
+		}
+		for _, name := range sub {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	return names, true
+}
+
+// pointInPolygon reports whether (x, y) is inside the polygon, using the even-odd rule
+func pointInPolygon(x, y int, polygon [][2]int) bool {
+	px, py := float64(x)+0.5, float64(y)+0.5
+	inside := false
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		xi, yi := float64(polygon[i][0]), float64(polygon[i][1])
+		xj, yj := float64(polygon[j][0]), float64(polygon[j][1])
+		if (yi > py) != (yj > py) && px < (xj-xi)*(py-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// rotInRange reports whether rot is in the heading range going clockwise from "from" to "to", inclusive
+func rotInRange(rot, from, to int) bool {
+	rot = ((rot % 360) + 360) % 360
+	if from <= to {
+		return rot >= from && rot <= to
+	}
+	// The range wraps around north
+	return rot >= from || rot <= to
+}
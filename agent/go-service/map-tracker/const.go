@@ -14,31 +14,160 @@ const (
 	LOC_RADIUS   = 40
 )
 
+// Minimap detection configuration, for captures not in the reference layout
+const (
+	MINIMAP_RING_RADIUS      = 64.0 // Radius (px) of the minimap ring in the reference layout
+	MINIMAP_SEARCH_W         = 0.35 // Part of the screen width searched for the ring, from the left
+	MINIMAP_SEARCH_H         = 0.5  // Part of the screen height searched for the ring, from the top
+	MINIMAP_SCALE_MIN        = 0.6  // Min UI scale relative to the reference layout
+	MINIMAP_SCALE_MAX        = 1.6  // Max UI scale relative to the reference layout
+	MINIMAP_EDGE_THRESHOLD   = 80.0 // Min Sobel gradient magnitude of ring edge pixels
+	MINIMAP_DETECT_MIN_SCORE = 0.4  // Min fraction of the ring circumference supported by edges
+	MINIMAP_SNAP_DISTANCE    = 2    // Max center offset (px) to snap a detection to the reference layout
+	MINIMAP_SNAP_SCALE       = 0.03 // Max relative scale difference to snap a detection to the reference layout
+	MINIMAP_REDETECT_MISSES  = 10   // Consecutive location misses to re-detect the minimap
+)
+
 // Rotation inference configuration
 const (
 	// Pointer crop area
 	ROT_CENTER_X = 108
 	ROT_CENTER_Y = 111
 	ROT_RADIUS   = 12
+
+	// Fast rotation inference with polar signatures of the pointer
+	ROT_FAST_BINS         = 128 // Angle bins over 360 degrees, a power of 2
+	ROT_FAST_MIN_RADIUS   = 2   // Radius (px) of the innermost sampled circle
+	ROT_FAST_MAX_RADIUS   = 7   // Radius (px) of the outermost sampled circle, within the pointer template
+	ROT_FAST_CENTER_RANGE = 1   // Max offset (px) of the pointer center searched around the crop center
+	ROT_FAST_MIN_CONF     = 0.6 // Min confidence to trust the fast result, below which the brute force search is used
+)
+
+// Time-series filtering configuration
+const (
+	// Location filter (constant-velocity Kalman filter over x, y)
+	KALMAN_PROCESS_NOISE      = 400.0 // Acceleration noise spectral density (px^2/s^3)
+	KALMAN_MEASUREMENT_STD    = 2.0   // Location measurement std (px) at full confidence
+	KALMAN_INIT_VELOCITY_STD  = 30.0  // Initial velocity std (px/s)
+	KALMAN_GATE_THRESHOLD     = 13.8  // Squared Mahalanobis distance gate (chi-square, 2 DoF, 99.9%)
+	KALMAN_REACQUIRE_COUNT    = 3     // Consecutive consistent outliers to re-initialize the track
+	KALMAN_REACQUIRE_DISTANCE = 30    // Max distance (px) between outliers to be considered consistent
+	KALMAN_COAST_TIME_MS      = 500   // Max time to keep predicting without accepted measurements
+
+	// Heading filter (constant-angular-velocity Kalman filter over rotation)
+	HEADING_PROCESS_NOISE     = 5000.0 // Angular acceleration noise spectral density (deg^2/s^3)
+	HEADING_MEASUREMENT_STD   = 3.0    // Rotation measurement std (deg) at full confidence
+	HEADING_INIT_VELOCITY_STD = 90.0   // Initial angular velocity std (deg/s)
+	HEADING_GATE_THRESHOLD    = 10.8   // Squared Mahalanobis distance gate (chi-square, 1 DoF, 99.9%)
+	HEADING_REACQUIRE_COUNT   = 3      // Consecutive consistent outliers to re-initialize the track
+	HEADING_REACQUIRE_ANGLE   = 20.0   // Max angle (deg) between outliers to be considered consistent
+
+	// Local search radius (px) around the predicted location
+	FAST_SEARCH_RADIUS = 30
+
+	// Min confidence gain for the fast search to switch from the tracked tier to another tier of the same map
+	TIER_SWITCH_MARGIN = 0.05
+)
+
+// Location candidates configuration
+const (
+	LOC_TOP_K                = 5    // Number of location candidates to keep
+	LOC_PEAK_SUPPRESS_RADIUS = 20   // Distance (px) within which candidates refer to the same place
+	PEAK_RATIO_MIN_CONF      = 0.01 // Lower bound of the second peak confidence when calculating the peak ratio
 )
 
-// Time-series empirical optimization configuration
+// Coarse-to-fine matching configuration of the full search
 const (
-	PENDING_TAKEOVER_TIME_MS         = 1000
-	PENDING_TAKEOVER_COUNT_THRESHOLD = 3
-	CONVINCED_DISTANCE_THRESHOLD     = 30
-	CONVINCED_VALID_TIME_MS          = 500
+	PYRAMID_MIN_SCALE     = 0.25 // Min scale of the coarsest pyramid level
+	PYRAMID_COARSE_FACTOR = 2    // Peaks kept at the coarsest level per candidate to return, since coarse scores are noisier
+	PYRAMID_REFINE_RADIUS = 2    // Search radius (px) around a candidate at each finer level
 )
 
 // Resource paths
 const (
-	MAP_DIR      = "image/MapTracker/map"
-	POINTER_PATH = "image/MapTracker/pointer.png"
+	MAP_DIR           = "image/MapTracker/map"
+	WALKABLE_MASK_DIR = "image/MapTracker/map/walkable"
+	POINTER_PATH      = "image/MapTracker/pointer.png"
+	ROUTES_PATH       = "image/MapTracker/routes.json"
+
+	// Tier metadata file in MAP_DIR, declaring transitions between tiers
+	MAP_TIERS_FILE = "map_tiers.json"
+)
+
+// Routes file format version
+const ROUTES_VERSION = 1
+
+// Type of the tier transitions written into routes by MapTrackerRecord
+const TRANSITION_TYPE_RECORDED = "recorded"
+
+// Path planning configuration
+const (
+	PLANNER_GRID_SIZE        = 2   // Map pixels per planning grid cell
+	PLANNER_SNAP_RADIUS      = 10  // Max distance (cells) to snap an off-mask endpoint to walkable area
+	PLANNER_CLEARANCE_RANGE  = 6.0 // Distance (cells) to obstacles within which steps are penalized
+	PLANNER_CLEARANCE_WEIGHT = 2.0 // Extra step cost right next to an obstacle
 )
 
 // Move action configuration
 const (
 	INFER_INTERVAL_MS = 200
+
+	// Max movement (px) between inferences that still counts as not moving
+	STUCK_MOVE_THRESHOLD = 2.0
+
+	// Max re-plans while stuck before a target is reached
+	MAX_REPLANS_PER_TARGET = 3
+
+	// Max relocations after discontinuities before a target is reached
+	MAX_RELOCATIONS_PER_TARGET = 3
+)
+
+// Discontinuity detection configuration of MapTrackerMove
+const (
+	TELEPORT_CHECK_WINDOW_MS = 2000 // Max time between two locations to compare them for a jump
+	LOST_INFER_COUNT         = 5    // Consecutive inference failures to consider the location lost
+	LOADING_FLAT_STD         = 6.0  // Minimap color std below which it is considered blank
+	RELOCATE_STABLE_FRAMES   = 3    // Consecutive similar minimap frames to consider the scene stable
+	RELOCATE_STABLE_DIFF     = 12.0 // Max mean absolute difference between similar minimap frames
+)
+
+// Trajectory export configuration
+const (
+	TRAJECTORY_RENDER_MARGIN = 48  // Margin (px) around the drawn area when cropping the map image
+	TRAJECTORY_RENDER_SCALE  = 2.0 // Enlargement of the rendering
+)
+
+// Camera rotation calibration configuration
+const (
+	CALIBRATION_PATH        = "config/map_tracker_calibration.json" // Relative to the working directory
+	CALIBRATION_VERSION     = 1
+	CALIBRATION_MAX_DEGREES = 150.0 // Max expected rotation of a swipe, since larger ones are ambiguous
+	CALIBRATION_MIN_DEGREES = 2.0   // Min rotation of a swipe to count as a measurement
+	CALIBRATION_STEP_MS     = 300   // Duration of the step taken before each reading
+)
+
+// Obstacle memory related parameters
+const (
+	OBSTACLES_PATH             = "config/map_tracker_obstacles.json" // Relative to the working directory
+	OBSTACLES_VERSION          = 1
+	OBSTACLE_MERGE_RADIUS      = 8.0  // Max distance (px) between stuck locations counted as the same obstacle
+	OBSTACLE_AHEAD             = 3.0  // Estimated distance (px) from the stuck location to the obstacle
+	OBSTACLE_RADIUS            = 8.0  // Radius (px) around an obstacle kept clear by planning and detours
+	OBSTACLE_DETOUR_OFFSET     = 10.0 // Sideways offset (px) of a detour waypoint from the path
+	OBSTACLE_HEADING_TOLERANCE = 60.0 // Max angle in degrees between a path segment and the stuck heading for a detour
+	OBSTACLE_MIN_HITS          = 2    // Min times stuck at an obstacle before it is avoided
+	OBSTACLE_MAX_HITS          = 10   // Cap of the hit count, so that avoidance stays bounded
+	OBSTACLE_COST_WEIGHT       = 2.0  // Extra planning cost per hit at the center of an obstacle
+	OBSTACLE_EXPIRE_DAYS       = 30   // Days after which an obstacle not hit again is forgotten
+	OBSTACLE_PASS_WINDOW_MS    = 3000 // Max time after a recovery step for getting past an obstacle to be credited to it
+)
+
+// Default tracking sessions, so that actions and recognitions do not share state
+const (
+	MOVE_SESSION            = "MapTrackerMove"
+	ASSERT_LOCATION_SESSION = "MapTrackerAssertLocation"
+	RECORD_SESSION          = "MapTrackerRecord"
+	CALIBRATE_SESSION       = "MapTrackerCalibrate"
 )
 
 // MapTrackerInfer parameters default values
@@ -66,6 +195,40 @@ var DEFAULT_MOVING_PARAM = MapTrackerMoveParam{
 	SprintThreshold:        25.0,
 	StuckThreshold:         1500,
 	StuckTimeout:           10000,
+	StuckRecovery: []string{
+		RECOVERY_JUMP,
+		RECOVERY_BACK_OFF,
+		RECOVERY_STRAFE_LEFT,
+		RECOVERY_STRAFE_RIGHT,
+		RECOVERY_DETOUR_LEFT,
+		RECOVERY_DETOUR_RIGHT,
+		RECOVERY_REPLAN,
+	},
+	RecoveryDuration:  800,
+	DetourAngle:       45.0,
+	MinPeakRatio:      0, // Off until the benchmark justifies a value
+	TeleportThreshold: 80.0,
+	RelocateTimeout:   30000,
+}
+
+// MapTrackerAssertLocation parameters default values
+var DEFAULT_ASSERT_LOCATION_PARAM = MapTrackerAssertLocationParam{
+	MinPeakRatio: 0, // Off until the benchmark justifies a value
+}
+
+// MapTrackerRecord parameters default values
+var DEFAULT_RECORD_PARAM = MapTrackerRecordParam{
+	Interval:    INFER_INTERVAL_MS,
+	IdleTimeout: 5000,
+	MaxDuration: 600000,
+	Epsilon:     2.0,
+}
+
+// MapTrackerCalibrate parameters default values
+var DEFAULT_CALIBRATE_PARAM = MapTrackerCalibrateParam{
+	Swipes:      []int{30, 60, 120, 240},
+	Repeats:     2,
+	SettleDelay: 500,
 }
 
 // Win32 action related codes
@@ -74,8 +237,37 @@ const (
 	KEY_A     = 0x41
 	KEY_S     = 0x53
 	KEY_D     = 0x44
+	KEY_F     = 0x46
 	KEY_SHIFT = 0x10
 	KEY_CTRL  = 0x11
 	KEY_ALT   = 0x12
 	KEY_SPACE = 0x20
 )
+
+// Touch action related positions (in WORK_W x WORK_H coordinates) and contacts
+const (
+	TOUCH_JOYSTICK_CONTACT = 0
+	TOUCH_CAMERA_CONTACT   = 1
+	TOUCH_BUTTON_CONTACT   = 2
+
+	// Virtual joystick in the lower-left corner
+	TOUCH_JOYSTICK_X      = 180
+	TOUCH_JOYSTICK_Y      = 560
+	TOUCH_JOYSTICK_RADIUS = 90
+
+	// Camera swipe area in the right half of the screen
+	TOUCH_CAMERA_X         = 960
+	TOUCH_CAMERA_Y         = 300
+	TOUCH_CAMERA_MAX_SWIPE = 500
+	TOUCH_CAMERA_SCALE     = 1.0 // Touch swipe pixels per mouse movement pixel
+
+	// Buttons in the lower-right corner
+	TOUCH_SPRINT_X = 1150
+	TOUCH_SPRINT_Y = 620
+	TOUCH_JUMP_X   = 1210
+	TOUCH_JUMP_Y   = 520
+
+	// Interaction prompt to the right of the screen center
+	TOUCH_INTERACT_X = 860
+	TOUCH_INTERACT_Y = 400
+)
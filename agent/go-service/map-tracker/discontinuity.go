@@ -0,0 +1,166 @@
+// Copyright (c) 2026 Harry Huang
+package maptracker
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/MaaXYZ/MaaEnd/agent/go-service/pkg/minicv"
+	"github.com/MaaXYZ/maa-framework-go/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// Discontinuities of the player's location during navigation
+const (
+	DISCONTINUITY_JUMP    = "jump"    // The location jumped farther than walking allows
+	DISCONTINUITY_LOADING = "loading" // The minimap is blank, e.g. on a loading screen
+	DISCONTINUITY_LOST    = "lost"    // The location could not be inferred several times in a row
+)
+
+// discontinuityDetector watches the inferred locations of a navigation run for sudden changes
+type discontinuityDetector struct {
+	param     *MapTrackerMoveParam
+	last      *MapTrackerInferResult // Last inferred location
+	lastTime  time.Time
+	failCount int // Consecutive inference failures
+}
+
+func newDiscontinuityDetector(param *MapTrackerMoveParam) *discontinuityDetector {
+	return &discontinuityDetector{param: param}
+}
+
+// reset forgets the history, e.g. after re-localization
+func (d *discontinuityDetector) reset() {
+	d.last = nil
+	d.lastTime = time.Time{}
+	d.failCount = 0
+}
+
+// observe checks an inferred location against the previous one.
+// Locations are only inferred on the maps of the path, so leaving them shows up as DISCONTINUITY_LOST.
+// Returns the kind of discontinuity, or "" if the location is continuous.
+func (d *discontinuityDetector) observe(result *MapTrackerInferResult, now time.Time) string {
+	d.failCount = 0
+	last, lastTime := d.last, d.lastTime
+	d.last, d.lastTime = result, now
+	if last == nil {
+		return ""
+	}
+
+	// Locations far apart in time may be far apart in space as well
+	if now.Sub(lastTime).Milliseconds() <= TELEPORT_CHECK_WINDOW_MS {
+		dist := math.Hypot(float64(result.X-last.X), float64(result.Y-last.Y))
+		if dist > d.param.TeleportThreshold {
+			log.Warn().Ints("from", []int{last.X, last.Y}).Ints("to", []int{result.X, result.Y}).Float64("dist", dist).Msg("Location jump detected")
+			return DISCONTINUITY_JUMP
+		}
+	}
+	return ""
+}
+
+// observeFailure counts an inference failure.
+// Returns DISCONTINUITY_LOST once failures pile up, or "" otherwise.
+func (d *discontinuityDetector) observeFailure() string {
+	d.failCount++
+	if d.failCount >= LOST_INFER_COUNT {
+		d.failCount = 0
+		log.Warn().Int("failures", LOST_INFER_COUNT).Msg("Location lost")
+		return DISCONTINUITY_LOST
+	}
+	return ""
+}
+
+// checkMinimapBlank reports whether the minimap on the last captured screen is blank,
+// as on loading screens and fade-outs
+func checkMinimapBlank(ctx *maa.Context, ctrl *maa.Controller, session string) bool {
+	img, err := ctrl.CacheImage()
+	if err != nil || img == nil {
+		return false
+	}
+	screen := minicv.ImageConvertRGBA(img)
+	minimap := getInferState(ctx, session).minimapGeometry(screen).cropMinimap(screen, 1.0)
+	return isMinimapBlank(minimap)
+}
+
+// isMinimapBlank reports whether a minimap crop is too flat to be a map
+func isMinimapBlank(minimap *image.RGBA) bool {
+	return minicv.IsImageFlat(minimap, LOADING_FLAT_STD)
+}
+
+// relocate waits for the scene to stabilize after a discontinuity, then re-localizes with a full search on all tiers of the map.
+// The new location must be on a map of the current path.
+// Returns the new location, or an error describing why navigation cannot resume.
+func relocate(ctx *maa.Context, ctrl *maa.Controller, mb MovementBackend, param *MapTrackerMoveParam, path []Waypoint, kind string) (*MapTrackerInferResult, error) {
+	mb.MoveStop(100)
+	log.Warn().Str("kind", kind).Msg("Location discontinuity detected, pausing navigation to re-localize")
+	deadline := time.Now().Add(time.Duration(param.RelocateTimeout) * time.Millisecond)
+	interval := time.Duration(INFER_INTERVAL_MS) * time.Millisecond
+
+	// Wait until the minimap is visible and stops changing
+	var prev *image.RGBA
+	stableCount := 0
+	for stableCount < RELOCATE_STABLE_FRAMES {
+		if ctx.GetTasker().Stopping() {
+			return nil, fmt.Errorf("task is stopping")
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("scene did not stabilize within %d ms after %s", param.RelocateTimeout, kind)
+		}
+		time.Sleep(interval)
+
+		ctrl.PostScreencap().Wait()
+		img, err := ctrl.CacheImage()
+		if err != nil || img == nil {
+			stableCount = 0
+			continue
+		}
+		screen := minicv.ImageConvertRGBA(img)
+		minimap := getInferState(ctx, param.Session).minimapGeometry(screen).cropMinimap(screen, 1.0)
+		if isMinimapBlank(minimap) {
+			prev, stableCount = nil, 0
+			continue
+		}
+		if prev != nil && minicv.ImageMeanAbsDiff(prev, minimap) < RELOCATE_STABLE_DIFF {
+			stableCount++
+		} else {
+			stableCount = 0
+		}
+		prev = minimap
+	}
+
+	// Forget the stale track, so that the next inference is a full search
+	resetInferStates(ctx, param.Session, false)
+	baseMap, _ := splitMapName(param.MapName)
+	mapNames := pathMapNames(baseMap, path)
+
+	for {
+		if ctx.GetTasker().Stopping() {
+			return nil, fmt.Errorf("task is stopping")
+		}
+		result, err := runInfer(ctx, ctrl, "MapTrackerMove_Relocate", map[string]any{
+			"map_name_regex": mapFamilyRegex(baseMap),
+			"precision":      DEFAULT_INFERENCE_PARAM_FOR_MOVE.Precision,
+			"threshold":      DEFAULT_INFERENCE_PARAM_FOR_MOVE.Threshold,
+			"session":        param.Session,
+			"min_peak_ratio": param.MinPeakRatio,
+		})
+		if err == nil && result.Ambiguous {
+			err = fmt.Errorf("location is ambiguous, peak ratio %.3f", result.PeakRatio)
+		}
+		if err == nil {
+			if !slices.Contains(mapNames, result.MapName) {
+				return nil, fmt.Errorf("re-localized on map %s at [%d, %d] after %s, which is not on the path", result.MapName, result.X, result.Y, kind)
+			}
+			log.Info().Str("map", result.MapName).Int("x", result.X).Int("y", result.Y).Msg("Re-localized after discontinuity")
+			return result, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("failed to re-localize within %d ms after %s: %w", param.RelocateTimeout, kind, err)
+		}
+		log.Debug().Err(err).Msg("Re-localization failed, retrying")
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,353 @@
+// Copyright (c) 2026 Harry Huang
+package maptracker
+
+import (
+	"math"
+
+	"github.com/rs/zerolog/log"
+)
+
+/* ******** Location Filter ******** */
+
+// locationFilter is a constant-velocity Kalman filter over the player's map location.
+// State vector is (x, y, vx, vy) in map pixels and pixels per second.
+type locationFilter struct {
+	mapName     string
+	x           [4]float64
+	p           [4][4]float64
+	stateTimeMs int64 // Timestamp the state vector refers to
+	lastHitMs   int64 // Timestamp of the last accepted measurement
+
+	// Consecutive rejected measurements that agree with each other
+	outlierCount     int
+	outlierCandidate InferLocationRawResult
+}
+
+// searchPrior describes where the next location is expected to be
+type searchPrior struct {
+	mapName string
+	x, y    int
+	radius  int
+}
+
+// lost reports whether the filter has no usable track at the given time
+func (f *locationFilter) lost(nowMs int64) bool {
+	return f.mapName == "" || nowMs-f.lastHitMs >= KALMAN_COAST_TIME_MS
+}
+
+// reset initializes the filter at the given measurement
+func (f *locationFilter) reset(meas *InferLocationRawResult, nowMs int64) {
+	r := measurementVariance(KALMAN_MEASUREMENT_STD, meas.conf)
+	v := KALMAN_INIT_VELOCITY_STD * KALMAN_INIT_VELOCITY_STD
+	f.mapName = meas.mapName
+	f.x = [4]float64{float64(meas.x), float64(meas.y), 0, 0}
+	f.p = [4][4]float64{{r, 0, 0, 0}, {0, r, 0, 0}, {0, 0, v, 0}, {0, 0, 0, v}}
+	f.stateTimeMs = nowMs
+	f.lastHitMs = nowMs
+	f.outlierCount = 0
+}
+
+// predicted returns the state and covariance propagated to the given time without mutating the filter
+func (f *locationFilter) predicted(nowMs int64) ([4]float64, [4][4]float64) {
+	dt := float64(nowMs-f.stateTimeMs) / 1000.0
+	if dt <= 0 {
+		return f.x, f.p
+	}
+
+	// x = F x
+	x := f.x
+	x[0] += dt * x[2]
+	x[1] += dt * x[3]
+
+	// P = F P F^T
+	p := f.p
+	for i := range 4 {
+		p[i][0] += dt * p[i][2]
+		p[i][1] += dt * p[i][3]
+	}
+	for j := range 4 {
+		p[0][j] += dt * p[2][j]
+		p[1][j] += dt * p[3][j]
+	}
+
+	// P += Q (white noise acceleration model)
+	q := KALMAN_PROCESS_NOISE
+	dt2, dt3 := dt*dt, dt*dt*dt
+	for axis := range 2 {
+		pos, vel := axis, axis+2
+		p[pos][pos] += q * dt3 / 3
+		p[pos][vel] += q * dt2 / 2
+		p[vel][pos] += q * dt2 / 2
+		p[vel][vel] += q * dt
+	}
+	return x, p
+}
+
+// predict propagates the filter state to the given time
+func (f *locationFilter) predict(nowMs int64) {
+	f.x, f.p = f.predicted(nowMs)
+	if nowMs > f.stateTimeMs {
+		f.stateTimeMs = nowMs
+	}
+}
+
+// innovation returns the measurement residual, its covariance inverse and the squared Mahalanobis distance
+func (f *locationFilter) innovation(meas *InferLocationRawResult) ([2]float64, [2][2]float64, float64, bool) {
+	r := measurementVariance(KALMAN_MEASUREMENT_STD, meas.conf)
+	y := [2]float64{float64(meas.x) - f.x[0], float64(meas.y) - f.x[1]}
+	s := [2][2]float64{{f.p[0][0] + r, f.p[0][1]}, {f.p[1][0], f.p[1][1] + r}}
+	det := s[0][0]*s[1][1] - s[0][1]*s[1][0]
+	if det < 1e-9 {
+		return y, s, 0, false
+	}
+	si := [2][2]float64{{s[1][1] / det, -s[0][1] / det}, {-s[1][0] / det, s[0][0] / det}}
+	d2 := y[0]*(si[0][0]*y[0]+si[0][1]*y[1]) + y[1]*(si[1][0]*y[0]+si[1][1]*y[1])
+	return y, si, d2, true
+}
+
+// correct applies a measurement update with precomputed innovation
+func (f *locationFilter) correct(y [2]float64, si [2][2]float64) {
+	// K = P H^T S^-1
+	var k [4][2]float64
+	for i := range 4 {
+		k[i][0] = f.p[i][0]*si[0][0] + f.p[i][1]*si[1][0]
+		k[i][1] = f.p[i][0]*si[0][1] + f.p[i][1]*si[1][1]
+	}
+
+	// x = x + K y
+	for i := range 4 {
+		f.x[i] += k[i][0]*y[0] + k[i][1]*y[1]
+	}
+
+	// P = (I - K H) P
+	hp := [2][4]float64{f.p[0], f.p[1]}
+	for i := range 4 {
+		for j := range 4 {
+			f.p[i][j] -= k[i][0]*hp[0][j] + k[i][1]*hp[1][j]
+		}
+	}
+
+	// Keep covariance symmetric against rounding drift
+	for i := range 4 {
+		for j := i + 1; j < 4; j++ {
+			m := (f.p[i][j] + f.p[j][i]) / 2
+			f.p[i][j], f.p[j][i] = m, m
+		}
+	}
+}
+
+// update feeds a (possibly nil) measurement to the filter and returns the filtered location,
+// or nil if there is no usable track
+func (f *locationFilter) update(meas *InferLocationRawResult, nowMs int64) *InferLocationRawResult {
+	lost := f.lost(nowMs)
+
+	if meas == nil {
+		if lost {
+			return nil
+		}
+		// Coast on prediction during a temporary miss
+		f.predict(nowMs)
+		return f.result(VIRTUAL_HIT, 0, 0)
+	}
+
+	if lost {
+		f.reset(meas, nowMs)
+		return f.result(meas.source, meas.conf, meas.elapsedTimeMs)
+	}
+
+	f.predict(nowMs)
+	// Tiers of the same base map share coordinates, so the track continues across them
+	if sameMapFrame(meas.mapName, f.mapName) {
+		y, si, d2, ok := f.innovation(meas)
+		if ok && d2 <= KALMAN_GATE_THRESHOLD {
+			f.correct(y, si)
+			f.mapName = meas.mapName
+			f.lastHitMs = nowMs
+			f.outlierCount = 0
+			return f.result(meas.source, meas.conf, meas.elapsedTimeMs)
+		}
+		log.Debug().Float64("mahalanobis2", d2).Int("X", meas.x).Int("Y", meas.y).Msg("Location measurement rejected as outlier")
+	} else {
+		log.Debug().Str("map", meas.mapName).Str("trackedMap", f.mapName).Msg("Location measurement on another map rejected as outlier")
+	}
+
+	// Re-acquire when several consecutive outliers agree with each other
+	if f.outlierCount > 0 && sameMapFrame(f.outlierCandidate.mapName, meas.mapName) &&
+		math.Hypot(float64(f.outlierCandidate.x-meas.x), float64(f.outlierCandidate.y-meas.y)) < KALMAN_REACQUIRE_DISTANCE {
+		f.outlierCount++
+	} else {
+		f.outlierCount = 1
+	}
+	f.outlierCandidate = *meas
+
+	if f.outlierCount >= KALMAN_REACQUIRE_COUNT {
+		log.Info().Str("map", meas.mapName).Int("X", meas.x).Int("Y", meas.y).Msg("Consistent outliers observed, re-initializing location filter")
+		f.reset(meas, nowMs)
+		return f.result(meas.source, meas.conf, meas.elapsedTimeMs)
+	}
+	return f.result(VIRTUAL_HIT, 0, 0)
+}
+
+// result builds a raw location result from the current filter state
+func (f *locationFilter) result(source InferLocationHitMode, conf float64, elapsedTimeMs int64) *InferLocationRawResult {
+	return &InferLocationRawResult{
+		mapName:       f.mapName,
+		x:             int(math.Round(f.x[0])),
+		y:             int(math.Round(f.x[1])),
+		conf:          conf,
+		source:        source,
+		elapsedTimeMs: elapsedTimeMs,
+	}
+}
+
+// covariance returns the location covariance as [xx, xy, yy]
+func (f *locationFilter) covariance() [3]float64 {
+	return [3]float64{f.p[0][0], f.p[0][1], f.p[1][1]}
+}
+
+// std returns the RMS location standard deviation in pixels
+func (f *locationFilter) std() float64 {
+	return math.Sqrt(max(0, (f.p[0][0]+f.p[1][1])/2))
+}
+
+// prior returns the expected location for a local search, if the track is stable
+func (f *locationFilter) prior(nowMs int64) (searchPrior, bool) {
+	if f.lost(nowMs) || f.outlierCount > 0 {
+		return searchPrior{}, false
+	}
+	x, p := f.predicted(nowMs)
+	std := math.Sqrt(max(0, (p[0][0]+p[1][1])/2))
+	radius := min(max(FAST_SEARCH_RADIUS, int(3*std)), 2*FAST_SEARCH_RADIUS)
+	return searchPrior{f.mapName, int(math.Round(x[0])), int(math.Round(x[1])), radius}, true
+}
+
+/* ******** Heading Filter ******** */
+
+// headingFilter is a constant-angular-velocity Kalman filter over the player's heading.
+// State vector is (heading, angular velocity) in degrees and degrees per second.
+type headingFilter struct {
+	valid       bool
+	x           [2]float64
+	p           [2][2]float64
+	stateTimeMs int64
+	lastHitMs   int64
+
+	// Consecutive rejected measurements that agree with each other
+	outlierCount     int
+	outlierCandidate float64
+}
+
+func (f *headingFilter) reset(meas *InferRotationRawResult, nowMs int64) {
+	r := measurementVariance(HEADING_MEASUREMENT_STD, meas.conf)
+	v := HEADING_INIT_VELOCITY_STD * HEADING_INIT_VELOCITY_STD
+	f.valid = true
+	f.x = [2]float64{meas.rot, 0}
+	f.p = [2][2]float64{{r, 0}, {0, v}}
+	f.stateTimeMs = nowMs
+	f.lastHitMs = nowMs
+	f.outlierCount = 0
+}
+
+func (f *headingFilter) predict(nowMs int64) {
+	dt := float64(nowMs-f.stateTimeMs) / 1000.0
+	if dt <= 0 {
+		return
+	}
+	q := HEADING_PROCESS_NOISE
+	p := f.p
+	f.x[0] = normalizeAngle(f.x[0] + dt*f.x[1])
+	f.p[0][0] = p[0][0] + dt*(p[0][1]+p[1][0]) + dt*dt*p[1][1] + q*dt*dt*dt/3
+	f.p[0][1] = p[0][1] + dt*p[1][1] + q*dt*dt/2
+	f.p[1][0] = f.p[0][1]
+	f.p[1][1] = p[1][1] + q*dt
+	f.stateTimeMs = nowMs
+}
+
+// update feeds a (possibly nil) measurement to the filter and returns the filtered heading,
+// or nil if there is no usable track
+func (f *headingFilter) update(meas *InferRotationRawResult, nowMs int64) *InferRotationRawResult {
+	lost := !f.valid || nowMs-f.lastHitMs >= KALMAN_COAST_TIME_MS
+
+	if meas == nil {
+		if lost {
+			return nil
+		}
+		f.predict(nowMs)
+		return f.result(0, 0)
+	}
+
+	if lost {
+		f.reset(meas, nowMs)
+		return f.result(meas.conf, meas.elapsedTimeMs)
+	}
+
+	f.predict(nowMs)
+	r := measurementVariance(HEADING_MEASUREMENT_STD, meas.conf)
+	y := normalizeDeltaAngle(meas.rot - f.x[0])
+	s := f.p[0][0] + r
+	if y*y/s > HEADING_GATE_THRESHOLD {
+		log.Debug().Float64("mahalanobis2", y*y/s).Float64("rot", meas.rot).Msg("Rotation measurement rejected as outlier")
+
+		// Re-acquire when several consecutive outliers agree with each other, e.g. after an abrupt camera turn
+		if f.outlierCount > 0 && math.Abs(normalizeDeltaAngle(meas.rot-f.outlierCandidate)) < HEADING_REACQUIRE_ANGLE {
+			f.outlierCount++
+		} else {
+			f.outlierCount = 1
+		}
+		f.outlierCandidate = meas.rot
+
+		if f.outlierCount >= HEADING_REACQUIRE_COUNT {
+			log.Info().Float64("rot", meas.rot).Msg("Consistent outliers observed, re-initializing heading filter")
+			f.reset(meas, nowMs)
+			return f.result(meas.conf, meas.elapsedTimeMs)
+		}
+		return f.result(0, 0)
+	}
+
+	k0, k1 := f.p[0][0]/s, f.p[1][0]/s
+	f.x[0] = normalizeAngle(f.x[0] + k0*y)
+	f.x[1] += k1 * y
+	p := f.p
+	f.p[0][0] = p[0][0] - k0*p[0][0]
+	f.p[0][1] = p[0][1] - k0*p[0][1]
+	f.p[1][0] = f.p[0][1]
+	f.p[1][1] = p[1][1] - k1*p[0][1]
+	f.lastHitMs = nowMs
+	f.outlierCount = 0
+	return f.result(meas.conf, meas.elapsedTimeMs)
+}
+
+func (f *headingFilter) result(conf float64, elapsedTimeMs int64) *InferRotationRawResult {
+	return &InferRotationRawResult{
+		rot:           f.x[0],
+		conf:          conf,
+		elapsedTimeMs: elapsedTimeMs,
+	}
+}
+
+// std returns the heading standard deviation in degrees
+func (f *headingFilter) std() float64 {
+	return math.Sqrt(max(0, f.p[0][0]))
+}
+
+/* ******** Helpers ******** */
+
+// measurementVariance scales the base measurement std by the inverse of confidence
+func measurementVariance(baseStd, conf float64) float64 {
+	std := baseStd / max(conf, 0.05)
+	return std * std
+}
+
+// normalizeAngle normalizes an angle in degrees to [0, 360)
+func normalizeAngle(a float64) float64 {
+	a = math.Mod(a, 360)
+	if a < 0 {
+		a += 360
+	}
+	return a
+}
+
+// normalizeDeltaAngle normalizes an angle difference in degrees to [-180, 180)
+func normalizeDeltaAngle(d float64) float64 {
+	return normalizeAngle(d+180) - 180
+}
@@ -2,6 +2,7 @@
 package maptracker
 
 import (
+	"cmp"
 	_ "embed"
 	"encoding/json"
 	"fmt"
@@ -12,6 +13,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -25,6 +27,8 @@ import (
 // MapTrackerInferResult represents the result of map tracking inference
 type MapTrackerInferResult struct {
 	MapName     string  `json:"mapName"`     // Map name
+	BaseMap     string  `json:"baseMap"`     // Base map name (same as MapName unless on a tier)
+	Tier        string  `json:"tier"`        // Tier of the base map ("" if on the base map itself)
 	X           int     `json:"x"`           // X coordinate on the map
 	Y           int     `json:"y"`           // Y coordinate on the map
 	Rot         int     `json:"rot"`         // Rotation angle (0-359 degrees)
@@ -34,6 +38,22 @@ type MapTrackerInferResult struct {
 	RotTimeMs   int64   `json:"rotTimeMs"`   // Rotation inference time in ms
 	InferMode   string  `json:"inferMode"`   // Inference mode ("FullSearchHit", "FastSearchHit", "VirtualHit")
 	InferTimeMs int64   `json:"inferTimeMs"` // Total inference time in ms
+
+	LocCov [3]float64 `json:"locCov"` // Filtered location covariance [xx, xy, yy] in px^2
+	LocStd float64    `json:"locStd"` // Filtered location uncertainty (RMS std) in px
+	RotStd float64    `json:"rotStd"` // Filtered rotation uncertainty (std) in degrees
+
+	Candidates []InferLocationCandidate `json:"candidates"` // Top location candidates of this frame, best first
+	PeakRatio  float64                  `json:"peakRatio"`  // Ratio of the best confidence to that of the best distinct location
+	Ambiguous  bool                     `json:"ambiguous"`  // Whether the peak ratio is below min_peak_ratio
+}
+
+// InferLocationCandidate represents a candidate location of a single frame
+type InferLocationCandidate struct {
+	MapName string  `json:"mapName"` // Map name
+	X       int     `json:"x"`       // X coordinate on the map
+	Y       int     `json:"y"`       // Y coordinate on the map
+	Conf    float64 `json:"conf"`    // Matching confidence
 }
 
 // MapTrackerInferParam represents the custom_recognition_param for MapTrackerInfer
@@ -46,15 +66,24 @@ type MapTrackerInferParam struct {
 	Precision float64 `json:"precision,omitempty"`
 	// Threshold controls the minimum confidence required to consider the inference successful.
 	Threshold float64 `json:"threshold,omitempty"`
+	// Session names the tracking state to use, so that unrelated inferences do not affect each other.
+	Session string `json:"session,omitempty"`
+	// MinPeakRatio is the minimum peak ratio for a location to be considered unambiguous (0 to disable).
+	// Ambiguous locations are not used to update the tracking state.
+	MinPeakRatio float64 `json:"min_peak_ratio,omitempty"`
+	// Pyramid enables coarse-to-fine matching in the full search, which is faster but not yet benchmarked
+	// to be as accurate as matching at full precision.
+	Pyramid bool `json:"pyramid,omitempty"`
 }
 
 // MapCache represents a preloaded map image
 type MapCache struct {
 	Name     string
 	Img      *image.RGBA
-	Integral minicv.IntegralArray
+	Integral minicv.IntegralArray // Only set on scaled maps
 	OffsetX  int
 	OffsetY  int
+	Pyramid  *minicv.Pyramid // Cached scaled versions of the original map image
 }
 
 // MapTrackerInfer is the custom recognition component for map tracking
@@ -64,30 +93,16 @@ type MapTrackerInfer struct {
 	pointerOnce sync.Once
 	maps        []MapCache
 	pointer     *image.RGBA
+	pointerMask *minicv.Mask
+	pointerSig  *minicv.PolarSignature
 	mapsErr     error
 	pointerErr  error
 
-	// Cache for scaled maps
-	scaledMu    sync.Mutex
-	scaledScale float64
-	scaledMaps  []MapCache
+	// Cache for scaled maps, keyed by scale
+	scaledMu   sync.Mutex
+	scaledMaps map[float64][]MapCache
 }
 
-type InferState struct {
-	convinced              InferLocationRawResult
-	convincedLastHitTime   int64
-	convincedMoveDirection float64
-	convincedMoveSpeed     float64
-
-	pending             InferLocationRawResult
-	pendingFirstHitTime int64
-	pendingHitCount     int
-
-	mu sync.Mutex
-}
-
-var globalInferState InferState
-
 type InferLocationHitMode string
 
 const (
@@ -103,12 +118,12 @@ type InferLocationRawResult struct {
 	conf          float64
 	source        InferLocationHitMode
 	elapsedTimeMs int64
+	candidates    []InferLocationCandidate
+	peakRatio     float64
 }
 
-var emptyLocationRawResult = InferLocationRawResult{"", 0, 0, 0.0, "", 0}
-
 type InferRotationRawResult struct {
-	rot           int
+	rot           float64 // Clockwise angle in degrees [0, 360)
 	conf          float64
 	elapsedTimeMs int64
 }
@@ -137,14 +152,7 @@ func (i *MapTrackerInfer) Run(ctx *maa.Context, arg *maa.CustomRecognitionArg) (
 		return nil, false
 	}
 
-	var rotStep int
-	if param.Precision < 0.3 {
-		rotStep = 12
-	} else if param.Precision < 0.6 {
-		rotStep = 6
-	} else {
-		rotStep = 3
-	}
+	rotStep := rotStepForPrecision(param.Precision)
 
 	// Initialize resources on first run
 	i.initMaps(ctx)
@@ -160,9 +168,13 @@ func (i *MapTrackerInfer) Run(ctx *maa.Context, arg *maa.CustomRecognitionArg) (
 		return nil, false
 	}
 
+	// Get tracking state of this session
+	state := getInferState(ctx, param.Session)
+
 	// Perform inference
 	screenImg := minicv.ImageConvertRGBA(arg.Img)
 	t0 := time.Now()
+	geom := state.minimapGeometry(screenImg)
 
 	var wg sync.WaitGroup
 	wg.Add(2)
@@ -172,113 +184,46 @@ func (i *MapTrackerInfer) Run(ctx *maa.Context, arg *maa.CustomRecognitionArg) (
 
 	go func() {
 		defer wg.Done()
-		loc = i.inferLocation(screenImg, mapNameRegex, param)
+		loc = i.inferLocation(screenImg, geom, mapNameRegex, param, state)
 	}()
 
 	go func() {
 		defer wg.Done()
-		rot = i.inferRotation(screenImg, rotStep)
+		rot = i.inferRotation(screenImg, geom, rotStep)
 	}()
 
 	wg.Wait()
 
-	// Determine if recognition hit natively
-	internalLocHit := loc != nil && loc.conf > param.Threshold
+	// Determine if recognition hit natively.
+	// A single candidate, as from the fast search, has nothing to be confused with.
+	ambiguous := loc != nil && param.MinPeakRatio > 0 && len(loc.candidates) > 1 && loc.peakRatio < param.MinPeakRatio
+	if ambiguous {
+		log.Info().Float64("peakRatio", loc.peakRatio).Interface("candidates", loc.candidates).Msg("Location is ambiguous")
+	}
+	internalLocHit := loc != nil && loc.conf > param.Threshold && !ambiguous
 	internalRotHit := rot != nil && rot.conf > param.Threshold
 
-	// Final results (nil for now)
-	var finalLoc *InferLocationRawResult
-	var finalRot *InferRotationRawResult
-
-	globalInferState.mu.Lock()
-	nowMs := time.Now().UnixMilli()
-
-	// Process internal location hit
+	// Feed measurements to the filters (nil measurements let them coast on prediction)
+	var locMeas *InferLocationRawResult
+	var rotMeas *InferRotationRawResult
 	if internalLocHit {
-		isCloseToConvinced := func() bool {
-			if globalInferState.convinced.mapName == "" || globalInferState.convinced.mapName != loc.mapName {
-				return false
-			}
-			dx := float64(globalInferState.convinced.x - loc.x)
-			dy := float64(globalInferState.convinced.y - loc.y)
-			return math.Hypot(dx, dy) < CONVINCED_DISTANCE_THRESHOLD
-		}
-
-		isCloseToPending := func() bool {
-			if globalInferState.pending.mapName == "" || globalInferState.pending.mapName != loc.mapName {
-				return false
-			}
-			dx := float64(globalInferState.pending.x - loc.x)
-			dy := float64(globalInferState.pending.y - loc.y)
-			return math.Hypot(dx, dy) < CONVINCED_DISTANCE_THRESHOLD
-		}
-
-		if isCloseToConvinced() {
-			// This hit is close to the currently convinced location
-			dt := nowMs - globalInferState.convincedLastHitTime
-			if dt > 0 {
-				dx := float64(loc.x - globalInferState.convinced.x)
-				dy := float64(loc.y - globalInferState.convinced.y)
-				dist := math.Hypot(dx, dy)
-				globalInferState.convincedMoveSpeed = dist / float64(dt)
-				globalInferState.convincedMoveDirection = math.Atan2(dy, dx)
-			}
-			globalInferState.convinced = *loc
-			globalInferState.convincedLastHitTime = nowMs
-			finalLoc = loc
-
-		} else if isCloseToPending() {
-			// This hit is close to the pending location
-			globalInferState.pending.x = loc.x
-			globalInferState.pending.y = loc.y
-			globalInferState.pendingHitCount++
-
-			if globalInferState.convinced.mapName == "" ||
-				nowMs-globalInferState.pendingFirstHitTime >= PENDING_TAKEOVER_TIME_MS ||
-				globalInferState.pendingHitCount >= PENDING_TAKEOVER_COUNT_THRESHOLD {
-				// Do takeover (replace convinced with pending)
-				globalInferState.convinced = globalInferState.pending
-				globalInferState.convincedLastHitTime = nowMs
-				globalInferState.convincedMoveSpeed = 0
-				globalInferState.convincedMoveDirection = 0
-				globalInferState.pending = emptyLocationRawResult
-				globalInferState.pendingHitCount = 0
-				finalLoc = &globalInferState.convinced
-			}
-		} else {
-			// This hit is far from both convinced and pending locations, start a new pending
-			globalInferState.pending = *loc
-			globalInferState.pendingFirstHitTime = nowMs
-			globalInferState.pendingHitCount = 1
-		}
+		locMeas = loc
 	}
-
-	if finalLoc == nil {
-		if globalInferState.convinced.mapName != "" && nowMs-globalInferState.convincedLastHitTime < CONVINCED_VALID_TIME_MS {
-			// This is a temporary miss, but we can generate a virtual result
-			dt := nowMs - globalInferState.convincedLastHitTime
-			sx := globalInferState.convincedMoveSpeed * math.Cos(globalInferState.convincedMoveDirection)
-			sy := globalInferState.convincedMoveSpeed * math.Sin(globalInferState.convincedMoveDirection)
-			vx := globalInferState.convinced.x + int(sx*float64(dt))
-			vy := globalInferState.convinced.y + int(sy*float64(dt))
-
-			finalLoc = &InferLocationRawResult{
-				mapName:       globalInferState.convinced.mapName,
-				x:             vx,
-				y:             vy,
-				conf:          0,
-				source:        VIRTUAL_HIT,
-				elapsedTimeMs: 0,
-			}
-		}
-	}
-
-	// Process internal rotation hit
 	if internalRotHit {
-		finalRot = rot
+		rotMeas = rot
 	}
 
-	globalInferState.mu.Unlock()
+	state.mu.Lock()
+	nowMs := time.Now().UnixMilli()
+	finalLoc := state.loc.update(locMeas, nowMs)
+	finalRot := state.rot.update(rotMeas, nowMs)
+	locCov := state.loc.covariance()
+	locStd := state.loc.std()
+	rotStd := state.rot.std()
+	state.mu.Unlock()
+
+	// Re-detect the minimap if the location keeps being lost, e.g. after the UI scale changed
+	state.observeMinimap(screenImg, loc != nil && loc.conf > param.Threshold)
 
 	finalHit := finalLoc != nil && finalRot != nil
 	finalElapsedTimeMs := time.Since(t0).Milliseconds()
@@ -297,17 +242,28 @@ func (i *MapTrackerInfer) Run(ctx *maa.Context, arg *maa.CustomRecognitionArg) (
 	}
 
 	// Build hit result
+	baseMap, tier := splitMapName(finalLoc.mapName)
 	result := MapTrackerInferResult{
 		MapName:     finalLoc.mapName,
+		BaseMap:     baseMap,
+		Tier:        tier,
 		X:           finalLoc.x,
 		Y:           finalLoc.y,
-		Rot:         finalRot.rot,
+		Rot:         int(math.Round(finalRot.rot)) % 360,
 		LocConf:     finalLoc.conf,
 		RotConf:     finalRot.conf,
 		LocTimeMs:   finalLoc.elapsedTimeMs,
 		RotTimeMs:   finalRot.elapsedTimeMs,
 		InferMode:   string(finalLoc.source),
 		InferTimeMs: finalElapsedTimeMs,
+		LocCov:      locCov,
+		LocStd:      locStd,
+		RotStd:      rotStd,
+		Ambiguous:   ambiguous,
+	}
+	if loc != nil {
+		result.Candidates = loc.candidates
+		result.PeakRatio = loc.peakRatio
 	}
 
 	// Serialize result to JSON
@@ -355,6 +311,10 @@ func (r *MapTrackerInfer) parseParam(paramStr string) (*MapTrackerInferParam, er
 			} else if param.Threshold < 0.0 || param.Threshold > 1.0 {
 				return nil, fmt.Errorf("invalid threshold value: %f", param.Threshold)
 			}
+
+			if param.MinPeakRatio != 0.0 && param.MinPeakRatio < 1.0 {
+				return nil, fmt.Errorf("invalid min_peak_ratio value: %f", param.MinPeakRatio)
+			}
 		} else {
 			return nil, fmt.Errorf("failed to unmarshal parameters: %w", err)
 		}
@@ -364,6 +324,16 @@ func (r *MapTrackerInfer) parseParam(paramStr string) (*MapTrackerInferParam, er
 	}
 }
 
+// rotStepForPrecision returns the rotation search step in degrees for the given precision
+func rotStepForPrecision(precision float64) int {
+	if precision < 0.3 {
+		return 12
+	} else if precision < 0.6 {
+		return 6
+	}
+	return 3
+}
+
 // initMaps initializes the map cache (thread-safe, runs once)
 func (i *MapTrackerInfer) initMaps(ctx *maa.Context) {
 	i.mapsOnce.Do(func() {
@@ -382,9 +352,23 @@ func (i *MapTrackerInfer) initPointer(ctx *maa.Context) {
 		i.pointer, i.pointerErr = i.loadPointer(ctx)
 		if i.pointerErr != nil {
 			log.Error().Err(i.pointerErr).Msg("Failed to load pointer template")
-		} else {
-			log.Info().Msg("Pointer template image loaded")
+			return
+		}
+		// Match only the opaque pixels of the template, or the circle inscribed in it if it has no transparency,
+		// so that the background around the pointer does not count
+		i.pointerMask = minicv.NewAlphaMask(i.pointer)
+		if i.pointerMask.IsFull() {
+			i.pointerMask = minicv.NewCircleMask(i.pointer.Rect.Dx(), i.pointer.Rect.Dy())
+		}
+
+		// The pointer is assumed to rotate around the center of the template
+		radii := make([]float64, 0, ROT_FAST_MAX_RADIUS-ROT_FAST_MIN_RADIUS+1)
+		for r := ROT_FAST_MIN_RADIUS; r <= ROT_FAST_MAX_RADIUS; r++ {
+			radii = append(radii, float64(r))
 		}
+		w, h := i.pointer.Rect.Dx(), i.pointer.Rect.Dy()
+		i.pointerSig = minicv.NewPolarSignature(i.pointer, float64(w-1)/2, float64(h-1)/2, radii, ROT_FAST_BINS)
+		log.Info().Int("maskedPixels", i.pointerMask.Count).Msg("Pointer template image loaded")
 	})
 }
 
@@ -464,15 +448,13 @@ func (i *MapTrackerInfer) loadMaps(ctx *maa.Context) ([]MapCache, error) {
 			imgRGBA = minicv.ImageConvertRGBA(img)
 		}
 
-		// Precompute integral image
-		integral := minicv.GetIntegralArray(imgRGBA)
-
+		// Scaled versions and their integral images are computed on demand
 		maps = append(maps, MapCache{
-			Name:     name,
-			Img:      imgRGBA,
-			Integral: integral,
-			OffsetX:  offsetX,
-			OffsetY:  offsetY,
+			Name:    name,
+			Img:     imgRGBA,
+			OffsetX: offsetX,
+			OffsetY: offsetY,
+			Pyramid: minicv.NewPyramid(imgRGBA),
 		})
 	}
 
@@ -509,7 +491,7 @@ func (i *MapTrackerInfer) loadPointer(ctx *maa.Context) (*image.RGBA, error) {
 
 // inferLocation infers the player's location on the map.
 // Returns a raw result with mapName, x/y (map coordinates), conf, source, and elapsedTimeMs.
-func (i *MapTrackerInfer) inferLocation(screenImg *image.RGBA, mapNameRegex *regexp.Regexp, param *MapTrackerInferParam) *InferLocationRawResult {
+func (i *MapTrackerInfer) inferLocation(screenImg *image.RGBA, geom minimapGeometry, mapNameRegex *regexp.Regexp, param *MapTrackerInferParam, state *InferState) *InferLocationRawResult {
 	t0 := time.Now()
 
 	// Use cached scaled maps
@@ -521,82 +503,148 @@ func (i *MapTrackerInfer) inferLocation(screenImg *image.RGBA, mapNameRegex *reg
 	}
 
 	// Crop and scale mini-map area from screen
-	miniMap := minicv.ImageCropSquareByRadius(screenImg, LOC_CENTER_X, LOC_CENTER_Y, LOC_RADIUS)
-	miniMap = minicv.ImageScale(miniMap, scale)
+	miniMap := geom.cropMinimap(screenImg, scale)
 	miniMapBounds := miniMap.Bounds()
 	miniMapW, miniMapH := miniMapBounds.Dx(), miniMapBounds.Dy()
 
-	// Precompute needle (minimap) statistics for all matches
-	miniStats := minicv.GetImageStats(miniMap)
+	// Precompute needle (minimap) statistics for all matches.
+	// The minimap is round, so only the circle inscribed in the crop is matched.
+	miniMask := minicv.NewCircleMask(miniMapW, miniMapH)
+	miniStats := minicv.GetMaskedImageStats(miniMap, miniMask)
 	if miniStats.Std < 1e-6 {
 		return nil
 	}
 
-	// Time-series empirical optimization
-	// If the user is in a stable state (convinced location updated recently, no pending drifts),
-	// try to match the convinced map around the convinced location first.
-	globalInferState.mu.Lock()
-
-	isStable := globalInferState.convinced.mapName != "" &&
-		(time.Now().UnixMilli()-globalInferState.convincedLastHitTime < CONVINCED_VALID_TIME_MS) &&
-		globalInferState.pendingHitCount == 0
-	stableMapName := globalInferState.convinced.mapName
-	stableLocX := globalInferState.convinced.x
-	stableLocY := globalInferState.convinced.y
-
-	globalInferState.mu.Unlock()
-
-	// Try fast search if stable
-	if isStable && mapNameRegex.MatchString(stableMapName) {
-		for _, mapData := range scaledMaps {
-			if mapData.Name == stableMapName {
-				expectedCenterX := int(float64(stableLocX-mapData.OffsetX) * scale)
-				expectedCenterY := int(float64(stableLocY-mapData.OffsetY) * scale)
-				searchRadius := max(int(float64(CONVINCED_DISTANCE_THRESHOLD)*scale), 1)
-
-				matchX, matchY, matchVal := MatchTemplateAround(mapData.Img, mapData.Integral, miniMap, miniStats, expectedCenterX, expectedCenterY, searchRadius)
-
-				if matchVal > param.Threshold {
-					// Fast search hit
-					bestX := int(float64(matchX+miniMapW/2)/scale) + mapData.OffsetX
-					bestY := int(float64(matchY+miniMapH/2)/scale) + mapData.OffsetY
-					elapsedTimeMs := time.Since(t0).Milliseconds()
-					log.Debug().Float64("conf", matchVal).
-						Str("map", stableMapName).
-						Int("X", bestX).
-						Int("Y", bestY).
-						Int64("elapsedTimeMs", elapsedTimeMs).
-						Msg("Internal fast search location inference completed")
-
-					return &InferLocationRawResult{
-						mapName:       mapData.Name,
-						x:             bestX,
-						y:             bestY,
-						conf:          matchVal,
-						source:        FAST_SEARCH_HIT,
-						elapsedTimeMs: elapsedTimeMs,
-					}
+	// Time-series optimization
+	// If the location filter has a stable track, try to match the tracked map
+	// around the predicted location first.
+	state.mu.Lock()
+	prior, isStable := state.loc.prior(time.Now().UnixMilli())
+	state.mu.Unlock()
+
+	// Try fast search if stable.
+	// Tiers of the tracked map share its coordinates, so they are searched around the same location,
+	// which lets the track follow the player across floors.
+	if isStable && mapNameRegex.MatchString(prior.mapName) {
+		var (
+			bestMap            *MapCache
+			bestX, bestY       int
+			bestVal            = -1.0
+			trackedVal         = -1.0
+			trackedX, trackedY int
+			candidates         []InferLocationCandidate
+		)
+		for idx := range scaledMaps {
+			mapData := &scaledMaps[idx]
+			if !sameMapFrame(mapData.Name, prior.mapName) || !mapNameRegex.MatchString(mapData.Name) {
+				continue
+			}
+			expectedCenterX := int(float64(prior.x-mapData.OffsetX) * scale)
+			expectedCenterY := int(float64(prior.y-mapData.OffsetY) * scale)
+			searchRadius := max(int(float64(prior.radius)*scale), 1)
+
+			matchX, matchY, matchVal := MatchTemplateAround(mapData.Img, mapData.Integral, miniMap, miniMask, miniStats, expectedCenterX, expectedCenterY, searchRadius)
+			candidates = append(candidates, InferLocationCandidate{
+				MapName: mapData.Name,
+				X:       int(float64(matchX+miniMapW/2)/scale) + mapData.OffsetX,
+				Y:       int(float64(matchY+miniMapH/2)/scale) + mapData.OffsetY,
+				Conf:    matchVal,
+			})
+			if mapData.Name == prior.mapName {
+				trackedVal, trackedX, trackedY = matchVal, matchX, matchY
+			}
+			if matchVal > bestVal {
+				bestMap, bestX, bestY, bestVal = mapData, matchX, matchY, matchVal
+			}
+		}
+
+		// Stay on the tracked tier unless another tier is clearly better
+		if bestMap != nil && bestMap.Name != prior.mapName && trackedVal >= 0 && bestVal-trackedVal < TIER_SWITCH_MARGIN {
+			for idx := range scaledMaps {
+				if scaledMaps[idx].Name == prior.mapName {
+					bestMap, bestX, bestY, bestVal = &scaledMaps[idx], trackedX, trackedY, trackedVal
+					break
 				}
+			}
+		}
 
-				// If fast search fails (low confidence), fallback to full search
-				log.Debug().Float64("conf", matchVal).Msg("Empirical fast search miss")
-				break
+		if bestMap != nil && bestVal > param.Threshold {
+			// Fast search hit
+			locX := int(float64(bestX+miniMapW/2)/scale) + bestMap.OffsetX
+			locY := int(float64(bestY+miniMapH/2)/scale) + bestMap.OffsetY
+			elapsedTimeMs := time.Since(t0).Milliseconds()
+			log.Debug().Float64("conf", bestVal).
+				Str("map", bestMap.Name).
+				Int("X", locX).
+				Int("Y", locY).
+				Int64("elapsedTimeMs", elapsedTimeMs).
+				Msg("Internal fast search location inference completed")
+
+			candidates = sortCandidates(candidates)
+			return &InferLocationRawResult{
+				mapName:       bestMap.Name,
+				x:             locX,
+				y:             locY,
+				conf:          bestVal,
+				source:        FAST_SEARCH_HIT,
+				elapsedTimeMs: elapsedTimeMs,
+				candidates:    candidates,
+				peakRatio:     calcPeakRatio(candidates),
 			}
 		}
+
+		// If fast search fails (low confidence), fallback to full search
+		log.Debug().Float64("conf", bestVal).Msg("Fast search miss")
 	} else {
-		log.Debug().Msg("Empirical fast search skipped, not in stable state or regex mismatch")
+		log.Debug().Msg("Fast search skipped, no stable track or regex mismatch")
 	}
 
-	// Match against all maps in parallel
-	type mapResult struct {
-		val     float64
-		x, y    int
-		mapName string
+	// Match against all maps in parallel, keeping the top candidates of each map.
+	// With the pyramid enabled, maps are searched at the coarsest level, and the candidates are refined level by level.
+	scales := []float64{scale}
+	if param.Pyramid {
+		scales = minicv.PyramidScales(scale, PYRAMID_MIN_SCALE)
+	}
+	needles := make([]*image.RGBA, len(scales))
+	masks := make([]*minicv.Mask, len(scales))
+	for l, s := range scales {
+		if s == scale {
+			needles[l], masks[l] = miniMap, miniMask
+		} else {
+			needles[l] = geom.cropMinimap(screenImg, s)
+			masks[l] = minicv.NewCircleMask(needles[l].Rect.Dx(), needles[l].Rect.Dy())
+		}
+	}
+	suppressRadius := max(int(LOC_PEAK_SUPPRESS_RADIUS*scale), 1)
+	matchMap := func(m *MapCache) []InferLocationCandidate {
+		var peaks []MatchPeak
+		if len(scales) == 1 {
+			peaks = MatchTemplateTopK(m.Img, m.Integral, miniMap, miniMask, miniStats, LOC_TOP_K, suppressRadius)
+		} else {
+			hays := make([]*minicv.PyramidLevel, len(scales))
+			for l, s := range scales {
+				hays[l] = m.Pyramid.Level(s)
+			}
+			peaks = MatchTemplatePyramidTopK(hays, needles, masks, LOC_TOP_K, suppressRadius)
+			// The coarse levels may miss the true peak, which a search at full precision still finds
+			if len(peaks) == 0 || peaks[0].Score <= param.Threshold {
+				log.Debug().Str("map", m.Name).Msg("Coarse-to-fine search miss, falling back to full precision")
+				peaks = MatchTemplateTopK(m.Img, m.Integral, miniMap, miniMask, miniStats, LOC_TOP_K, suppressRadius)
+			}
+		}
+		cands := make([]InferLocationCandidate, 0, len(peaks))
+		for _, p := range peaks {
+			cands = append(cands, InferLocationCandidate{
+				MapName: m.Name,
+				X:       int(float64(p.X+miniMapW/2)/scale) + m.OffsetX,
+				Y:       int(float64(p.Y+miniMapH/2)/scale) + m.OffsetY,
+				Conf:    p.Score,
+			})
+		}
+		return cands
 	}
 
-	bestVal := -1.0
-	bestX, bestY := 0, 0
-	bestMapName := ""
+	candidates := make([]InferLocationCandidate, 0)
 	triedCount := 0
 
 	// Special case: if there's only one map to check, run it directly to avoid goroutine overhead
@@ -614,28 +662,21 @@ func (i *MapTrackerInfer) inferLocation(screenImg *image.RGBA, mapNameRegex *reg
 	}
 
 	if singleMapToTry != nil {
-		matchX, matchY, matchVal := MatchTemplateOptimized(singleMapToTry.Img, singleMapToTry.Integral, miniMap, miniStats)
-		bestVal = matchVal
-		bestX = int(float64(matchX+miniMapW/2)/scale) + singleMapToTry.OffsetX
-		bestY = int(float64(matchY+miniMapH/2)/scale) + singleMapToTry.OffsetY
-		bestMapName = singleMapToTry.Name
+		candidates = matchMap(singleMapToTry)
 	} else if triedCount > 1 {
-		resChan := make(chan mapResult, triedCount)
+		resChan := make(chan []InferLocationCandidate, triedCount)
 		var wg sync.WaitGroup
 
-		for _, mapData := range scaledMaps {
-			if !mapNameRegex.MatchString(mapData.Name) {
+		for idx := range scaledMaps {
+			if !mapNameRegex.MatchString(scaledMaps[idx].Name) {
 				continue
 			}
 
 			wg.Add(1)
-			go func(m MapCache) {
+			go func(m *MapCache) {
 				defer wg.Done()
-				matchX, matchY, matchVal := MatchTemplateOptimized(m.Img, m.Integral, miniMap, miniStats)
-				mx := int(float64(matchX+miniMapW/2)/scale) + m.OffsetX
-				my := int(float64(matchY+miniMapH/2)/scale) + m.OffsetY
-				resChan <- mapResult{matchVal, mx, my, m.Name}
-			}(mapData)
+				resChan <- matchMap(m)
+			}(&scaledMaps[idx])
 		}
 
 		go func() {
@@ -644,14 +685,19 @@ func (i *MapTrackerInfer) inferLocation(screenImg *image.RGBA, mapNameRegex *reg
 		}()
 
 		for res := range resChan {
-			if res.val > bestVal {
-				bestVal = res.val
-				bestX = res.x
-				bestY = res.y
-				bestMapName = res.mapName
-			}
+			candidates = append(candidates, res...)
 		}
 	}
+	candidates = sortCandidates(candidates)
+
+	bestVal := -1.0
+	bestX, bestY := 0, 0
+	bestMapName := ""
+	if len(candidates) > 0 {
+		best := candidates[0]
+		bestVal, bestX, bestY, bestMapName = best.Conf, best.X, best.Y, best.MapName
+	}
+	peakRatio := calcPeakRatio(candidates)
 
 	if triedCount == 0 {
 		log.Warn().Str("regex", mapNameRegex.String()).Msg("No maps matched the regex")
@@ -660,6 +706,7 @@ func (i *MapTrackerInfer) inferLocation(screenImg *image.RGBA, mapNameRegex *reg
 
 	log.Debug().Int("triedMaps", triedCount).
 		Float64("bestConf", bestVal).
+		Float64("peakRatio", peakRatio).
 		Str("bestMap", bestMapName).
 		Int("X", bestX).
 		Int("Y", bestY).
@@ -673,38 +720,71 @@ func (i *MapTrackerInfer) inferLocation(screenImg *image.RGBA, mapNameRegex *reg
 		conf:          bestVal,
 		source:        FULL_SEARCH_HIT,
 		elapsedTimeMs: time.Since(t0).Milliseconds(),
+		candidates:    candidates,
+		peakRatio:     peakRatio,
+	}
+}
+
+// sortCandidates sorts location candidates by descending confidence and keeps the top LOC_TOP_K
+func sortCandidates(candidates []InferLocationCandidate) []InferLocationCandidate {
+	slices.SortFunc(candidates, func(a, b InferLocationCandidate) int { return cmp.Compare(b.Conf, a.Conf) })
+	if len(candidates) > LOC_TOP_K {
+		candidates = candidates[:LOC_TOP_K]
+	}
+	return candidates
+}
+
+// calcPeakRatio calculates the ratio of the best confidence to that of the best distinct location.
+// Candidates near the best one on the same map or its tiers refer to the same place and are skipped.
+func calcPeakRatio(candidates []InferLocationCandidate) float64 {
+	if len(candidates) == 0 || candidates[0].Conf <= 0 {
+		return 0.0
+	}
+	best, second := candidates[0], 0.0
+	for _, c := range candidates[1:] {
+		if sameMapFrame(c.MapName, best.MapName) && abs(c.X-best.X) <= LOC_PEAK_SUPPRESS_RADIUS && abs(c.Y-best.Y) <= LOC_PEAK_SUPPRESS_RADIUS {
+			continue
+		}
+		second = c.Conf
+		break
 	}
+	return best.Conf / max(second, PEAK_RATIO_MIN_CONF)
 }
 
-// getScaledMaps returns cached scaled maps or recomputes them
+// getScaledMaps returns the maps at the given scale, taken from the pyramid of each map.
+// Every scale stays cached, so that switching precision does not rebuild the maps.
 func (i *MapTrackerInfer) getScaledMaps(scale float64) []MapCache {
 	i.scaledMu.Lock()
 	defer i.scaledMu.Unlock()
 
-	if i.scaledScale == scale && len(i.scaledMaps) > 0 {
-		return i.scaledMaps
+	if scaled, ok := i.scaledMaps[scale]; ok {
+		return scaled
 	}
 
-	log.Info().Float64("scale", scale).Msg("Recomputing scaled maps cache")
-	newScaled := make([]MapCache, 0, len(i.maps))
+	t0 := time.Now()
+	scaled := make([]MapCache, 0, len(i.maps))
 	for _, m := range i.maps {
-		sImg := minicv.ImageScale(m.Img, scale)
-		newScaled = append(newScaled, MapCache{
+		level := m.Pyramid.Level(scale)
+		scaled = append(scaled, MapCache{
 			Name:     m.Name,
-			Img:      sImg,
-			Integral: minicv.GetIntegralArray(sImg),
+			Img:      level.Img,
+			Integral: level.Integral,
 			OffsetX:  m.OffsetX,
 			OffsetY:  m.OffsetY,
+			Pyramid:  m.Pyramid,
 		})
 	}
-	i.scaledScale = scale
-	i.scaledMaps = newScaled
-	return i.scaledMaps
+	if i.scaledMaps == nil {
+		i.scaledMaps = make(map[float64][]MapCache)
+	}
+	i.scaledMaps[scale] = scaled
+	log.Info().Float64("scale", scale).Dur("duration", time.Since(t0)).Msg("Scaled maps cache built")
+	return scaled
 }
 
-// inferRotation infers the player's rotation angle
-// Returns (angle, confidence)
-func (i *MapTrackerInfer) inferRotation(screenImg *image.RGBA, rotStep int) *InferRotationRawResult {
+// inferRotation infers the player's rotation angle.
+// The angle is found from polar signatures of the pointer first, and by brute force if that is not confident enough.
+func (i *MapTrackerInfer) inferRotation(screenImg *image.RGBA, geom minimapGeometry, rotStep int) *InferRotationRawResult {
 	t0 := time.Now()
 
 	if i.pointer == nil {
@@ -712,10 +792,64 @@ func (i *MapTrackerInfer) inferRotation(screenImg *image.RGBA, rotStep int) *Inf
 	}
 
 	// Crop pointer area from screen
-	patch := minicv.ImageCropSquareByRadius(screenImg, ROT_CENTER_X, ROT_CENTER_Y, ROT_RADIUS)
+	patch := geom.cropPointer(screenImg)
+
+	if rot, conf := i.inferRotationFast(patch); conf >= ROT_FAST_MIN_CONF {
+		elapsedTimeMs := time.Since(t0).Milliseconds()
+		log.Debug().
+			Float64("bestConf", conf).
+			Float64("bestAngle", rot).
+			Int64("elapsedTimeMs", elapsedTimeMs).
+			Msg("Internal fast rotation inference completed")
+		return &InferRotationRawResult{
+			rot:           rot,
+			conf:          conf,
+			elapsedTimeMs: elapsedTimeMs,
+		}
+	} else {
+		log.Debug().Float64("conf", conf).Msg("Fast rotation inference miss")
+	}
+
+	return i.inferRotationBruteForce(patch, rotStep, t0)
+}
+
+// inferRotationFast finds the rotation of the pointer in the patch by correlating its polar signature
+// with the signature of the pointer template, around each center near the middle of the patch.
+// Returns (angle, confidence).
+func (i *MapTrackerInfer) inferRotationFast(patch *image.RGBA) (float64, float64) {
+	bestX, bestY := float64(patch.Rect.Dx()-1)/2, float64(patch.Rect.Dy()-1)/2
+	bestRot, bestConf := 0.0, -1.0
+	try := func(x, y float64) {
+		sig := minicv.NewPolarSignature(patch, x, y, i.pointerSig.Radii, ROT_FAST_BINS)
+		if rot, conf := minicv.EstimateRotation(sig, i.pointerSig); conf > bestConf {
+			bestX, bestY, bestRot, bestConf = x, y, rot, conf
+		}
+	}
+
+	// Search the center on the pixel grid, then around the best center with a finer step
+	cx, cy := bestX, bestY
+	for dy := -ROT_FAST_CENTER_RANGE; dy <= ROT_FAST_CENTER_RANGE; dy++ {
+		for dx := -ROT_FAST_CENTER_RANGE; dx <= ROT_FAST_CENTER_RANGE; dx++ {
+			try(cx+float64(dx), cy+float64(dy))
+		}
+	}
+	for _, step := range []float64{0.5, 0.25} {
+		cx, cy = bestX, bestY
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				if dx != 0 || dy != 0 {
+					try(cx+float64(dx)*step, cy+float64(dy)*step)
+				}
+			}
+		}
+	}
+	return bestRot, bestConf
+}
 
+// inferRotationBruteForce matches the pointer template against the patch rotated by every step
+func (i *MapTrackerInfer) inferRotationBruteForce(patch *image.RGBA, rotStep int, t0 time.Time) *InferRotationRawResult {
 	// Precompute needle (pointer) statistics
-	pointerStats := minicv.GetImageStats(i.pointer)
+	pointerStats := minicv.GetMaskedImageStats(i.pointer, i.pointerMask)
 	if pointerStats.Std < 1e-6 {
 		return nil
 	}
@@ -738,7 +872,7 @@ func (i *MapTrackerInfer) inferRotation(screenImg *image.RGBA, rotStep int) *Inf
 
 			// Match against pointer template
 			integral := minicv.GetIntegralArray(rotatedRGBA)
-			_, _, matchVal := MatchTemplateOptimized(rotatedRGBA, integral, i.pointer, pointerStats)
+			_, _, matchVal := MatchTemplateOptimized(rotatedRGBA, integral, i.pointer, i.pointerMask, pointerStats)
 
 			resChan <- result{a, matchVal}
 		}(angle)
@@ -769,7 +903,7 @@ func (i *MapTrackerInfer) inferRotation(screenImg *image.RGBA, rotStep int) *Inf
 		Msg("Internal rotation inference completed")
 
 	return &InferRotationRawResult{
-		rot:           bestAngle,
+		rot:           float64(bestAngle),
 		conf:          maxVal,
 		elapsedTimeMs: time.Since(t0).Milliseconds(),
 	}
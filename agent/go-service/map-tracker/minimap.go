@@ -0,0 +1,126 @@
+// Copyright (c) 2026 Harry Huang
+package maptracker
+
+import (
+	"image"
+	"image/draw"
+	"math"
+
+	"github.com/MaaXYZ/MaaEnd/agent/go-service/pkg/minicv"
+	"github.com/rs/zerolog/log"
+)
+
+// minimapGeometry describes where the minimap is on the screen.
+// Crops are rescaled to the reference layout (WORK_W x WORK_H at the default UI scale),
+// so that the crop constants (LOC_*, ROT_*) and the map images keep their meaning.
+type minimapGeometry struct {
+	centerX, centerY int     // Minimap center on the screen
+	scale            float64 // Minimap size relative to the reference layout
+}
+
+// defaultMinimapGeometry returns the reference layout fitted to the screen height
+func defaultMinimapGeometry(screen *image.RGBA) minimapGeometry {
+	s := float64(screen.Rect.Dy()) / WORK_H
+	return minimapGeometry{
+		centerX: int(math.Round(LOC_CENTER_X * s)),
+		centerY: int(math.Round(LOC_CENTER_Y * s)),
+		scale:   s,
+	}
+}
+
+// cropMinimap crops the mini-map area and scales it by extraScale relative to the reference layout
+func (g minimapGeometry) cropMinimap(screen *image.RGBA, extraScale float64) *image.RGBA {
+	radius := int(math.Round(LOC_RADIUS * g.scale))
+	img := minicv.ImageCropSquareByRadius(screen, g.centerX, g.centerY, radius)
+	return minicv.ImageScale(img, extraScale/g.scale)
+}
+
+// cropPointer crops the pointer area, rescaled to the reference layout
+func (g minimapGeometry) cropPointer(screen *image.RGBA) *image.RGBA {
+	cx := g.centerX + int(math.Round((ROT_CENTER_X-LOC_CENTER_X)*g.scale))
+	cy := g.centerY + int(math.Round((ROT_CENTER_Y-LOC_CENTER_Y)*g.scale))
+	radius := int(math.Round(ROT_RADIUS * g.scale))
+	img := minicv.ImageCropSquareByRadius(screen, cx, cy, radius)
+	if radius == ROT_RADIUS {
+		return img
+	}
+	// Resize to the exact reference size, so that the pointer template fits
+	size := 2*ROT_RADIUS + 1
+	return minicv.ImageScale(img, float64(size)/float64(img.Rect.Dx()))
+}
+
+// detectMinimap locates the minimap ring on the screen.
+// Returns false if no ring is found with enough confidence.
+func detectMinimap(screen *image.RGBA) (minimapGeometry, bool) {
+	def := defaultMinimapGeometry(screen)
+
+	// The minimap is in the top-left corner
+	region := image.Rect(0, 0, int(float64(screen.Rect.Dx())*MINIMAP_SEARCH_W), int(float64(screen.Rect.Dy())*MINIMAP_SEARCH_H))
+	crop := image.NewRGBA(region)
+	draw.Draw(crop, region, screen, screen.Rect.Min, draw.Src)
+
+	minR := int(MINIMAP_RING_RADIUS * def.scale * MINIMAP_SCALE_MIN)
+	maxR := int(math.Ceil(MINIMAP_RING_RADIUS * def.scale * MINIMAP_SCALE_MAX))
+	circle, ok := minicv.DetectCircle(crop, minR, maxR, MINIMAP_EDGE_THRESHOLD)
+	if !ok || circle.Score < MINIMAP_DETECT_MIN_SCORE {
+		log.Debug().Float64("score", circle.Score).Msg("Minimap ring not found")
+		return def, false
+	}
+
+	g := minimapGeometry{
+		centerX: circle.X,
+		centerY: circle.Y,
+		scale:   float64(circle.Radius) / MINIMAP_RING_RADIUS,
+	}
+	// Prefer the exact reference layout when the detection agrees with it, to avoid resampling
+	if abs(g.centerX-def.centerX) <= MINIMAP_SNAP_DISTANCE && abs(g.centerY-def.centerY) <= MINIMAP_SNAP_DISTANCE &&
+		math.Abs(g.scale-def.scale) <= MINIMAP_SNAP_SCALE*def.scale {
+		g = def
+	}
+
+	log.Info().Int("centerX", g.centerX).Int("centerY", g.centerY).Float64("scale", g.scale).
+		Int("radius", circle.Radius).Float64("score", circle.Score).Msg("Minimap detected")
+	return g, true
+}
+
+// minimapGeometry returns the minimap geometry of this session, detecting it if needed.
+// The reference layout is used until the capture size differs from it or the location is lost repeatedly.
+func (s *InferState) minimapGeometry(screen *image.RGBA) minimapGeometry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.minimap != nil && s.minimapH == screen.Rect.Dy() && s.minimapW == screen.Rect.Dx() {
+		return *s.minimap
+	}
+
+	g := defaultMinimapGeometry(screen)
+	if screen.Rect.Dx() != WORK_W || screen.Rect.Dy() != WORK_H {
+		if detected, ok := detectMinimap(screen); ok {
+			g = detected
+		}
+	}
+	s.minimap, s.minimapW, s.minimapH = &g, screen.Rect.Dx(), screen.Rect.Dy()
+	s.minimapMisses = 0
+	return g
+}
+
+// observeMinimap counts consecutive location misses, and re-detects the minimap once they pile up
+func (s *InferState) observeMinimap(screen *image.RGBA, locHit bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if locHit {
+		s.minimapMisses = 0
+		return
+	}
+	s.minimapMisses++
+	if s.minimapMisses < MINIMAP_REDETECT_MISSES {
+		return
+	}
+	s.minimapMisses = 0
+
+	log.Info().Int("misses", MINIMAP_REDETECT_MISSES).Msg("Location confidence collapsed, re-detecting minimap")
+	if g, ok := detectMinimap(screen); ok {
+		s.minimap, s.minimapW, s.minimapH = &g, screen.Rect.Dx(), screen.Rect.Dy()
+	}
+}
@@ -6,7 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"math"
-	"regexp"
+	"slices"
 	"time"
 
 	"github.com/MaaXYZ/MaaEnd/agent/go-service/pkg/maafocus"
@@ -18,10 +18,17 @@ type MapTrackerMove struct{}
 
 // MapTrackerMoveParam represents the custom_action_param for MapTrackerMove
 type MapTrackerMoveParam struct {
-	// MapName is the name of the map to navigate (required).
+	// MapName is the name of the map to navigate (required unless Route is set).
 	MapName string `json:"map_name"`
-	// Path is a sequence of [x, y] coordinate points to follow (required).
-	Path [][2]int `json:"path"`
+	// Path is a sequence of waypoints to follow (required unless Target is set).
+	// Each waypoint is [x, y], or {"x": x, "y": y, "tier": tier, "actions": [...]} to put it on another tier
+	// of the map or to perform actions after reaching it.
+	Path []Waypoint `json:"path"`
+	// Target is a [x, y] destination; the path is planned on the walkable mask of the map (required unless Path is set).
+	// It is only accepted on maps with a shipped walkable mask.
+	Target *[2]int `json:"target,omitempty"`
+	// Route is the name of a route in the routes file, which provides both MapName and Path.
+	Route string `json:"route,omitempty"`
 	// PathTrim trims the path to start from the nearest point to the current location when enabled.
 	PathTrim bool `json:"path_trim,omitempty"`
 	// NoPrint controls whether to suppress printing navigation status to the GUI.
@@ -35,6 +42,7 @@ type MapTrackerMoveParam struct {
 	// RotationUpperThreshold is the angular difference in degrees above which a more aggressive correction is applied.
 	RotationUpperThreshold float64 `json:"rotation_upper_threshold,omitempty"`
 	// RotationSpeed is the multiplier applied to the delta rotation when rotating the camera.
+	// If omitted, the camera rotation calibration of the controller is used if any.
 	RotationSpeed float64 `json:"rotation_speed,omitempty"`
 	// RotationTimeout is the maximum time in milliseconds allowed for rotation adjustment.
 	RotationTimeout int64 `json:"rotation_timeout,omitempty"`
@@ -42,8 +50,28 @@ type MapTrackerMoveParam struct {
 	SprintThreshold float64 `json:"sprint_threshold,omitempty"`
 	// StuckThreshold is the duration in milliseconds after which lack of movement is considered a stuck condition.
 	StuckThreshold int64 `json:"stuck_threshold,omitempty"`
-	// StuckTimeout is the maximum time in milliseconds to tolerate being stuck.
+	// StuckTimeout is the maximum time in milliseconds to tolerate being stuck once all recovery steps are tried.
 	StuckTimeout int64 `json:"stuck_timeout,omitempty"`
+	// StuckRecovery is the ordered list of recovery steps to try, one per stuck detection.
+	StuckRecovery []string `json:"stuck_recovery,omitempty"`
+	// RecoveryDuration is the time in milliseconds a movement recovery step keeps walking.
+	RecoveryDuration int64 `json:"recovery_duration,omitempty"`
+	// DetourAngle is the angle in degrees to turn for detour recovery steps.
+	DetourAngle float64 `json:"detour_angle,omitempty"`
+	// MinPeakRatio is the minimum peak ratio of inferred locations; ambiguous locations are not acted on.
+	MinPeakRatio float64 `json:"min_peak_ratio,omitempty"`
+	// Session names the tracking state used during navigation.
+	Session string `json:"session,omitempty"`
+	// Backend selects the input method for movement: "auto", "win32" or "touch".
+	Backend string `json:"backend,omitempty"`
+	// TeleportThreshold is the distance in pixels between consecutive locations above which the player is considered teleported.
+	TeleportThreshold float64 `json:"teleport_threshold,omitempty"`
+	// RelocateTimeout is the maximum time in milliseconds to wait for the scene to stabilize and re-localize after a discontinuity.
+	RelocateTimeout int64 `json:"relocate_timeout,omitempty"`
+	// Trajectory controls whether to dump the trajectory of the run to the debug directory.
+	Trajectory bool `json:"trajectory,omitempty"`
+	// ObstacleMemory enables recording the places where the player got stuck and avoiding the known ones.
+	ObstacleMemory bool `json:"obstacle_memory,omitempty"`
 }
 
 //go:embed messages/emergency_stop.html
@@ -68,14 +96,32 @@ func (a *MapTrackerMove) Run(ctx *maa.Context, arg *maa.CustomActionArg) bool {
 
 	ctrl := ctx.GetTasker().GetController()
 	aw := NewActionWrapper(ctx, ctrl)
+	if param.Backend == BACKEND_AUTO {
+		param.Backend = detectMovementBackend(ctrl)
+	}
+	mb := newMovementBackend(aw, param.Backend)
+	rm := newRotationModel(ctrl, param.Backend, param.RotationSpeed)
 	inferIntervalDuration := time.Duration(INFER_INTERVAL_MS) * time.Millisecond
 
+	if param.Target != nil {
+		path, err := planToTarget(ctx, ctrl, param)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to plan path to target")
+			return false
+		}
+		param.Path = path
+	}
+	baseMap, _ := splitMapName(param.MapName)
+
 	if param.PathTrim && len(param.Path) > 1 {
 		if initRes, err := doInfer(ctx, ctrl, param); err == nil && initRes != nil {
 			closestIdx := 0
 			minDist := math.MaxFloat64
 			for i, p := range param.Path {
-				dist := math.Hypot(float64(initRes.X-p[0]), float64(initRes.Y-p[1]))
+				if joinMapName(baseMap, p.tier()) != initRes.MapName {
+					continue
+				}
+				dist := math.Hypot(float64(initRes.X-p.X), float64(initRes.Y-p.Y))
 				if dist < minDist {
 					minDist = dist
 					closestIdx = i
@@ -90,12 +136,49 @@ func (a *MapTrackerMove) Run(ctx *maa.Context, arg *maa.CustomActionArg) bool {
 		}
 	}
 
+	// Planned paths already keep away from known obstacles
+	if param.ObstacleMemory && param.Target == nil {
+		param.Path = applyObstacleDetours(baseMap, param.Path)
+	}
+
 	log.Info().Str("map", param.MapName).Int("targets_count", len(param.Path)).Msg("Starting navigation to targets")
 
+	path := param.Path
+	recovery := newStuckRecovery(mb, rm, param)
+	detector := newDiscontinuityDetector(param)
+
+	// Dump the trajectory however navigation ends
+	trace := newTrajectoryRecorder(param)
+	trace.setPath(path)
+	finished := false
+	defer func() { trace.save(finished) }()
+
+	// The obstacle the player is stuck at, the heading it was hit in and the last recovery step tried,
+	// until the player gets past it or the target is reached
+	var (
+		stuckAt       *MapTrackerInferResult
+		stuckRot      int
+		stuckStep     string
+		stuckStepTime time.Time
+	)
+
+	// Progress towards the next target, kept across re-plans and relocations until a target is reached,
+	// so that they cannot postpone the arrival and stuck timeouts forever
+	var (
+		arrivalStart     time.Time
+		prevLocationTime time.Time
+		prevLocation     *[2]int
+		replans          int
+		relocations      int
+	)
+
 	// For each target point
-	for i, target := range param.Path {
-		targetX, targetY := target[0], target[1]
-		log.Info().Int("index", i).Int("targetX", targetX).Int("targetY", targetY).Msg("Navigating to next target point")
+targetLoop:
+	for i := 0; i < len(path); i++ {
+		target := path[i]
+		targetX, targetY := target.X, target.Y
+		targetMap := joinMapName(baseMap, target.tier())
+		log.Info().Int("index", i).Int("targetX", targetX).Int("targetY", targetY).Str("targetMap", targetMap).Msg("Navigating to next target point")
 
 		// Show navigation UI
 		if initRes, err := doInfer(ctx, ctrl, param); err == nil && initRes != nil {
@@ -113,10 +196,10 @@ func (a *MapTrackerMove) Run(ctx *maa.Context, arg *maa.CustomActionArg) bool {
 		var (
 			lastInferTime          = time.Time{}
 			lastRotationAdjustTime = time.Time{}
-			lastArrivalTime        = time.Now()
-			prevLocationTime       = time.Time{}
-			prevLocation           *[2]int
 		)
+		if arrivalStart.IsZero() {
+			arrivalStart = time.Now()
+		}
 
 		for {
 			// Calculate time since last check
@@ -130,50 +213,134 @@ func (a *MapTrackerMove) Run(ctx *maa.Context, arg *maa.CustomActionArg) bool {
 			// Check stopping signal
 			if ctx.GetTasker().Stopping() {
 				log.Warn().Msg("Task is stopping, exiting navigation loop")
-				aw.KeyUpSync(KEY_W, 100)
+				trace.addEvent(TRAJECTORY_EVENT_STOPPED, i, "")
+				mb.MoveStop(100)
 				return false
 			}
 
 			// Check arrival timeout
-			deltaArrivalMs := now.Sub(lastArrivalTime).Milliseconds()
+			deltaArrivalMs := now.Sub(arrivalStart).Milliseconds()
 			if deltaArrivalMs > param.ArrivalTimeout {
 				log.Error().Msg("Arrival timeout, stopping task")
-				doEmergencyStop(aw, param.NoPrint)
+				trace.addEvent(TRAJECTORY_EVENT_EMERGENCY_STOP, i, "arrival_timeout")
+				doEmergencyStop(aw, mb, param.NoPrint)
 				return false
 			}
 
 			// Run inference to get current location and rotation
 			result, err := doInfer(ctx, ctrl, param)
+
+			// Check teleports and loading screens.
+			// A flat minimap alone is not enough, since some areas of the maps are flat as well.
+			var discontinuity string
+			if err != nil && checkMinimapBlank(ctx, ctrl, param.Session) {
+				discontinuity = DISCONTINUITY_LOADING
+			} else if err != nil {
+				discontinuity = detector.observeFailure()
+			} else {
+				discontinuity = detector.observe(result, now)
+			}
+			if discontinuity != "" {
+				trace.addEvent(TRAJECTORY_EVENT_DISCONTINUITY, i, discontinuity)
+				if relocations >= MAX_RELOCATIONS_PER_TARGET {
+					log.Error().Int("relocations", relocations).Msg("Too many discontinuities without reaching a target, stopping task")
+					trace.addEvent(TRAJECTORY_EVENT_EMERGENCY_STOP, i, "relocation_limit")
+					doEmergencyStop(aw, mb, param.NoPrint)
+					return false
+				}
+				relocations++
+				relocated, err := relocate(ctx, ctrl, mb, param, path, discontinuity)
+				if err != nil {
+					if ctx.GetTasker().Stopping() {
+						log.Warn().Msg("Task is stopping, exiting navigation loop")
+						trace.addEvent(TRAJECTORY_EVENT_STOPPED, i, "")
+						return false
+					}
+					log.Error().Err(err).Msg("Failed to resume navigation after discontinuity, stopping task")
+					trace.addEvent(TRAJECTORY_EVENT_EMERGENCY_STOP, i, err.Error())
+					doEmergencyStop(aw, mb, param.NoPrint)
+					return false
+				}
+				trace.addPoint(relocated)
+				detector.reset()
+				recovery.reset()
+				stuckAt, stuckStep = nil, ""
+				prevLocation = nil
+				path, i = replanFromStuck(baseMap, path, i, relocated, param.ObstacleMemory)
+				trace.setPath(path)
+				i-- // Compensate the increment of the target loop
+				continue targetLoop
+			}
+
 			if err != nil {
 				log.Error().Err(err).Msg("Inference failed during navigation")
-				aw.KeyUpSync(KEY_W, 100)
+				mb.MoveStop(100)
 				continue
 			}
 
 			curX, curY := result.X, result.Y
 			rot := result.Rot
+			trace.addPoint(result)
 
 			// Check Stuck
-			if prevLocation != nil && prevLocation[0] == curX && prevLocation[1] == curY {
+			if prevLocation != nil && math.Hypot(float64(prevLocation[0]-curX), float64(prevLocation[1]-curY)) < STUCK_MOVE_THRESHOLD {
 				deltaLocationMs := now.Sub(prevLocationTime).Milliseconds()
-				if deltaLocationMs > param.StuckTimeout {
-					log.Error().Msg("Stuck for too long, stopping task")
-					doEmergencyStop(aw, param.NoPrint)
-					return false
-				}
 				if deltaLocationMs > param.StuckThreshold {
-					log.Info().Msg("Stuck detected, jumping...")
-					aw.KeyTypeSync(KEY_SPACE, 100)
+					if !recovery.exhausted() {
+						if recovery.due(now) {
+							step := recovery.advance(now)
+							trace.addEvent(TRAJECTORY_EVENT_STUCK, i, step)
+							if param.ObstacleMemory {
+								if stuckAt == nil {
+									stuckAt, stuckRot = result, calcTargetRotation(curX, curY, targetX, targetY)
+									recordObstacle(result.MapName, curX, curY, stuckRot)
+								}
+								stuckStep, stuckStepTime = step, now
+							}
+							if step == RECOVERY_REPLAN {
+								mb.MoveStop(100)
+								if replans >= MAX_REPLANS_PER_TARGET {
+									log.Error().Int("replans", replans).Msg("Too many re-plans without reaching a target, stopping task")
+									trace.addEvent(TRAJECTORY_EVENT_EMERGENCY_STOP, i, "replan_limit")
+									doEmergencyStop(aw, mb, param.NoPrint)
+									return false
+								}
+								replans++
+								path, i = replanFromStuck(baseMap, path, i, result, param.ObstacleMemory)
+								trace.setPath(path)
+								i-- // Compensate the increment of the target loop
+								continue targetLoop
+							}
+							recovery.move(step)
+						}
+					} else if deltaLocationMs > param.StuckTimeout {
+						log.Error().Msg("All stuck recovery steps failed, stopping task")
+						trace.addEvent(TRAJECTORY_EVENT_EMERGENCY_STOP, i, "stuck_timeout")
+						doEmergencyStop(aw, mb, param.NoPrint)
+						return false
+					}
 				}
 			} else {
 				prevLocation = &[2]int{curX, curY}
 				prevLocationTime = now
 			}
 
-			// Check arrival
+			// Learn the side to pass the obstacle by, if the player got past it soon after a sideways recovery step
+			if stuckAt != nil && result.MapName == stuckAt.MapName && passedObstacle(stuckAt.X, stuckAt.Y, stuckRot, curX, curY) {
+				if now.Sub(stuckStepTime).Milliseconds() <= OBSTACLE_PASS_WINDOW_MS {
+					resolveObstacle(stuckAt.MapName, stuckAt.X, stuckAt.Y, stuckStep)
+				}
+				stuckAt, stuckStep = nil, ""
+			}
+
+			// Check arrival (on the same tier)
 			dist := math.Hypot(float64(curX-targetX), float64(curY-targetY))
-			if dist < param.ArrivalThreshold {
+			if dist < param.ArrivalThreshold && result.MapName == targetMap {
 				log.Info().Int("x", curX).Int("y", curY).Int("index", i).Msg("Target point reached")
+				trace.addEvent(TRAJECTORY_EVENT_TARGET_REACHED, i, "")
+				recovery.reset()
+				arrivalStart, prevLocation, replans, relocations = time.Time{}, nil, 0, 0
+				stuckAt, stuckStep = nil, ""
 				break
 			}
 
@@ -191,7 +358,8 @@ func (a *MapTrackerMove) Run(ctx *maa.Context, arg *maa.CustomActionArg) bool {
 				deltaRotationAdjustMs := now.Sub(lastRotationAdjustTime).Milliseconds()
 				if deltaRotationAdjustMs > param.RotationTimeout {
 					log.Error().Msg("Rotation adjustment timeout, stopping task")
-					doEmergencyStop(aw, param.NoPrint)
+					trace.addEvent(TRAJECTORY_EVENT_EMERGENCY_STOP, i, "rotation_timeout")
+					doEmergencyStop(aw, mb, param.NoPrint)
 					return false
 				}
 
@@ -199,36 +367,47 @@ func (a *MapTrackerMove) Run(ctx *maa.Context, arg *maa.CustomActionArg) bool {
 
 				if math.Abs(float64(deltaRot)) > param.RotationUpperThreshold {
 					// Stop and rotate for large misalignment
-					aw.KeyUpSync(KEY_W, 0)
-					aw.RotateCamera(int(float64(deltaRot)*param.RotationSpeed), 100, 100)
-					aw.KeyDownSync(KEY_W, 100)
+					mb.MoveStop(0)
+					mb.RotateCamera(rm.pixels(float64(deltaRot)), 100, 100)
+					mb.MoveStart(MOVE_FORWARD, 100)
 				} else {
 					// Just rotate for small misalignment
-					aw.RotateCamera(int(float64(deltaRot)*param.RotationSpeed), 100, 100)
-					aw.KeyDownSync(KEY_W, 100)
+					mb.RotateCamera(rm.pixels(float64(deltaRot)), 100, 100)
+					mb.MoveStart(MOVE_FORWARD, 100)
 				}
 			} else {
-				aw.KeyDownSync(KEY_W, 100)
+				mb.MoveStart(MOVE_FORWARD, 100)
 				if dist > param.SprintThreshold {
 					// Sprint if target is far enough
-					aw.KeyTypeSync(KEY_SHIFT, 100)
+					mb.Sprint(100)
 				}
 				lastRotationAdjustTime = time.Time{} // Reset
 			}
 		}
 
 		// End of loop, one target reached
-		aw.KeyUpSync(KEY_W, 100)
+		mb.MoveStop(100)
+
+		if len(target.Actions) > 0 {
+			if err := runWaypointActions(ctx, ctrl, mb, param, target, targetMap); err != nil {
+				log.Error().Err(err).Int("index", i).Msg("Waypoint action failed, stopping navigation")
+				trace.addEvent(TRAJECTORY_EVENT_ACTION_FAILED, i, err.Error())
+				return false
+			}
+			// Actions may move the player, e.g. by teleporting
+			detector.reset()
+		}
 	}
 
 	// Show finished UI summary
 	if !param.NoPrint {
 		maafocus.NodeActionStarting(
 			aw.ctx,
-			fmt.Sprintf(navigationFinishedHTML, len(param.Path)),
+			fmt.Sprintf(navigationFinishedHTML, len(path)),
 		)
 	}
 
+	finished = true
 	return true
 }
 
@@ -240,11 +419,45 @@ func (a *MapTrackerMove) parseParam(paramStr string) (*MapTrackerMoveParam, erro
 	if err := json.Unmarshal([]byte(paramStr), &param); err != nil {
 		return nil, fmt.Errorf("failed to parse parameters: %w", err)
 	}
+	// Transitions recorded with the route, if any
+	var transitions []TierTransition
+	if param.Route != "" {
+		if len(param.MapName) > 0 || len(param.Path) > 0 || param.Target != nil {
+			return nil, fmt.Errorf("route cannot be combined with map_name, path or target")
+		}
+		route, err := getRoute(param.Route)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load route: %w", err)
+		}
+		param.MapName = route.MapName
+		param.Path = slices.Clone(route.Path)
+		transitions = route.Transitions
+	}
 	if len(param.MapName) == 0 {
 		return nil, fmt.Errorf("map_name is required in parameters, got empty")
 	}
-	if len(param.Path) == 0 {
-		return nil, fmt.Errorf("path is required in parameters, got empty")
+	if len(param.Path) == 0 && param.Target == nil {
+		return nil, fmt.Errorf("path or target is required in parameters, got empty")
+	}
+	if len(param.Path) > 0 && param.Target != nil {
+		return nil, fmt.Errorf("path and target are mutually exclusive")
+	}
+	// Drafts from `go-service walkmask` are not reliable enough to plan whole paths on,
+	// so target is only available on maps with a reviewed mask shipped
+	if param.Target != nil && walkMaskPath(param.MapName) == "" {
+		return nil, fmt.Errorf("target is not available on map %s, which has no walkable mask", param.MapName)
+	}
+	if len(param.Path) > 0 {
+		// Make tiers explicit and insert declared transitions between tiers
+		baseMap, path := resolveWaypoints(param.MapName, param.Path)
+		path, err := expandTierTransitions(baseMap, path, transitions)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path: %w", err)
+		}
+		if err := validateWaypointActions(path); err != nil {
+			return nil, fmt.Errorf("invalid path: %w", err)
+		}
+		param.Path = path
 	}
 
 	// Validate parameters and set defaults
@@ -276,10 +489,9 @@ func (a *MapTrackerMove) parseParam(paramStr string) (*MapTrackerMoveParam, erro
 		param.RotationUpperThreshold = DEFAULT_MOVING_PARAM.RotationUpperThreshold
 	}
 
+	// RotationSpeed of 0 is resolved with the calibration of the controller in Run
 	if param.RotationSpeed < 0 {
 		return nil, fmt.Errorf("rotation_speed must be non-negative")
-	} else if param.RotationSpeed == 0 {
-		param.RotationSpeed = DEFAULT_MOVING_PARAM.RotationSpeed
 	}
 
 	if param.RotationTimeout < 0 {
@@ -306,19 +518,128 @@ func (a *MapTrackerMove) parseParam(paramStr string) (*MapTrackerMoveParam, erro
 		param.StuckTimeout = DEFAULT_MOVING_PARAM.StuckTimeout
 	}
 
+	if param.StuckRecovery == nil {
+		param.StuckRecovery = DEFAULT_MOVING_PARAM.StuckRecovery
+	}
+	for _, step := range param.StuckRecovery {
+		if !isRecoveryStep(step) {
+			return nil, fmt.Errorf("unknown stuck recovery step %q", step)
+		}
+	}
+
+	if param.RecoveryDuration < 0 {
+		return nil, fmt.Errorf("recovery_duration must be non-negative")
+	} else if param.RecoveryDuration == 0 {
+		param.RecoveryDuration = DEFAULT_MOVING_PARAM.RecoveryDuration
+	}
+
+	if param.DetourAngle < 0 {
+		return nil, fmt.Errorf("detour_angle must be non-negative")
+	} else if param.DetourAngle > 180 {
+		return nil, fmt.Errorf("detour_angle must be between 0 and 180 degrees")
+	} else if param.DetourAngle == 0 {
+		param.DetourAngle = DEFAULT_MOVING_PARAM.DetourAngle
+	}
+
+	if param.MinPeakRatio < 0 {
+		return nil, fmt.Errorf("min_peak_ratio must be non-negative")
+	} else if param.MinPeakRatio == 0 {
+		param.MinPeakRatio = DEFAULT_MOVING_PARAM.MinPeakRatio
+	} else if param.MinPeakRatio < 1 {
+		return nil, fmt.Errorf("min_peak_ratio must be at least 1")
+	}
+
+	if param.Session == "" {
+		param.Session = MOVE_SESSION
+	}
+
+	if param.TeleportThreshold < 0 {
+		return nil, fmt.Errorf("teleport_threshold must be non-negative")
+	} else if param.TeleportThreshold == 0 {
+		param.TeleportThreshold = DEFAULT_MOVING_PARAM.TeleportThreshold
+	}
+
+	if param.RelocateTimeout < 0 {
+		return nil, fmt.Errorf("relocate_timeout must be non-negative")
+	} else if param.RelocateTimeout == 0 {
+		param.RelocateTimeout = DEFAULT_MOVING_PARAM.RelocateTimeout
+	}
+
+	switch param.Backend {
+	case "":
+		param.Backend = BACKEND_AUTO
+	case BACKEND_AUTO, BACKEND_WIN32, BACKEND_TOUCH:
+	default:
+		return nil, fmt.Errorf("unknown backend %q, must be one of auto, win32 or touch", param.Backend)
+	}
+
 	return &param, nil
 }
 
-func doEmergencyStop(aw *ActionWrapper, noPrint bool) {
+// planToTarget infers the current location and plans a walkable path from it to param.Target
+func planToTarget(ctx *maa.Context, ctrl *maa.Controller, param *MapTrackerMoveParam) ([]Waypoint, error) {
+	mask, err := getWalkMask(param.MapName)
+	if err != nil {
+		return nil, err
+	}
+
+	initRes, err := doInfer(ctx, ctrl, param)
+	if err != nil {
+		return nil, fmt.Errorf("failed to infer current location: %w", err)
+	}
+
+	from := [2]int{initRes.X, initRes.Y}
+	startTime := time.Now()
+	var opts *PlanOptions
+	if param.ObstacleMemory {
+		opts = obstaclePlanOptions(param.MapName)
+	}
+	path, err := mask.PlanPath(from, *param.Target, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info().Ints("from", from[:]).Ints("target", param.Target[:]).Int("waypoints", len(path)).
+		Dur("duration", time.Since(startTime)).Msg("Path planned to target")
+	_, tier := splitMapName(param.MapName)
+	return waypointsFromPoints(path, tier), nil
+}
+
+func doEmergencyStop(aw *ActionWrapper, mb MovementBackend, noPrint bool) {
 	log.Warn().Msg("Emergency stop triggered")
 	if !noPrint {
 		maafocus.NodeActionStarting(aw.ctx, emergencyStopHTML)
 	}
-	aw.KeyUpSync(KEY_W, 100)
+	mb.MoveStop(100)
 	aw.ctx.GetTasker().PostStop()
 }
 
 func doInfer(ctx *maa.Context, ctrl *maa.Controller, param *MapTrackerMoveParam) (*MapTrackerInferResult, error) {
+	// Only consider the maps (tiers) the path is on
+	mapNames := []string{param.MapName}
+	if len(param.Path) > 0 {
+		baseMap, _ := splitMapName(param.MapName)
+		mapNames = pathMapNames(baseMap, param.Path)
+	}
+	result, err := runInfer(ctx, ctrl, "MapTrackerMove_Infer", map[string]any{
+		"map_name_regex": mapNamesRegex(mapNames),
+		"precision":      DEFAULT_INFERENCE_PARAM_FOR_MOVE.Precision,
+		"threshold":      DEFAULT_INFERENCE_PARAM_FOR_MOVE.Threshold,
+		"session":        param.Session,
+		"min_peak_ratio": param.MinPeakRatio,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Do not act on a location that may be confused with another place
+	if result.Ambiguous {
+		return nil, fmt.Errorf("location is ambiguous, peak ratio %.3f", result.PeakRatio)
+	}
+	return result, nil
+}
+
+// runInfer captures the screen and runs MapTrackerInfer with the given parameters under the given node name
+func runInfer(ctx *maa.Context, ctrl *maa.Controller, nodeName string, inferParam map[string]any) (*MapTrackerInferResult, error) {
 	// Capture Screen
 	ctrl.PostScreencap().Wait()
 	img, err := ctrl.CacheImage()
@@ -332,16 +653,11 @@ func doInfer(ctx *maa.Context, ctrl *maa.Controller, param *MapTrackerMoveParam)
 	}
 
 	// Run recognition
-	nodeName := "MapTrackerMove_Infer"
 	config := map[string]any{
 		nodeName: map[string]any{
-			"recognition":        "Custom",
-			"custom_recognition": "MapTrackerInfer",
-			"custom_recognition_param": map[string]any{
-				"map_name_regex": "^" + regexp.QuoteMeta(param.MapName) + "$",
-				"precision":      DEFAULT_INFERENCE_PARAM_FOR_MOVE.Precision,
-				"threshold":      DEFAULT_INFERENCE_PARAM_FOR_MOVE.Threshold,
-			},
+			"recognition":              "Custom",
+			"custom_recognition":       "MapTrackerInfer",
+			"custom_recognition_param": inferParam,
 		},
 	}
 
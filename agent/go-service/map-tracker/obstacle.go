@@ -0,0 +1,331 @@
+// Copyright (c) 2026 Harry Huang
+package maptracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Obstacle is a place where MapTrackerMove got stuck, learned across runs
+type Obstacle struct {
+	X        int    `json:"x"`        // Location of the player when stuck
+	Y        int    `json:"y"`        // Location of the player when stuck
+	Rot      int    `json:"rot"`      // Heading the player tried to move in
+	Hits     int    `json:"hits"`     // Number of times the player got stuck here
+	Side     int    `json:"side"`     // Side to pass by, learned from the recovery step that worked: -1 left, 1 right, 0 unknown
+	LastSeen string `json:"lastSeen"` // Time the player last got stuck here
+}
+
+// center returns the estimated position of the obstacle itself, a little ahead of the player
+func (o *Obstacle) center() (float64, float64) {
+	rad := float64(o.Rot) * math.Pi / 180
+	return float64(o.X) + OBSTACLE_AHEAD*math.Sin(rad), float64(o.Y) - OBSTACLE_AHEAD*math.Cos(rad)
+}
+
+// obstacleFile is the file format of the persisted obstacles
+type obstacleFile struct {
+	Version int                   `json:"version"`
+	Maps    map[string][]Obstacle `json:"maps"` // Keyed by map name
+}
+
+var (
+	obstaclesMu sync.Mutex
+	obstacles   *obstacleFile // Loaded on first use
+)
+
+// loadObstacles returns the obstacle store, loading it on first use. Must be called with obstaclesMu held.
+func loadObstacles() *obstacleFile {
+	if obstacles != nil {
+		return obstacles
+	}
+	obstacles = &obstacleFile{Version: OBSTACLES_VERSION, Maps: make(map[string][]Obstacle)}
+
+	data, err := os.ReadFile(OBSTACLES_PATH)
+	if os.IsNotExist(err) {
+		return obstacles
+	} else if err != nil {
+		log.Warn().Err(err).Msg("Failed to read obstacle file")
+		return obstacles
+	}
+	var file obstacleFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		log.Warn().Err(err).Msg("Failed to parse obstacle file, starting over")
+		return obstacles
+	}
+	if file.Version != OBSTACLES_VERSION {
+		log.Warn().Int("version", file.Version).Msg("Unsupported obstacle file version, starting over")
+		return obstacles
+	}
+
+	// Forget obstacles not seen for a long time, since they may have been temporary
+	expire := time.Now().Add(-OBSTACLE_EXPIRE_DAYS * 24 * time.Hour)
+	count := 0
+	for mapName, list := range file.Maps {
+		kept := slices.DeleteFunc(list, func(o Obstacle) bool {
+			t, err := time.Parse(time.RFC3339, o.LastSeen)
+			return err != nil || t.Before(expire)
+		})
+		if len(kept) > 0 {
+			obstacles.Maps[mapName] = kept
+			count += len(kept)
+		}
+	}
+	log.Info().Int("obstacles", count).Msg("Obstacle memory loaded")
+	return obstacles
+}
+
+// saveObstacles writes the obstacle store. Must be called with obstaclesMu held.
+func saveObstacles() error {
+	if err := os.MkdirAll(filepath.Dir(OBSTACLES_PATH), 0755); err != nil {
+		return fmt.Errorf("failed to create obstacle directory: %w", err)
+	}
+	data, err := json.MarshalIndent(obstacles, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal obstacle file: %w", err)
+	}
+	if err := os.WriteFile(OBSTACLES_PATH, data, 0644); err != nil {
+		return fmt.Errorf("failed to write obstacle file: %w", err)
+	}
+	return nil
+}
+
+// findObstacle returns the index of the obstacle near (x, y) in the list, or -1
+func findObstacle(list []Obstacle, x, y int) int {
+	best, bestDist := -1, float64(OBSTACLE_MERGE_RADIUS)
+	for i, o := range list {
+		if dist := math.Hypot(float64(o.X-x), float64(o.Y-y)); dist <= bestDist {
+			best, bestDist = i, dist
+		}
+	}
+	return best
+}
+
+// recordObstacle remembers that the player got stuck at (x, y) while heading in rot
+func recordObstacle(mapName string, x, y, rot int) {
+	obstaclesMu.Lock()
+	defer obstaclesMu.Unlock()
+
+	store := loadObstacles()
+	list := store.Maps[mapName]
+	now := time.Now().Format(time.RFC3339)
+	if i := findObstacle(list, x, y); i >= 0 {
+		o := &list[i]
+		// Move towards the latest observation, weighted by the number of observations
+		w := 1.0 / float64(o.Hits+1)
+		o.X = int(math.Round(float64(o.X) + w*float64(x-o.X)))
+		o.Y = int(math.Round(float64(o.Y) + w*float64(y-o.Y)))
+		o.Rot = (o.Rot + int(math.Round(w*float64(calcDeltaRotation(o.Rot, rot)))) + 360) % 360
+		o.Hits = min(o.Hits+1, OBSTACLE_MAX_HITS)
+		o.LastSeen = now
+		log.Info().Str("map", mapName).Int("x", o.X).Int("y", o.Y).Int("hits", o.Hits).Msg("Known obstacle hit again")
+	} else {
+		list = append(list, Obstacle{X: x, Y: y, Rot: rot, Hits: 1, LastSeen: now})
+		log.Info().Str("map", mapName).Int("x", x).Int("y", y).Int("rot", rot).Msg("New obstacle recorded")
+	}
+	store.Maps[mapName] = list
+
+	if err := saveObstacles(); err != nil {
+		log.Warn().Err(err).Msg("Failed to save obstacle memory")
+	}
+}
+
+// resolveObstacle remembers which side the obstacle at (x, y) was passed by, from the recovery step that worked
+func resolveObstacle(mapName string, x, y int, step string) {
+	side := 0
+	switch step {
+	case RECOVERY_STRAFE_LEFT, RECOVERY_DETOUR_LEFT:
+		side = -1
+	case RECOVERY_STRAFE_RIGHT, RECOVERY_DETOUR_RIGHT:
+		side = 1
+	default:
+		return
+	}
+
+	obstaclesMu.Lock()
+	defer obstaclesMu.Unlock()
+
+	store := loadObstacles()
+	list := store.Maps[mapName]
+	i := findObstacle(list, x, y)
+	if i < 0 || list[i].Side == side {
+		return
+	}
+	list[i].Side = side
+	log.Info().Str("map", mapName).Int("x", list[i].X).Int("y", list[i].Y).Int("side", side).Msg("Obstacle side learned")
+
+	if err := saveObstacles(); err != nil {
+		log.Warn().Err(err).Msg("Failed to save obstacle memory")
+	}
+}
+
+// passedObstacle reports whether the player at (x, y) got past the place (sx, sy) where it got stuck heading in rot,
+// i.e. moved beyond the obstacle in that heading
+func passedObstacle(sx, sy, rot, x, y int) bool {
+	rad := float64(rot) * math.Pi / 180
+	ahead := float64(x-sx)*math.Sin(rad) - float64(y-sy)*math.Cos(rad)
+	return ahead > OBSTACLE_AHEAD+OBSTACLE_RADIUS
+}
+
+// getObstacles returns a copy of the obstacles on the map that were hit often enough to be trusted
+func getObstacles(mapName string) []Obstacle {
+	obstaclesMu.Lock()
+	defer obstaclesMu.Unlock()
+
+	result := make([]Obstacle, 0)
+	for _, o := range loadObstacles().Maps[mapName] {
+		if o.Hits >= OBSTACLE_MIN_HITS {
+			result = append(result, o)
+		}
+	}
+	return result
+}
+
+// obstaclePlanOptions returns planning options that keep paths away from the obstacles on the map,
+// or nil if there are none
+func obstaclePlanOptions(mapName string) *PlanOptions {
+	list := getObstacles(mapName)
+	if len(list) == 0 {
+		return nil
+	}
+
+	g := float64(PLANNER_GRID_SIZE)
+	type disc struct{ cx, cy, weight float64 }
+	discs := make([]disc, 0, len(list))
+	for _, o := range list {
+		x, y := o.center()
+		discs = append(discs, disc{x / g, y / g, OBSTACLE_COST_WEIGHT * float64(o.Hits)})
+	}
+	radius := OBSTACLE_RADIUS / g
+
+	return &PlanOptions{
+		ExtraCost: func(cx, cy int) float64 {
+			cost := 0.0
+			for _, d := range discs {
+				dist := math.Hypot(float64(cx)+0.5-d.cx, float64(cy)+0.5-d.cy)
+				if dist < radius {
+					cost += d.weight * (1 - dist/radius)
+				}
+			}
+			return cost
+		},
+	}
+}
+
+// applyObstacleDetours inserts a detour waypoint wherever a path segment runs into a known obstacle
+// in the direction the player got stuck. The detour passes the obstacle on the side learned from
+// recovery, or on the walkable side otherwise. Detours are only inserted where the walkable mask of the map
+// confirms that they can be walked; on maps without a mask, the path is kept as is.
+func applyObstacleDetours(baseMap string, path []Waypoint) []Waypoint {
+	result := make([]Waypoint, 0, len(path))
+	obstaclesByMap := make(map[string][]Obstacle)
+	masksByMap := make(map[string]*WalkMask)
+	inserted := 0
+
+	for i, w := range path {
+		if i == 0 || path[i-1].tier() != w.tier() {
+			result = append(result, w)
+			continue
+		}
+		mapName := joinMapName(baseMap, w.tier())
+		list, ok := obstaclesByMap[mapName]
+		if !ok {
+			if mask, err := getWalkMask(mapName); err == nil {
+				list = getObstacles(mapName)
+				masksByMap[mapName] = mask
+			}
+			obstaclesByMap[mapName] = list
+		}
+		mask := masksByMap[mapName]
+
+		prev := path[i-1]
+		ax, ay := float64(prev.X), float64(prev.Y)
+		dx, dy := float64(w.X)-ax, float64(w.Y)-ay
+		length := math.Hypot(dx, dy)
+		if length < 1 || len(list) == 0 {
+			result = append(result, w)
+			continue
+		}
+		ux, uy := dx/length, dy/length
+		heading := calcTargetRotation(prev.X, prev.Y, w.X, w.Y)
+
+		// Detours along this segment, ordered by their position on it
+		type detour struct {
+			t    float64
+			x, y int
+		}
+		detours := make([]detour, 0)
+		for _, o := range list {
+			if math.Abs(float64(calcDeltaRotation(heading, o.Rot))) > OBSTACLE_HEADING_TOLERANCE {
+				continue
+			}
+			ox, oy := o.center()
+			t := (ox-ax)*ux + (oy-ay)*uy
+			if t <= 0 || t >= length {
+				continue
+			}
+			px, py := ax+t*ux, ay+t*uy
+			if math.Hypot(ox-px, oy-py) > OBSTACLE_RADIUS {
+				continue
+			}
+			side := o.Side
+			if side == 0 {
+				side = walkableSide(mask, px, py, ux, uy)
+			}
+			if side == 0 {
+				continue
+			}
+			// The left normal of (ux, uy) with y pointing down is (uy, -ux)
+			x := int(math.Round(px + float64(side)*OBSTACLE_DETOUR_OFFSET*-uy))
+			y := int(math.Round(py + float64(side)*OBSTACLE_DETOUR_OFFSET*ux))
+			g := PLANNER_GRID_SIZE
+			if !mask.lineWalkable(prev.X/g, prev.Y/g, x/g, y/g) || !mask.lineWalkable(x/g, y/g, w.X/g, w.Y/g) {
+				log.Debug().Str("map", mapName).Int("x", x).Int("y", y).Msg("Detour around obstacle is not walkable, skipped")
+				continue
+			}
+			detours = append(detours, detour{t, x, y})
+		}
+		slices.SortFunc(detours, func(a, b detour) int { return int(math.Copysign(1, a.t-b.t)) })
+		for _, d := range detours {
+			result = append(result, newWaypoint([2]int{d.x, d.y}, w.tier()))
+			inserted++
+		}
+		result = append(result, w)
+	}
+
+	if inserted > 0 {
+		log.Info().Int("detours", inserted).Msg("Detours around known obstacles inserted")
+	}
+	return result
+}
+
+// walkableSide picks the side of (px, py) to pass by from the walkable mask:
+// -1 for left, 1 for right, 0 if it cannot tell
+func walkableSide(mask *WalkMask, px, py, ux, uy float64) int {
+	g := float64(PLANNER_GRID_SIZE)
+	clearance := func(side float64) float64 {
+		x := px + side*OBSTACLE_DETOUR_OFFSET*uy
+		y := py - side*OBSTACLE_DETOUR_OFFSET*ux
+		cx, cy := int(x/g), int(y/g)
+		if !mask.walkableAt(cx, cy) {
+			return -1
+		}
+		return mask.Clearance[cy*mask.W+cx]
+	}
+	left, right := clearance(1), clearance(-1)
+	switch {
+	case left < 0 && right < 0:
+		return 0
+	case left >= right:
+		return -1
+	default:
+		return 1
+	}
+}
@@ -0,0 +1,398 @@
+// Copyright (c) 2026 Harry Huang
+package maptracker
+
+import (
+	"container/heap"
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// WalkMask is a walkability grid derived from a per-map mask image.
+// Each cell covers PLANNER_GRID_SIZE x PLANNER_GRID_SIZE map pixels.
+type WalkMask struct {
+	W, H      int
+	Walkable  []bool
+	Clearance []float64 // Approximate distance (in cells) to the nearest blocked cell
+}
+
+var (
+	walkMasksMu sync.Mutex
+	walkMasks   = make(map[string]*WalkMask)
+)
+
+// getWalkMask returns the cached walkability mask of a map, loading it on first use
+func getWalkMask(mapName string) (*WalkMask, error) {
+	walkMasksMu.Lock()
+	defer walkMasksMu.Unlock()
+
+	if m, ok := walkMasks[mapName]; ok {
+		return m, nil
+	}
+
+	m, err := loadWalkMask(mapName)
+	if err != nil {
+		return nil, err
+	}
+	walkMasks[mapName] = m
+	return m, nil
+}
+
+// walkMaskPath returns the path of the walkable mask of a map, or "" if the map has none
+func walkMaskPath(mapName string) string {
+	return findResource(filepath.Join(WALKABLE_MASK_DIR, mapName+".png"))
+}
+
+// loadWalkMask loads "<WALKABLE_MASK_DIR>/<mapName>.png", where bright opaque pixels are walkable.
+// The mask uses the same pixel coordinates as the map image.
+func loadWalkMask(mapName string) (*WalkMask, error) {
+	maskPath := walkMaskPath(mapName)
+	if maskPath == "" {
+		return nil, fmt.Errorf("walkable mask not found for map %s, a draft can be generated with `go-service walkmask`", mapName)
+	}
+
+	file, err := os.Open(maskPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open walkable mask: %w", err)
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode walkable mask: %w", err)
+	}
+
+	b := img.Bounds()
+	g := PLANNER_GRID_SIZE
+	w, h := b.Dx()/g, b.Dy()/g
+	if w == 0 || h == 0 {
+		return nil, fmt.Errorf("walkable mask of map %s is too small", mapName)
+	}
+
+	// A cell is walkable only if all of its pixels are walkable
+	walkable := make([]bool, w*h)
+	for cy := range h {
+		for cx := range w {
+			ok := true
+			for dy := 0; dy < g && ok; dy++ {
+				for dx := 0; dx < g && ok; dx++ {
+					r, gg, bb, a := img.At(b.Min.X+cx*g+dx, b.Min.Y+cy*g+dy).RGBA()
+					lum := (299*r + 587*gg + 114*bb) / 1000
+					ok = a >= 0x8000 && lum >= 0x8000
+				}
+			}
+			walkable[cy*w+cx] = ok
+		}
+	}
+
+	log.Info().Str("map", mapName).Int("gridW", w).Int("gridH", h).Msg("Walkable mask loaded")
+	return &WalkMask{
+		W:         w,
+		H:         h,
+		Walkable:  walkable,
+		Clearance: computeClearance(walkable, w, h),
+	}, nil
+}
+
+// computeClearance computes a chamfer (3-4) distance transform to the nearest blocked cell
+func computeClearance(walkable []bool, w, h int) []float64 {
+	const inf = math.MaxFloat64 / 4
+	d := make([]float64, w*h)
+	for i, ok := range walkable {
+		if ok {
+			d[i] = inf
+		}
+	}
+
+	relax := func(x, y, nx, ny int, cost float64) {
+		if nx < 0 || ny < 0 || nx >= w || ny >= h {
+			return
+		}
+		if v := d[ny*w+nx] + cost; v < d[y*w+x] {
+			d[y*w+x] = v
+		}
+	}
+
+	for y := range h {
+		for x := range w {
+			relax(x, y, x-1, y, 3)
+			relax(x, y, x, y-1, 3)
+			relax(x, y, x-1, y-1, 4)
+			relax(x, y, x+1, y-1, 4)
+		}
+	}
+	for y := h - 1; y >= 0; y-- {
+		for x := w - 1; x >= 0; x-- {
+			relax(x, y, x+1, y, 3)
+			relax(x, y, x, y+1, 3)
+			relax(x, y, x+1, y+1, 4)
+			relax(x, y, x-1, y+1, 4)
+		}
+	}
+
+	for i := range d {
+		d[i] /= 3
+	}
+	return d
+}
+
+// walkableAt reports whether a cell is inside the grid and walkable
+func (m *WalkMask) walkableAt(cx, cy int) bool {
+	return cx >= 0 && cy >= 0 && cx < m.W && cy < m.H && m.Walkable[cy*m.W+cx]
+}
+
+// snap returns the nearest walkable cell to (cx, cy) within PLANNER_SNAP_RADIUS cells
+func (m *WalkMask) snap(cx, cy int) (int, int, bool) {
+	if m.walkableAt(cx, cy) {
+		return cx, cy, true
+	}
+	bestX, bestY, bestD := 0, 0, math.MaxFloat64
+	for dy := -PLANNER_SNAP_RADIUS; dy <= PLANNER_SNAP_RADIUS; dy++ {
+		for dx := -PLANNER_SNAP_RADIUS; dx <= PLANNER_SNAP_RADIUS; dx++ {
+			if !m.walkableAt(cx+dx, cy+dy) {
+				continue
+			}
+			if d := math.Hypot(float64(dx), float64(dy)); d < bestD {
+				bestX, bestY, bestD = cx+dx, cy+dy, d
+			}
+		}
+	}
+	return bestX, bestY, bestD < math.MaxFloat64
+}
+
+// lineWalkable reports whether the straight segment between two cells only crosses walkable cells
+func (m *WalkMask) lineWalkable(x0, y0, x1, y1 int) bool {
+	return lineAll(x0, y0, x1, y1, m.walkableAt)
+}
+
+// lineAll reports whether every cell crossed by the straight segment between two cells satisfies ok.
+// On diagonal steps both orthogonal neighbors count as crossed, as for the corner rule of A*.
+func lineAll(x0, y0, x1, y1 int, ok func(cx, cy int) bool) bool {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	e := dx + dy
+	for {
+		if !ok(x0, y0) {
+			return false
+		}
+		if x0 == x1 && y0 == y1 {
+			return true
+		}
+		e2 := 2 * e
+		stepX, stepY := e2 >= dy, e2 <= dx
+		if stepX && stepY && (!ok(x0+sx, y0) || !ok(x0, y0+sy)) {
+			return false
+		}
+		if stepX {
+			e += dy
+			x0 += sx
+		}
+		if stepY {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+// PlanOptions customizes path planning
+type PlanOptions struct {
+	// ExtraCost returns an additional cost for stepping into a cell, in cell units (optional)
+	ExtraCost func(cx, cy int) float64
+}
+
+// PlanPath plans a path on the walkable mask from one map coordinate to another using A*.
+// The returned waypoints are in map coordinates, simplified by line-of-sight. They end at `to` if it is walkable,
+// or else at the center of the walkable cell it is snapped to.
+func (m *WalkMask) PlanPath(from, to [2]int, opts *PlanOptions) ([][2]int, error) {
+	g := PLANNER_GRID_SIZE
+	sx, sy, ok := m.snap(from[0]/g, from[1]/g)
+	if !ok {
+		return nil, fmt.Errorf("start point %v is not near any walkable area", from)
+	}
+	gx, gy, ok := m.snap(to[0]/g, to[1]/g)
+	if !ok {
+		return nil, fmt.Errorf("target point %v is not near any walkable area", to)
+	}
+
+	cells, err := m.astar(sx, sy, gx, gy, opts)
+	if err != nil {
+		return nil, err
+	}
+	cells = m.simplify(cells, opts)
+
+	// Skip the start cell, since the player is already there
+	path := make([][2]int, 0, len(cells))
+	for _, c := range cells[1:] {
+		path = append(path, [2]int{c[0]*g + g/2, c[1]*g + g/2})
+	}
+	if m.walkableAt(to[0]/g, to[1]/g) {
+		// The last cell is the one of `to`
+		if len(path) > 0 {
+			path = path[:len(path)-1]
+		}
+		path = append(path, to)
+	} else if len(path) == 0 {
+		path = append(path, [2]int{gx*g + g/2, gy*g + g/2})
+	}
+	return path, nil
+}
+
+// astar runs 8-connected A* with a clearance penalty and returns the cell path from start to goal
+func (m *WalkMask) astar(sx, sy, gx, gy int, opts *PlanOptions) ([][2]int, error) {
+	n := m.W * m.H
+	start, goal := sy*m.W+sx, gy*m.W+gx
+
+	cost := make([]float64, n)
+	for i := range cost {
+		cost[i] = math.MaxFloat64
+	}
+	parent := make([]int32, n)
+	closed := make([]bool, n)
+
+	heuristic := func(i int) float64 {
+		dx, dy := math.Abs(float64(i%m.W-gx)), math.Abs(float64(i/m.W-gy))
+		return max(dx, dy) + (math.Sqrt2-1)*min(dx, dy)
+	}
+
+	open := &astarQueue{}
+	cost[start] = 0
+	parent[start] = -1
+	heap.Push(open, astarItem{start, heuristic(start)})
+
+	dirs := [8][2]int{{1, 0}, {-1, 0}, {0, 1}, {0, -1}, {1, 1}, {1, -1}, {-1, 1}, {-1, -1}}
+	for open.Len() > 0 {
+		cur := heap.Pop(open).(astarItem).idx
+		if closed[cur] {
+			continue
+		}
+		if cur == goal {
+			break
+		}
+		closed[cur] = true
+
+		cx, cy := cur%m.W, cur/m.W
+		for _, d := range dirs {
+			nx, ny := cx+d[0], cy+d[1]
+			if !m.walkableAt(nx, ny) {
+				continue
+			}
+			// Forbid cutting corners between two blocked cells
+			if d[0] != 0 && d[1] != 0 && (!m.walkableAt(cx+d[0], cy) || !m.walkableAt(cx, cy+d[1])) {
+				continue
+			}
+			next := ny*m.W + nx
+			if closed[next] {
+				continue
+			}
+
+			step := 1.0
+			if d[0] != 0 && d[1] != 0 {
+				step = math.Sqrt2
+			}
+			penalty := 0.0
+			if c := m.Clearance[next]; c < PLANNER_CLEARANCE_RANGE {
+				penalty = PLANNER_CLEARANCE_WEIGHT * (PLANNER_CLEARANCE_RANGE - c) / PLANNER_CLEARANCE_RANGE
+			}
+			if opts != nil && opts.ExtraCost != nil {
+				penalty += opts.ExtraCost(nx, ny)
+			}
+
+			if v := cost[cur] + step*(1+penalty); v < cost[next] {
+				cost[next] = v
+				parent[next] = int32(cur)
+				heap.Push(open, astarItem{next, v + heuristic(next)})
+			}
+		}
+	}
+
+	if cost[goal] == math.MaxFloat64 {
+		return nil, fmt.Errorf("no walkable route found")
+	}
+
+	cells := make([][2]int, 0)
+	for i := goal; i != -1; i = int(parent[i]) {
+		cells = append(cells, [2]int{i % m.W, i / m.W})
+	}
+	for i, j := 0, len(cells)-1; i < j; i, j = i+1, j-1 {
+		cells[i], cells[j] = cells[j], cells[i]
+	}
+	return cells, nil
+}
+
+// simplify reduces a cell path to the waypoints where line of sight breaks.
+// With extra costs, a shortcut must not cross cells costlier than the ones of the path it replaces.
+func (m *WalkMask) simplify(cells [][2]int, opts *PlanOptions) [][2]int {
+	if len(cells) <= 2 {
+		return cells
+	}
+	// limits[j] is the highest extra cost of the cells from the anchor to j
+	limits := make([]float64, len(cells))
+	result := [][2]int{cells[0]}
+	anchor := 0
+	for anchor < len(cells)-1 {
+		if opts != nil && opts.ExtraCost != nil {
+			limit := 0.0
+			for j := anchor; j < len(cells); j++ {
+				limit = max(limit, opts.ExtraCost(cells[j][0], cells[j][1]))
+				limits[j] = limit
+			}
+		}
+		next := anchor + 1
+		for j := len(cells) - 1; j > anchor+1; j-- {
+			if m.lineWalkable(cells[anchor][0], cells[anchor][1], cells[j][0], cells[j][1]) && lineAffordable(cells[anchor], cells[j], limits[j], opts) {
+				next = j
+				break
+			}
+		}
+		result = append(result, cells[next])
+		anchor = next
+	}
+	return result
+}
+
+// lineAffordable reports whether the straight segment between two cells crosses no cell with an extra cost above limit
+func lineAffordable(from, to [2]int, limit float64, opts *PlanOptions) bool {
+	if opts == nil || opts.ExtraCost == nil {
+		return true
+	}
+	return lineAll(from[0], from[1], to[0], to[1], func(cx, cy int) bool {
+		return opts.ExtraCost(cx, cy) <= limit
+	})
+}
+
+type astarItem struct {
+	idx int
+	f   float64
+}
+
+// astarQueue is a min-heap of A* items ordered by f score
+type astarQueue []astarItem
+
+func (q astarQueue) Len() int           { return len(q) }
+func (q astarQueue) Less(i, j int) bool { return q[i].f < q[j].f }
+func (q astarQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *astarQueue) Push(x any)        { *q = append(*q, x.(astarItem)) }
+func (q *astarQueue) Pop() any {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,359 @@
+// Copyright (c) 2026 Harry Huang
+package maptracker
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/MaaXYZ/MaaEnd/agent/go-service/pkg/maafocus"
+	"github.com/MaaXYZ/maa-framework-go/v4"
+	"github.com/rs/zerolog/log"
+)
+
+type MapTrackerRecord struct{}
+
+// MapTrackerRecordParam represents the custom_action_param for MapTrackerRecord
+type MapTrackerRecordParam struct {
+	// RouteName is the name of the recorded route (required).
+	RouteName string `json:"route_name"`
+	// MapName restricts recording to the given map and its tiers. If omitted, the map of the first location is used.
+	MapName string `json:"map_name,omitempty"`
+	// Output is the path of the route file to write. Defaults to a file in the debug directory.
+	Output string `json:"output,omitempty"`
+	// Interval is the time in milliseconds between two inferences.
+	Interval int64 `json:"interval,omitempty"`
+	// IdleTimeout is the time in milliseconds without movement, after moving, to finish recording.
+	IdleTimeout int64 `json:"idle_timeout,omitempty"`
+	// MaxDuration is the maximum recording time in milliseconds.
+	MaxDuration int64 `json:"max_duration,omitempty"`
+	// Epsilon is the Douglas-Peucker simplification tolerance in pixels.
+	Epsilon float64 `json:"epsilon,omitempty"`
+	// NoPrint controls whether to suppress printing recording status to the GUI.
+	NoPrint bool `json:"no_print,omitempty"`
+	// Session names the tracking state used during recording.
+	Session string `json:"session,omitempty"`
+}
+
+//go:embed messages/recording_started.html
+var recordingStartedHTML string
+
+//go:embed messages/recording_finished.html
+var recordingFinishedHTML string
+
+var _ maa.CustomActionRunner = &MapTrackerRecord{}
+
+// Run implements maa.CustomActionRunner
+func (a *MapTrackerRecord) Run(ctx *maa.Context, arg *maa.CustomActionArg) bool {
+	param, err := a.parseParam(arg.CustomActionParam)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to parse parameters for MapTrackerRecord")
+		return false
+	}
+
+	ctrl := ctx.GetTasker().GetController()
+	interval := time.Duration(param.Interval) * time.Millisecond
+
+	mapNameRegex := DEFAULT_INFERENCE_PARAM.MapNameRegex
+	if param.MapName != "" {
+		baseMap, _ := splitMapName(param.MapName)
+		mapNameRegex = mapFamilyRegex(baseMap)
+	}
+
+	if !param.NoPrint {
+		maafocus.NodeActionStarting(ctx, fmt.Sprintf(recordingStartedHTML, param.RouteName))
+	}
+	log.Info().Str("route", param.RouteName).Msg("Route recording started")
+
+	var (
+		mapName       = param.MapName
+		trace         []Waypoint
+		startTime     = time.Now()
+		lastInferTime = time.Time{}
+		lastMoveTime  = time.Now()
+		stopping      = false
+	)
+
+	for {
+		elapsed := time.Since(lastInferTime)
+		if elapsed < interval {
+			time.Sleep(interval - elapsed)
+		}
+		now := time.Now()
+		lastInferTime = now
+
+		if ctx.GetTasker().Stopping() {
+			log.Warn().Msg("Task is stopping, finishing route recording")
+			stopping = true
+			break
+		}
+		if now.Sub(startTime).Milliseconds() > param.MaxDuration {
+			log.Info().Msg("Max recording duration reached")
+			break
+		}
+		if len(trace) > 1 && now.Sub(lastMoveTime).Milliseconds() > param.IdleTimeout {
+			log.Info().Msg("Player stays still, finishing route recording")
+			break
+		}
+
+		result, err := runInfer(ctx, ctrl, "MapTrackerRecord_Infer", map[string]any{
+			"map_name_regex": mapNameRegex,
+			"precision":      DEFAULT_INFERENCE_PARAM_FOR_MOVE.Precision,
+			"threshold":      DEFAULT_INFERENCE_PARAM_FOR_MOVE.Threshold,
+			"session":        param.Session,
+		})
+		if err != nil {
+			log.Debug().Err(err).Msg("Inference failed during recording")
+			continue
+		}
+		// Predicted locations are not observed, so they are not recorded
+		if result.InferMode == "VirtualHit" {
+			continue
+		}
+
+		if mapName == "" {
+			mapName = result.MapName
+			mapNameRegex = mapFamilyRegex(result.BaseMap)
+			log.Info().Str("map", mapName).Msg("Recording map determined")
+		} else if !sameMapFrame(result.MapName, mapName) {
+			log.Warn().Str("expected", mapName).Str("got", result.MapName).Msg("Location on another map ignored")
+			continue
+		}
+
+		// Locations jitter by a pixel or two while standing still, which is not movement
+		p := newWaypoint([2]int{result.X, result.Y}, result.Tier)
+		if len(trace) > 0 {
+			last := trace[len(trace)-1]
+			if last.tier() == p.tier() && math.Hypot(float64(last.X-p.X), float64(last.Y-p.Y)) < STUCK_MOVE_THRESHOLD {
+				continue
+			}
+		}
+		trace = append(trace, p)
+		lastMoveTime = now
+		log.Debug().Int("x", p.X).Int("y", p.Y).Str("tier", result.Tier).Int("count", len(trace)).Msg("Location recorded")
+	}
+
+	if len(trace) < 2 {
+		log.Error().Int("count", len(trace)).Msg("Too few locations recorded, route not saved")
+		return false
+	}
+
+	path := simplifyTrace(trace, param.Epsilon)
+	outPath, err := a.save(param, mapName, path)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to save recorded route")
+		return false
+	}
+
+	log.Info().Str("route", param.RouteName).Str("map", mapName).Int("samples", len(trace)).
+		Int("points", len(path)).Str("output", outPath).Msg("Route recording finished")
+	if !param.NoPrint {
+		maafocus.NodeActionStarting(ctx, fmt.Sprintf(recordingFinishedHTML, param.RouteName, len(path), outPath))
+	}
+	return !stopping
+}
+
+func (a *MapTrackerRecord) parseParam(paramStr string) (*MapTrackerRecordParam, error) {
+	var param MapTrackerRecordParam
+	if err := json.Unmarshal([]byte(paramStr), &param); err != nil {
+		return nil, fmt.Errorf("failed to parse parameters: %w", err)
+	}
+	if param.RouteName == "" {
+		return nil, fmt.Errorf("route_name is required in parameters, got empty")
+	}
+
+	if param.Interval < 0 {
+		return nil, fmt.Errorf("interval must be non-negative")
+	} else if param.Interval == 0 {
+		param.Interval = DEFAULT_RECORD_PARAM.Interval
+	}
+
+	if param.IdleTimeout < 0 {
+		return nil, fmt.Errorf("idle_timeout must be non-negative")
+	} else if param.IdleTimeout == 0 {
+		param.IdleTimeout = DEFAULT_RECORD_PARAM.IdleTimeout
+	}
+
+	if param.MaxDuration < 0 {
+		return nil, fmt.Errorf("max_duration must be non-negative")
+	} else if param.MaxDuration == 0 {
+		param.MaxDuration = DEFAULT_RECORD_PARAM.MaxDuration
+	}
+
+	if param.Epsilon < 0 {
+		return nil, fmt.Errorf("epsilon must be non-negative")
+	} else if param.Epsilon == 0 {
+		param.Epsilon = DEFAULT_RECORD_PARAM.Epsilon
+	}
+
+	if param.Session == "" {
+		param.Session = RECORD_SESSION
+	}
+
+	return &param, nil
+}
+
+// save writes the recorded route to a routes file and returns the written path.
+// If the file exists, the route is added to it, replacing the route of the same name.
+func (a *MapTrackerRecord) save(param *MapTrackerRecordParam, mapName string, path []Waypoint) (string, error) {
+	outPath := param.Output
+	if outPath == "" {
+		name := strings.NewReplacer("/", "_", "\\", "_").Replace(param.RouteName)
+		outPath = filepath.Join("debug", "map_tracker_record", fmt.Sprintf("%s_%s.json", name, time.Now().Format("20060102_150405")))
+	}
+	if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+		return "", fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	file := routeFile{Version: ROUTES_VERSION}
+	if data, err := os.ReadFile(outPath); err == nil {
+		if err := json.Unmarshal(data, &file); err != nil {
+			return "", fmt.Errorf("failed to unmarshal existing route file, not overwritten: %w", err)
+		}
+		if file.Version != ROUTES_VERSION {
+			return "", fmt.Errorf("existing route file has unsupported version %d, not overwritten", file.Version)
+		}
+	} else if !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read existing route file: %w", err)
+	}
+
+	def := routeDef{
+		Name:        param.RouteName,
+		MapName:     mapName,
+		Path:        compactWaypoints(mapName, path),
+		Transitions: recordedTransitions(path),
+	}
+	replaced := false
+	for i := range file.Routes {
+		if file.Routes[i].Name == def.Name {
+			file.Routes[i] = def
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		file.Routes = append(file.Routes, def)
+	}
+	log.Info().Str("route", def.Name).Bool("replaced", replaced).Int("count", len(file.Routes)).Msg("Route merged into route file")
+
+	data, err := json.MarshalIndent(file, "", "    ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal route file: %w", err)
+	}
+	if err := os.WriteFile(outPath, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write route file: %w", err)
+	}
+	return outPath, nil
+}
+
+// simplifyTrace simplifies each run of the trace on the same tier separately,
+// so that the points where the tier changes are kept
+func simplifyTrace(trace []Waypoint, epsilon float64) []Waypoint {
+	result := make([]Waypoint, 0)
+	for start := 0; start < len(trace); {
+		end := start + 1
+		for end < len(trace) && trace[end].tier() == trace[start].tier() {
+			end++
+		}
+		points := make([][2]int, 0, end-start)
+		for _, w := range trace[start:end] {
+			points = append(points, [2]int{w.X, w.Y})
+		}
+		result = append(result, waypointsFromPoints(simplifyDouglasPeucker(points, epsilon), trace[start].tier())...)
+		start = end
+	}
+	return result
+}
+
+// recordedTransitions returns a transition for each observed change of tier in the path, from the last point on
+// the previous tier to the first point on the next one, so that the route loads without declared transitions
+func recordedTransitions(path []Waypoint) []TierTransition {
+	result := make([]TierTransition, 0)
+	for i := 1; i < len(path); i++ {
+		prev, next := path[i-1], path[i]
+		if prev.tier() == next.tier() {
+			continue
+		}
+		t := TierTransition{
+			Type:  TRANSITION_TYPE_RECORDED,
+			From:  prev.tier(),
+			To:    next.tier(),
+			Entry: [2]int{prev.X, prev.Y},
+			Exit:  [2]int{next.X, next.Y},
+		}
+		if !slices.Contains(result, t) {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+// compactWaypoints omits the tier of waypoints that are on the tier of mapName
+func compactWaypoints(mapName string, path []Waypoint) []Waypoint {
+	_, defaultTier := splitMapName(mapName)
+	result := make([]Waypoint, len(path))
+	for i, w := range path {
+		result[i] = w
+		if w.tier() == defaultTier {
+			result[i].Tier = nil
+		}
+	}
+	return result
+}
+
+// simplifyDouglasPeucker simplifies a polyline, keeping points farther than epsilon from the simplified line
+func simplifyDouglasPeucker(points [][2]int, epsilon float64) [][2]int {
+	if len(points) <= 2 {
+		return points
+	}
+
+	keep := make([]bool, len(points))
+	keep[0], keep[len(points)-1] = true, true
+
+	var recurse func(first, last int)
+	recurse = func(first, last int) {
+		if last-first < 2 {
+			return
+		}
+		maxDist, maxIdx := -1.0, first
+		for i := first + 1; i < last; i++ {
+			if d := pointSegmentDistance(points[i], points[first], points[last]); d > maxDist {
+				maxDist, maxIdx = d, i
+			}
+		}
+		if maxDist > epsilon {
+			keep[maxIdx] = true
+			recurse(first, maxIdx)
+			recurse(maxIdx, last)
+		}
+	}
+	recurse(0, len(points)-1)
+
+	result := make([][2]int, 0)
+	for i, p := range points {
+		if keep[i] {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// pointSegmentDistance returns the distance from p to the segment ab
+func pointSegmentDistance(p, a, b [2]int) float64 {
+	px, py := float64(p[0]), float64(p[1])
+	ax, ay := float64(a[0]), float64(a[1])
+	bx, by := float64(b[0]), float64(b[1])
+	dx, dy := bx-ax, by-ay
+	lenSq := dx*dx + dy*dy
+	if lenSq == 0 {
+		return math.Hypot(px-ax, py-ay)
+	}
+	t := max(0, min(1, ((px-ax)*dx+(py-ay)*dy)/lenSq))
+	return math.Hypot(px-(ax+t*dx), py-(ay+t*dy))
+}
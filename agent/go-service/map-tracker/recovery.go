@@ -0,0 +1,149 @@
+// Copyright (c) 2026 Harry Huang
+package maptracker
+
+import (
+	"math"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Stuck recovery steps of MapTrackerMove
+const (
+	RECOVERY_JUMP         = "jump"         // Press jump
+	RECOVERY_BACK_OFF     = "back_off"     // Walk backward
+	RECOVERY_STRAFE_LEFT  = "strafe_left"  // Walk left
+	RECOVERY_STRAFE_RIGHT = "strafe_right" // Walk right
+	RECOVERY_DETOUR_LEFT  = "detour_left"  // Turn left by the detour angle and walk forward
+	RECOVERY_DETOUR_RIGHT = "detour_right" // Turn right by the detour angle and walk forward
+	RECOVERY_REPLAN       = "replan"       // Re-plan the path from the current location
+)
+
+var allRecoverySteps = []string{
+	RECOVERY_JUMP,
+	RECOVERY_BACK_OFF,
+	RECOVERY_STRAFE_LEFT,
+	RECOVERY_STRAFE_RIGHT,
+	RECOVERY_DETOUR_LEFT,
+	RECOVERY_DETOUR_RIGHT,
+	RECOVERY_REPLAN,
+}
+
+// isRecoveryStep reports whether the name is a known stuck recovery step
+func isRecoveryStep(name string) bool {
+	return slices.Contains(allRecoverySteps, name)
+}
+
+// stuckRecovery walks through the stuck recovery ladder, one step per stuck detection
+type stuckRecovery struct {
+	mb       MovementBackend
+	rm       *rotationModel
+	param    *MapTrackerMoveParam
+	next     int       // Index of the next step in the ladder
+	lastStep time.Time // Time the last step was taken
+}
+
+func newStuckRecovery(mb MovementBackend, rm *rotationModel, param *MapTrackerMoveParam) *stuckRecovery {
+	return &stuckRecovery{mb: mb, rm: rm, param: param}
+}
+
+// reset restarts the ladder from the first step
+func (r *stuckRecovery) reset() {
+	r.next = 0
+	r.lastStep = time.Time{}
+}
+
+// exhausted reports whether all steps of the ladder have been tried
+func (r *stuckRecovery) exhausted() bool {
+	return r.next >= len(r.param.StuckRecovery)
+}
+
+// due reports whether enough time has passed since the last step to take the next one
+func (r *stuckRecovery) due(now time.Time) bool {
+	return r.lastStep.IsZero() || now.Sub(r.lastStep).Milliseconds() > r.param.StuckThreshold
+}
+
+// advance returns the next step of the ladder and marks it as taken
+func (r *stuckRecovery) advance(now time.Time) string {
+	step := r.param.StuckRecovery[r.next]
+	log.Info().Str("step", step).Int("attempt", r.next+1).Int("total", len(r.param.StuckRecovery)).Msg("Stuck detected, trying recovery step")
+	r.next++
+	r.lastStep = now
+	return step
+}
+
+// move performs a movement recovery step. Movement is stopped afterwards,
+// so that the navigation loop can re-align to the target.
+func (r *stuckRecovery) move(step string) {
+	mb, duration := r.mb, int(r.param.RecoveryDuration)
+	detour := r.rm.pixels(r.param.DetourAngle)
+
+	switch step {
+	case RECOVERY_JUMP:
+		mb.Jump(100)
+	case RECOVERY_BACK_OFF:
+		mb.MoveStop(0)
+		mb.MoveStart(MOVE_BACKWARD, duration)
+		mb.MoveStop(100)
+	case RECOVERY_STRAFE_LEFT:
+		mb.MoveStop(0)
+		mb.MoveStart(MOVE_LEFT, duration)
+		mb.MoveStop(100)
+	case RECOVERY_STRAFE_RIGHT:
+		mb.MoveStop(0)
+		mb.MoveStart(MOVE_RIGHT, duration)
+		mb.MoveStop(100)
+	case RECOVERY_DETOUR_LEFT:
+		mb.MoveStop(0)
+		mb.RotateCamera(-detour, 100, 100)
+		mb.MoveStart(MOVE_FORWARD, duration)
+		mb.MoveStop(100)
+	case RECOVERY_DETOUR_RIGHT:
+		mb.MoveStop(0)
+		mb.RotateCamera(detour, 100, 100)
+		mb.MoveStart(MOVE_FORWARD, duration)
+		mb.MoveStop(100)
+	}
+}
+
+// replanFromStuck re-plans the path at the given target index from the current location.
+// If the map has a walkable mask, a path to the current target is planned and inserted before it,
+// keeping away from known obstacles if avoidObstacles is set;
+// otherwise, navigation restarts from the path point nearest to the current location, at or after the current target,
+// so that it never goes back to targets already reached.
+// Returns the new path and the index of the next target.
+func replanFromStuck(baseMap string, path []Waypoint, idx int, cur *MapTrackerInferResult, avoidObstacles bool) ([]Waypoint, int) {
+	target := path[idx]
+	targetMap := joinMapName(baseMap, target.tier())
+
+	if cur.MapName == targetMap {
+		if mask, err := getWalkMask(targetMap); err == nil {
+			var opts *PlanOptions
+			if avoidObstacles {
+				opts = obstaclePlanOptions(targetMap)
+			}
+			planned, err := mask.PlanPath([2]int{cur.X, cur.Y}, [2]int{target.X, target.Y}, opts)
+			if err == nil && len(planned) > 1 {
+				detour := waypointsFromPoints(planned, target.tier())
+				newPath := slices.Concat(path[:idx], detour[:len(detour)-1], path[idx:])
+				log.Info().Int("index", idx).Int("waypoints", len(detour)).Msg("Re-planned a detour to the current target")
+				return newPath, idx
+			}
+			log.Debug().Err(err).Msg("Re-planning on walkable mask did not find a detour")
+		}
+	}
+
+	nearestIdx, minDist := idx, math.MaxFloat64
+	for i := idx; i < len(path); i++ {
+		p := path[i]
+		if joinMapName(baseMap, p.tier()) != cur.MapName {
+			continue
+		}
+		if dist := math.Hypot(float64(cur.X-p.X), float64(cur.Y-p.Y)); dist < minDist {
+			nearestIdx, minDist = i, dist
+		}
+	}
+	log.Info().Int("from_index", idx).Int("to_index", nearestIdx).Float64("dist", minDist).Msg("Restarting navigation from the nearest path point")
+	return path, nearestIdx
+}
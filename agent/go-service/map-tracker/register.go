@@ -3,11 +3,14 @@ package maptracker
 
 import "github.com/MaaXYZ/maa-framework-go/v4"
 
-// Register registers all custom recognition components for map-tracker package
+// Register registers all custom recognition and action components for map-tracker package
 func Register() {
 	ensureResourcePathSink()
 
 	maa.AgentServerRegisterCustomRecognition("MapTrackerInfer", &MapTrackerInfer{})
 	maa.AgentServerRegisterCustomRecognition("MapTrackerAssertLocation", &MapTrackerAssertLocation{})
 	maa.AgentServerRegisterCustomAction("MapTrackerMove", &MapTrackerMove{})
+	maa.AgentServerRegisterCustomAction("MapTrackerReset", &MapTrackerReset{})
+	maa.AgentServerRegisterCustomAction("MapTrackerRecord", &MapTrackerRecord{})
+	maa.AgentServerRegisterCustomAction("MapTrackerCalibrate", &MapTrackerCalibrate{})
 }
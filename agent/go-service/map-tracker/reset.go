@@ -0,0 +1,49 @@
+// Copyright (c) 2026 Harry Huang
+package maptracker
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/MaaXYZ/maa-framework-go/v4"
+	"github.com/rs/zerolog/log"
+)
+
+type MapTrackerReset struct{}
+
+// MapTrackerResetParam represents the custom_action_param for MapTrackerReset
+type MapTrackerResetParam struct {
+	// Session is the tracking session to clear. If omitted, all sessions of the current tasker are cleared.
+	Session *string `json:"session,omitempty"`
+}
+
+var _ maa.CustomActionRunner = &MapTrackerReset{}
+
+// Run implements maa.CustomActionRunner
+func (a *MapTrackerReset) Run(ctx *maa.Context, arg *maa.CustomActionArg) bool {
+	param, err := a.parseParam(arg.CustomActionParam)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to parse parameters for MapTrackerReset")
+		return false
+	}
+
+	var count int
+	if param.Session != nil {
+		count = resetInferStates(ctx, *param.Session, false)
+		log.Info().Str("session", *param.Session).Int("count", count).Msg("Map tracking session state reset")
+	} else {
+		count = resetInferStates(ctx, "", true)
+		log.Info().Int("count", count).Msg("All map tracking session states of current tasker reset")
+	}
+	return true
+}
+
+func (a *MapTrackerReset) parseParam(paramStr string) (*MapTrackerResetParam, error) {
+	var param MapTrackerResetParam
+	if paramStr != "" {
+		if err := json.Unmarshal([]byte(paramStr), &param); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal parameters: %w", err)
+		}
+	}
+	return &param, nil
+}
@@ -20,10 +20,26 @@ var (
 func ensureResourcePathSink() {
 	registerSinkOnce.Do(func() {
 		maa.AgentServerAddResourceSink(&resourcePathSink{})
-		log.Debug().Msg("Resource path sink registered for map-tracker")
+		maa.AgentServerAddTaskerSink(&inferStateSink{})
+		log.Debug().Msg("Resource path and tracking state sinks registered for map-tracker")
 	})
 }
 
+// AddResourcePathSink registers the resource path sink on a standalone resource,
+// which is needed when map-tracker runs outside the agent server (e.g. offline replay)
+func AddResourcePathSink(res *maa.Resource) {
+	res.AddSink(&resourcePathSink{})
+}
+
+// SetResourcePath sets the resource path directly,
+// which is needed by offline tools that do not load a resource at all (e.g. benchmark)
+func SetResourcePath(path string) {
+	if p, err := filepath.Abs(path); err == nil {
+		path = p
+	}
+	resourcePath.Store(path)
+}
+
 type resourcePathSink struct{}
 
 // OnResourceLoading captures the resource path when a resource is loaded
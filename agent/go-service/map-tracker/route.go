@@ -0,0 +1,239 @@
+// Copyright (c) 2026 Harry Huang
+package maptracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Route is a resolved named route
+type Route struct {
+	Name string
+	// MapName is the base map name of the route
+	MapName string
+	// Path is the waypoints of the route, with explicit tiers and transitions inserted
+	Path []Waypoint
+	// Transitions is the transitions between tiers recorded with the route and the routes it concatenates
+	Transitions []TierTransition
+}
+
+// routeFile represents the content of the routes file
+type routeFile struct {
+	// Version is the format version of the routes file, must be ROUTES_VERSION.
+	Version int `json:"version"`
+	// Routes is the list of route definitions.
+	Routes []routeDef `json:"routes"`
+}
+
+// routeDef represents a route definition in the routes file.
+// A route either defines its own map_name and path, or concatenates other routes.
+type routeDef struct {
+	// Name is the unique name of the route, e.g. "Wuling/DailyMineLoop".
+	Name string `json:"name"`
+	// MapName is the name of the map the route is on.
+	MapName string `json:"map_name,omitempty"`
+	// Path is the sequence of waypoints of the route.
+	Path []Waypoint `json:"path,omitempty"`
+	// Concat is a list of route names to concatenate in order. They must be on the same base map.
+	Concat []string `json:"concat,omitempty"`
+	// Reverse reverses the resolved points of the route.
+	Reverse bool `json:"reverse,omitempty"`
+	// Transitions is the transitions between tiers taken by the route, in addition to the declared ones.
+	// MapTrackerRecord writes the tier changes it observes here.
+	Transitions []TierTransition `json:"transitions,omitempty"`
+}
+
+var (
+	routesMu     sync.Mutex
+	routesPath   string
+	routesLoaded map[string]*Route
+)
+
+// getRoute returns the named route from the routes file of the current resource
+func getRoute(name string) (*Route, error) {
+	path := findResource(ROUTES_PATH)
+	if path == "" {
+		return nil, fmt.Errorf("routes file not found")
+	}
+
+	routesMu.Lock()
+	defer routesMu.Unlock()
+
+	if routesLoaded == nil || routesPath != path {
+		routes, err := loadRoutes(path)
+		if err != nil {
+			return nil, err
+		}
+		routesPath, routesLoaded = path, routes
+	}
+
+	route, ok := routesLoaded[name]
+	if !ok {
+		return nil, fmt.Errorf("route %q not found", name)
+	}
+	return route, nil
+}
+
+// loadRoutes loads, resolves and validates all routes in the routes file.
+// Invalid routes are logged and skipped, so that they do not make the other routes unavailable.
+func loadRoutes(path string) (map[string]*Route, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read routes file: %w", err)
+	}
+
+	var file routeFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal routes file: %w", err)
+	}
+	if file.Version != ROUTES_VERSION {
+		return nil, fmt.Errorf("unsupported routes file version %d, expected %d", file.Version, ROUTES_VERSION)
+	}
+
+	defs := make(map[string]*routeDef, len(file.Routes))
+	for i := range file.Routes {
+		def := &file.Routes[i]
+		if def.Name == "" {
+			log.Error().Int("index", i).Msg("Route has no name, skipped")
+			continue
+		}
+		if _, ok := defs[def.Name]; ok {
+			log.Error().Str("route", def.Name).Msg("Duplicate route name, skipped")
+			continue
+		}
+		defs[def.Name] = def
+	}
+
+	bboxes, err := loadMapBBoxes()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &routeResolver{defs: defs, bboxes: bboxes, resolved: make(map[string]*Route), visiting: make(map[string]bool)}
+	skipped := 0
+	for name := range defs {
+		if _, err := r.resolve(name); err != nil {
+			log.Error().Err(err).Str("route", name).Msg("Invalid route, skipped")
+			skipped++
+		}
+	}
+
+	log.Info().Str("path", path).Int("count", len(r.resolved)).Int("skipped", skipped).Msg("Routes loaded")
+	return r.resolved, nil
+}
+
+// routeResolver resolves route compositions with cycle detection
+type routeResolver struct {
+	defs     map[string]*routeDef
+	bboxes   map[string][]int
+	resolved map[string]*Route
+	visiting map[string]bool
+}
+
+func (r *routeResolver) resolve(name string) (*Route, error) {
+	if route, ok := r.resolved[name]; ok {
+		return route, nil
+	}
+	def, ok := r.defs[name]
+	if !ok {
+		return nil, fmt.Errorf("route %q not found", name)
+	}
+	if r.visiting[name] {
+		return nil, fmt.Errorf("route %q is composed of itself", name)
+	}
+	r.visiting[name] = true
+	defer delete(r.visiting, name)
+
+	for i, t := range def.Transitions {
+		if t.From == t.To {
+			return nil, fmt.Errorf("route %q: transition %d connects tier %q to itself", name, i, t.From)
+		}
+	}
+	route := &Route{Name: name, Transitions: slices.Clone(def.Transitions)}
+	switch {
+	case len(def.Concat) > 0 && (def.MapName != "" || len(def.Path) > 0):
+		return nil, fmt.Errorf("route %q: concat cannot be combined with map_name or path", name)
+	case len(def.Concat) > 0:
+		for _, part := range def.Concat {
+			sub, err := r.resolve(part)
+			if err != nil {
+				return nil, fmt.Errorf("route %q: %w", name, err)
+			}
+			if route.MapName == "" {
+				route.MapName = sub.MapName
+			} else if !sameMapFrame(route.MapName, sub.MapName) {
+				return nil, fmt.Errorf("route %q: cannot concat route %q on map %s with map %s", name, part, sub.MapName, route.MapName)
+			}
+			route.Path = append(route.Path, sub.Path...)
+			route.Transitions = append(route.Transitions, sub.Transitions...)
+		}
+	default:
+		if def.MapName == "" {
+			return nil, fmt.Errorf("route %q: map_name is required", name)
+		}
+		if len(def.Path) == 0 {
+			return nil, fmt.Errorf("route %q: path is required", name)
+		}
+		route.MapName, route.Path = resolveWaypoints(def.MapName, def.Path)
+	}
+
+	if def.Reverse {
+		slices.Reverse(route.Path)
+	}
+
+	// Routes may span tiers through their own or declared transitions
+	path, err := expandTierTransitions(route.MapName, route.Path, route.Transitions)
+	if err != nil {
+		return nil, fmt.Errorf("route %q: %w", name, err)
+	}
+	route.Path = path
+	if err := validateWaypointActions(route.Path); err != nil {
+		return nil, fmt.Errorf("route %q: %w", name, err)
+	}
+	if err := validateRoute(route, r.bboxes); err != nil {
+		return nil, err
+	}
+
+	r.resolved[name] = route
+	return route, nil
+}
+
+// validateRoute checks that all points of the route lie inside the bbox of the map (or tier) they are on,
+// expanded as for matching
+func validateRoute(route *Route, bboxes map[string][]int) error {
+	expand := LOC_RADIUS / 2
+	for i, p := range route.Path {
+		mapName := joinMapName(route.MapName, p.tier())
+		bbox, ok := bboxes[mapName]
+		if !ok || len(bbox) != 4 {
+			return fmt.Errorf("route %q: map %s not found in map_bbox.json", route.Name, mapName)
+		}
+		if p.X < bbox[0]-expand || p.X >= bbox[2]+expand || p.Y < bbox[1]-expand || p.Y >= bbox[3]+expand {
+			return fmt.Errorf("route %q: point %d [%d, %d] is outside the bbox %v of map %s", route.Name, i, p.X, p.Y, bbox, mapName)
+		}
+	}
+	return nil
+}
+
+// loadMapBBoxes reads map_bbox.json from the map directory
+func loadMapBBoxes() (map[string][]int, error) {
+	mapDir := findResource(MAP_DIR)
+	if mapDir == "" {
+		return nil, fmt.Errorf("map directory not found")
+	}
+	data, err := os.ReadFile(filepath.Join(mapDir, "map_bbox.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read map_bbox.json: %w", err)
+	}
+	bboxes := make(map[string][]int)
+	if err := json.Unmarshal(data, &bboxes); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal map_bbox.json: %w", err)
+	}
+	return bboxes, nil
+}
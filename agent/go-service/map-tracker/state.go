@@ -0,0 +1,118 @@
+// Copyright (c) 2026 Harry Huang
+package maptracker
+
+import (
+	"sync"
+
+	"github.com/MaaXYZ/maa-framework-go/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// InferState holds the time-series filters of one tracking session
+type InferState struct {
+	loc locationFilter
+	rot headingFilter
+
+	minimap            *minimapGeometry // Detected minimap geometry, nil until the first inference
+	minimapW, minimapH int              // Screen size the minimap geometry was determined for
+	minimapMisses      int              // Consecutive location misses since the last detection
+
+	mu sync.Mutex
+}
+
+// resetTracking forgets the location and heading filters, keeping the detected minimap geometry
+func (s *InferState) resetTracking() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loc = locationFilter{}
+	s.rot = headingFilter{}
+}
+
+// inferStateKey identifies a tracking session: a tasker plus an optional session name
+type inferStateKey struct {
+	tasker  maa.Tasker
+	session string
+}
+
+var (
+	inferStatesMu sync.Mutex
+	inferStates   = make(map[inferStateKey]*InferState)
+)
+
+// newInferStateKey builds the session key from the tasker of ctx (if any) and the session name
+func newInferStateKey(ctx *maa.Context, session string) inferStateKey {
+	key := inferStateKey{session: session}
+	if ctx != nil {
+		if tasker := ctx.GetTasker(); tasker != nil {
+			key.tasker = *tasker
+		}
+	}
+	return key
+}
+
+// getInferState returns the inference state of the given session, creating it if needed
+func getInferState(ctx *maa.Context, session string) *InferState {
+	key := newInferStateKey(ctx, session)
+
+	inferStatesMu.Lock()
+	defer inferStatesMu.Unlock()
+
+	state, ok := inferStates[key]
+	if !ok {
+		state = &InferState{}
+		inferStates[key] = state
+	}
+	return state
+}
+
+// resetInferStates clears the inference state of the given session of the tasker of ctx.
+// If allSessions is set, every session of that tasker is cleared. Returns the number of cleared states.
+func resetInferStates(ctx *maa.Context, session string, allSessions bool) int {
+	target := newInferStateKey(ctx, session)
+
+	inferStatesMu.Lock()
+	defer inferStatesMu.Unlock()
+
+	count := 0
+	for key := range inferStates {
+		if key.tasker != target.tasker {
+			continue
+		}
+		if !allSessions && key.session != target.session {
+			continue
+		}
+		delete(inferStates, key)
+		count++
+	}
+	return count
+}
+
+// dropTaskerInferStates clears every session of a tasker. Returns the number of cleared states.
+func dropTaskerInferStates(tasker maa.Tasker) int {
+	inferStatesMu.Lock()
+	defer inferStatesMu.Unlock()
+
+	count := 0
+	for key := range inferStates {
+		if key.tasker == tasker {
+			delete(inferStates, key)
+			count++
+		}
+	}
+	return count
+}
+
+// inferStateSink drops the tracking states of a tasker when its task ends.
+// The agent is not told when a tasker is destroyed, but by then its last task has ended,
+// so that states do not pile up and a new tasker reusing the handle starts afresh.
+type inferStateSink struct{}
+
+// OnTaskerTask handles tasker task events
+func (s *inferStateSink) OnTaskerTask(tasker *maa.Tasker, event maa.EventStatus, detail maa.TaskerTaskDetail) {
+	if tasker == nil || (event != maa.EventStatusSucceeded && event != maa.EventStatusFailed) {
+		return
+	}
+	if count := dropTaskerInferStates(*tasker); count > 0 {
+		log.Debug().Str("entry", detail.Entry).Int("count", count).Msg("Task ended, tracking states dropped")
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright (c) 2026 Harry Huang
+package maptracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Tier maps are floors or sub-areas of a base map, named "<base>_tier_<tier>".
+// They share the coordinate system of their base map.
+var tierMapNameRegex = regexp.MustCompile(`^(.+)_tier_(\d+)$`)
+
+// splitMapName splits a map name into its base map name and tier ("" for the base map itself)
+func splitMapName(mapName string) (string, string) {
+	if m := tierMapNameRegex.FindStringSubmatch(mapName); m != nil {
+		return m[1], m[2]
+	}
+	return mapName, ""
+}
+
+// joinMapName builds a map name from a base map name and a tier ("" for the base map itself)
+func joinMapName(baseMap, tier string) string {
+	if tier == "" {
+		return baseMap
+	}
+	return baseMap + "_tier_" + tier
+}
+
+// sameMapFrame reports whether two maps share the same coordinate system (same base map)
+func sameMapFrame(a, b string) bool {
+	baseA, _ := splitMapName(a)
+	baseB, _ := splitMapName(b)
+	return baseA == baseB
+}
+
+// mapFamilyRegex builds a regex matching a base map and all of its tiers
+func mapFamilyRegex(baseMap string) string {
+	return "^" + regexp.QuoteMeta(baseMap) + `(_tier_\d+)?$`
+}
+
+// mapNamesRegex builds an exact-match regex for the given map names
+func mapNamesRegex(mapNames []string) string {
+	quoted := make([]string, 0, len(mapNames))
+	for _, name := range mapNames {
+		quoted = append(quoted, regexp.QuoteMeta(name))
+	}
+	return "^(" + strings.Join(quoted, "|") + ")$"
+}
+
+// TierTransition is a declared connection (stairs, lift, etc.) between two tiers of a base map
+type TierTransition struct {
+	// Name is a human-readable name of the transition (optional).
+	Name string `json:"name,omitempty"`
+	// Type is the kind of the transition, e.g. "stairs" or "lift" (optional).
+	Type string `json:"type,omitempty"`
+	// From is the tier the transition starts on, "" for the base map.
+	From string `json:"from"`
+	// To is the tier the transition ends on, "" for the base map.
+	To string `json:"to"`
+	// Entry is the [x, y] point on From where the transition starts.
+	Entry [2]int `json:"entry"`
+	// Exit is the [x, y] point on To where the transition ends.
+	Exit [2]int `json:"exit"`
+	// Bidirectional allows the transition to be used from To to From as well.
+	Bidirectional bool `json:"bidirectional,omitempty"`
+}
+
+// tierMeta represents the tier metadata of a base map
+type tierMeta struct {
+	Transitions []TierTransition `json:"transitions"`
+}
+
+var (
+	tierMetasMu   sync.Mutex
+	tierMetasPath string
+	tierMetas     map[string]tierMeta
+)
+
+// getTierMetas returns the tier metadata of all base maps, reloading it if the resource changed.
+// A missing metadata file is treated as no transitions declared.
+func getTierMetas() (map[string]tierMeta, error) {
+	path := findResource(filepath.Join(MAP_DIR, MAP_TIERS_FILE))
+
+	tierMetasMu.Lock()
+	defer tierMetasMu.Unlock()
+
+	if tierMetas != nil && tierMetasPath == path {
+		return tierMetas, nil
+	}
+
+	metas := make(map[string]tierMeta)
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", MAP_TIERS_FILE, err)
+		}
+		if err := json.Unmarshal(data, &metas); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal %s: %w", MAP_TIERS_FILE, err)
+		}
+		for baseMap, meta := range metas {
+			for i, t := range meta.Transitions {
+				if t.From == t.To {
+					return nil, fmt.Errorf("transition %d of map %s connects tier %q to itself", i, baseMap, t.From)
+				}
+			}
+		}
+		log.Info().Str("path", path).Int("count", len(metas)).Msg("Map tier metadata loaded")
+	}
+
+	tierMetasPath, tierMetas = path, metas
+	return metas, nil
+}
+
+// findTransition finds a transition from one tier of a base map to another, among the given local transitions
+// (such as those recorded with a route) first, then among the declared ones.
+// A bidirectional transition used backwards is returned with From/To and Entry/Exit swapped.
+func findTransition(baseMap, from, to string, local []TierTransition) (*TierTransition, error) {
+	if t := matchTransition(local, from, to); t != nil {
+		return t, nil
+	}
+	metas, err := getTierMetas()
+	if err != nil {
+		return nil, err
+	}
+	if t := matchTransition(metas[baseMap].Transitions, from, to); t != nil {
+		return t, nil
+	}
+	return nil, fmt.Errorf("no transition declared from tier %q to tier %q of map %s", from, to, baseMap)
+}
+
+// matchTransition returns a copy of the first transition from one tier to another, or nil if there is none
+func matchTransition(transitions []TierTransition, from, to string) *TierTransition {
+	for _, t := range transitions {
+		if t.From == from && t.To == to {
+			return &t
+		}
+		if t.Bidirectional && t.From == to && t.To == from {
+			t.From, t.To = t.To, t.From
+			t.Entry, t.Exit = t.Exit, t.Entry
+			return &t
+		}
+	}
+	return nil
+}
+
+// connectsWaypoints reports whether one of the transitions goes from one waypoint straight to the other,
+// as recorded transitions do, so that nothing needs to be inserted between them
+func connectsWaypoints(transitions []TierTransition, from, to Waypoint) bool {
+	for _, t := range transitions {
+		if t.Bidirectional && t.From == to.tier() && t.To == from.tier() {
+			t.From, t.To = t.To, t.From
+			t.Entry, t.Exit = t.Exit, t.Entry
+		}
+		if t.From == from.tier() && t.To == to.tier() && t.Entry == [2]int{from.X, from.Y} && t.Exit == [2]int{to.X, to.Y} {
+			return true
+		}
+	}
+	return false
+}
+
+// expandTierTransitions inserts the entry and exit points of the transitions
+// between consecutive waypoints on different tiers. All waypoints must have explicit tiers.
+// Local transitions are tried before the declared ones, see findTransition.
+// The expansion is idempotent: already present entry and exit points are not inserted again.
+func expandTierTransitions(baseMap string, path []Waypoint, local []TierTransition) ([]Waypoint, error) {
+	if len(path) < 2 {
+		return path, nil
+	}
+	result := make([]Waypoint, 0, len(path))
+	result = append(result, path[0])
+	for _, next := range path[1:] {
+		prev := result[len(result)-1]
+		prevTier, nextTier := prev.tier(), next.tier()
+		if prevTier != nextTier && !connectsWaypoints(local, prev, next) {
+			t, err := findTransition(baseMap, prevTier, nextTier, local)
+			if err != nil {
+				return nil, err
+			}
+			entry := newWaypoint(t.Entry, t.From)
+			exit := newWaypoint(t.Exit, t.To)
+			if !prev.samePoint(entry) {
+				result = append(result, entry)
+			}
+			if !next.samePoint(exit) {
+				result = append(result, exit)
+			}
+		}
+		result = append(result, next)
+	}
+	return result, nil
+}
+
+// pathMapNames returns the distinct map names the waypoints are on, in order of appearance
+func pathMapNames(baseMap string, path []Waypoint) []string {
+	names := make([]string, 0)
+	for _, w := range path {
+		name := joinMapName(baseMap, w.tier())
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,423 @@
+// Copyright (c) 2026 Harry Huang
+package maptracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/MaaXYZ/MaaEnd/agent/go-service/pkg/minicv"
+	"github.com/rs/zerolog/log"
+)
+
+// Trajectory event kinds of MapTrackerMove
+const (
+	TRAJECTORY_EVENT_TARGET_REACHED = "target_reached" // A target point is reached
+	TRAJECTORY_EVENT_STUCK          = "stuck"          // A stuck recovery step is taken
+	TRAJECTORY_EVENT_EMERGENCY_STOP = "emergency_stop" // Navigation is aborted by an emergency stop
+	TRAJECTORY_EVENT_ACTION_FAILED  = "action_failed"  // A waypoint action failed
+	TRAJECTORY_EVENT_STOPPED        = "stopped"        // The task is stopping
+	TRAJECTORY_EVENT_DISCONTINUITY  = "discontinuity"  // A teleport or loading screen is detected
+)
+
+// Colors of the trajectory rendering
+var (
+	trajectoryPathColor   = color.RGBA{64, 128, 255, 255}
+	trajectoryWalkColor   = color.RGBA{255, 48, 48, 255}
+	trajectoryStartColor  = color.RGBA{48, 220, 48, 255}
+	trajectoryStuckColor  = color.RGBA{255, 160, 0, 255}
+	trajectoryStopColor   = color.RGBA{255, 0, 255, 255}
+	trajectoryReachColor  = color.RGBA{48, 220, 48, 255}
+	trajectoryTargetColor = color.RGBA{255, 255, 255, 255}
+)
+
+// trajectoryPoint is an inferred location during navigation
+type trajectoryPoint struct {
+	timeMs  int64 // Time since the start of navigation
+	mapName string
+	x, y    int
+	rot     int
+}
+
+// trajectoryEvent is something that happened during navigation, at the last inferred location
+type trajectoryEvent struct {
+	kind    string
+	timeMs  int64
+	index   int // Index of the current target
+	detail  string
+	mapName string
+	x, y    int
+}
+
+// trajectoryRecorder collects the trajectory of a MapTrackerMove run and dumps it to the debug directory.
+// A nil recorder records nothing, so that callers need not check whether recording is enabled.
+type trajectoryRecorder struct {
+	name      string // Route or map name, used for the output file names
+	baseMap   string
+	startTime time.Time
+	path      []Waypoint // Current path, updated when re-planned
+	points    []trajectoryPoint
+	events    []trajectoryEvent
+}
+
+// newTrajectoryRecorder returns a recorder for the run, or nil if trajectory export is disabled
+func newTrajectoryRecorder(param *MapTrackerMoveParam) *trajectoryRecorder {
+	if !param.Trajectory {
+		return nil
+	}
+	name := param.Route
+	if name == "" {
+		name = param.MapName
+	}
+	baseMap, _ := splitMapName(param.MapName)
+	return &trajectoryRecorder{
+		name:      strings.NewReplacer("/", "_", "\\", "_").Replace(name),
+		baseMap:   baseMap,
+		startTime: time.Now(),
+	}
+}
+
+// setPath records the path being followed
+func (t *trajectoryRecorder) setPath(path []Waypoint) {
+	if t == nil {
+		return
+	}
+	t.path = append([]Waypoint(nil), path...)
+}
+
+// addPoint records an inferred location
+func (t *trajectoryRecorder) addPoint(result *MapTrackerInferResult) {
+	if t == nil {
+		return
+	}
+	t.points = append(t.points, trajectoryPoint{
+		timeMs:  time.Since(t.startTime).Milliseconds(),
+		mapName: result.MapName,
+		x:       result.X,
+		y:       result.Y,
+		rot:     result.Rot,
+	})
+}
+
+// addEvent records an event at the last inferred location
+func (t *trajectoryRecorder) addEvent(kind string, index int, detail string) {
+	if t == nil {
+		return
+	}
+	e := trajectoryEvent{
+		kind:   kind,
+		timeMs: time.Since(t.startTime).Milliseconds(),
+		index:  index,
+		detail: detail,
+	}
+	if len(t.points) > 0 {
+		last := t.points[len(t.points)-1]
+		e.mapName, e.x, e.y = last.mapName, last.x, last.y
+	} else if index >= 0 && index < len(t.path) {
+		// Nothing inferred yet, so place the event at the target
+		w := t.path[index]
+		e.mapName, e.x, e.y = joinMapName(t.baseMap, w.tier()), w.X, w.Y
+	}
+	t.events = append(t.events, e)
+}
+
+// save writes the trajectory as GeoJSON, and one PNG rendering per map it visits.
+// Failures are logged only, since the dump must not affect navigation.
+func (t *trajectoryRecorder) save(finished bool) {
+	if t == nil {
+		return
+	}
+	dir := filepath.Join("debug", "map_tracker_trajectory")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Warn().Err(err).Msg("Failed to create trajectory directory")
+		return
+	}
+	prefix := filepath.Join(dir, fmt.Sprintf("%s_%s", t.name, t.startTime.Format("20060102_150405")))
+
+	data, err := json.MarshalIndent(t.geoJSON(finished), "", "    ")
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to marshal trajectory")
+		return
+	}
+	if err := os.WriteFile(prefix+".geojson", data, 0644); err != nil {
+		log.Warn().Err(err).Msg("Failed to write trajectory")
+		return
+	}
+
+	for _, mapName := range t.mapNames() {
+		outPath := fmt.Sprintf("%s_%s.png", prefix, mapName)
+		if err := t.render(mapName, outPath); err != nil {
+			log.Warn().Err(err).Str("map", mapName).Msg("Failed to render trajectory")
+		}
+	}
+
+	log.Info().Str("path", prefix+".geojson").Int("points", len(t.points)).Int("events", len(t.events)).
+		Bool("finished", finished).Msg("Trajectory saved")
+}
+
+// mapNames returns the maps the path, the trajectory or the events are on, in order of appearance
+func (t *trajectoryRecorder) mapNames() []string {
+	names := make([]string, 0)
+	add := func(name string) {
+		if name != "" && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	for _, w := range t.path {
+		add(joinMapName(t.baseMap, w.tier()))
+	}
+	for _, p := range t.points {
+		add(p.mapName)
+	}
+	for _, e := range t.events {
+		add(e.mapName)
+	}
+	return names
+}
+
+// GeoJSON objects. Coordinates are map pixels [x, y], with y pointing down.
+type geoJSONFeatureCollection struct {
+	Type      string           `json:"type"`
+	Name      string           `json:"name"`
+	StartTime string           `json:"startTime"`
+	Finished  bool             `json:"finished"`
+	Features  []geoJSONFeature `json:"features"`
+}
+
+type geoJSONFeature struct {
+	Type       string          `json:"type"`
+	Geometry   geoJSONGeometry `json:"geometry"`
+	Properties map[string]any  `json:"properties"`
+}
+
+type geoJSONGeometry struct {
+	Type        string `json:"type"`
+	Coordinates any    `json:"coordinates"`
+}
+
+// geoJSON converts the trajectory to a GeoJSON feature collection
+func (t *trajectoryRecorder) geoJSON(finished bool) geoJSONFeatureCollection {
+	features := make([]geoJSONFeature, 0)
+
+	// Planned path, split where the tier changes
+	for start := 0; start < len(t.path); {
+		end := start + 1
+		for end < len(t.path) && t.path[end].tier() == t.path[start].tier() {
+			end++
+		}
+		coords := make([][2]int, 0, end-start)
+		for _, w := range t.path[start:end] {
+			coords = append(coords, [2]int{w.X, w.Y})
+		}
+		features = append(features, geoJSONLine(coords, map[string]any{
+			"kind": "path",
+			"map":  joinMapName(t.baseMap, t.path[start].tier()),
+		}))
+		start = end
+	}
+	for i, w := range t.path {
+		features = append(features, geoJSONPoint(w.X, w.Y, map[string]any{
+			"kind":    "target",
+			"index":   i,
+			"map":     joinMapName(t.baseMap, w.tier()),
+			"actions": len(w.Actions),
+		}))
+	}
+
+	// Walked trajectory, split where the map changes
+	for start := 0; start < len(t.points); {
+		end := start + 1
+		for end < len(t.points) && t.points[end].mapName == t.points[start].mapName {
+			end++
+		}
+		coords := make([][2]int, 0, end-start)
+		times := make([]int64, 0, end-start)
+		rots := make([]int, 0, end-start)
+		for _, p := range t.points[start:end] {
+			coords = append(coords, [2]int{p.x, p.y})
+			times = append(times, p.timeMs)
+			rots = append(rots, p.rot)
+		}
+		features = append(features, geoJSONLine(coords, map[string]any{
+			"kind":   "trajectory",
+			"map":    t.points[start].mapName,
+			"timeMs": times,
+			"rot":    rots,
+		}))
+		start = end
+	}
+
+	for _, e := range t.events {
+		props := map[string]any{
+			"kind":   e.kind,
+			"map":    e.mapName,
+			"timeMs": e.timeMs,
+			"index":  e.index,
+		}
+		if e.detail != "" {
+			props["detail"] = e.detail
+		}
+		features = append(features, geoJSONPoint(e.x, e.y, props))
+	}
+
+	return geoJSONFeatureCollection{
+		Type:      "FeatureCollection",
+		Name:      t.name,
+		StartTime: t.startTime.Format(time.RFC3339),
+		Finished:  finished,
+		Features:  features,
+	}
+}
+
+func geoJSONPoint(x, y int, props map[string]any) geoJSONFeature {
+	return geoJSONFeature{
+		Type:       "Feature",
+		Geometry:   geoJSONGeometry{Type: "Point", Coordinates: [2]int{x, y}},
+		Properties: props,
+	}
+}
+
+func geoJSONLine(coords [][2]int, props map[string]any) geoJSONFeature {
+	// A LineString needs at least two positions
+	if len(coords) == 1 {
+		coords = append(coords, coords[0])
+	}
+	return geoJSONFeature{
+		Type:       "Feature",
+		Geometry:   geoJSONGeometry{Type: "LineString", Coordinates: coords},
+		Properties: props,
+	}
+}
+
+// render draws the path, the trajectory and the events on the given map over the map image.
+// The image is cropped to the drawn area and enlarged, so that single steps stay visible.
+func (t *trajectoryRecorder) render(mapName, outPath string) error {
+	mapPath := findResource(filepath.Join(MAP_DIR, mapName+".png"))
+	if mapPath == "" {
+		return fmt.Errorf("map image not found for map %s", mapName)
+	}
+	file, err := os.Open(mapPath)
+	if err != nil {
+		return fmt.Errorf("failed to open map image: %w", err)
+	}
+	mapImg, _, err := image.Decode(file)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("failed to decode map image: %w", err)
+	}
+
+	// Bounding box of everything on this map
+	var points [][2]int
+	for _, w := range t.path {
+		if joinMapName(t.baseMap, w.tier()) == mapName {
+			points = append(points, [2]int{w.X, w.Y})
+		}
+	}
+	for _, p := range t.points {
+		if p.mapName == mapName {
+			points = append(points, [2]int{p.x, p.y})
+		}
+	}
+	for _, e := range t.events {
+		if e.mapName == mapName {
+			points = append(points, [2]int{e.x, e.y})
+		}
+	}
+	if len(points) == 0 {
+		return fmt.Errorf("nothing to render")
+	}
+	bbox := image.Rect(points[0][0], points[0][1], points[0][0]+1, points[0][1]+1)
+	for _, p := range points[1:] {
+		bbox = bbox.Union(image.Rect(p[0], p[1], p[0]+1, p[1]+1))
+	}
+	bbox = bbox.Inset(-TRAJECTORY_RENDER_MARGIN).Intersect(mapImg.Bounds())
+	if bbox.Empty() {
+		return fmt.Errorf("trajectory is outside of the map image")
+	}
+
+	crop := image.NewRGBA(image.Rect(0, 0, bbox.Dx(), bbox.Dy()))
+	draw.Draw(crop, crop.Rect, mapImg, bbox.Min, draw.Src)
+	img := minicv.ImageScale(crop, TRAJECTORY_RENDER_SCALE)
+
+	// Map coordinates to rendering coordinates
+	sx := float64(img.Rect.Dx()) / float64(bbox.Dx())
+	sy := float64(img.Rect.Dy()) / float64(bbox.Dy())
+	toImg := func(x, y int) (int, int) {
+		return int(math.Round((float64(x-bbox.Min.X) + 0.5) * sx)), int(math.Round((float64(y-bbox.Min.Y) + 0.5) * sy))
+	}
+
+	// Planned path
+	var prev *[2]int
+	for _, w := range t.path {
+		if joinMapName(t.baseMap, w.tier()) != mapName {
+			prev = nil
+			continue
+		}
+		x, y := toImg(w.X, w.Y)
+		if prev != nil {
+			minicv.DrawLine(img, prev[0], prev[1], x, y, trajectoryPathColor, 2)
+		}
+		prev = &[2]int{x, y}
+	}
+	for _, w := range t.path {
+		if joinMapName(t.baseMap, w.tier()) == mapName {
+			x, y := toImg(w.X, w.Y)
+			minicv.FillCircle(img, x, y, 3, trajectoryPathColor)
+			minicv.FillCircle(img, x, y, 1, trajectoryTargetColor)
+		}
+	}
+
+	// Walked trajectory
+	prev = nil
+	for i, p := range t.points {
+		if p.mapName != mapName {
+			prev = nil
+			continue
+		}
+		x, y := toImg(p.x, p.y)
+		if prev != nil {
+			minicv.DrawLine(img, prev[0], prev[1], x, y, trajectoryWalkColor, 2)
+		}
+		if i == 0 {
+			minicv.FillCircle(img, x, y, 4, trajectoryStartColor)
+		}
+		prev = &[2]int{x, y}
+	}
+
+	// Events
+	for _, e := range t.events {
+		if e.mapName != mapName {
+			continue
+		}
+		x, y := toImg(e.x, e.y)
+		switch e.kind {
+		case TRAJECTORY_EVENT_TARGET_REACHED:
+			minicv.DrawCircle(img, x, y, 4, trajectoryReachColor, 1)
+		case TRAJECTORY_EVENT_STUCK:
+			minicv.FillCircle(img, x, y, 4, trajectoryStuckColor)
+		case TRAJECTORY_EVENT_DISCONTINUITY:
+			minicv.DrawCircle(img, x, y, 6, trajectoryStuckColor, 1)
+		default:
+			minicv.DrawCircle(img, x, y, 8, trajectoryStopColor, 2)
+		}
+	}
+
+	out, err := os.Create(outPath)
+	if err != nil {
+		return fmt.Errorf("failed to create rendering: %w", err)
+	}
+	defer out.Close()
+	if err := png.Encode(out, img); err != nil {
+		return fmt.Errorf("failed to encode rendering: %w", err)
+	}
+	return nil
+}
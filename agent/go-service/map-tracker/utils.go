@@ -2,8 +2,11 @@
 package maptracker
 
 import (
+	"cmp"
 	"image"
 	"math"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/MaaXYZ/MaaEnd/agent/go-service/pkg/minicv"
@@ -12,16 +15,39 @@ import (
 
 /* ******** Recognitions ******** */
 
+// Template matching methods for searching a whole haystack
+const (
+	MATCH_METHOD_AUTO    = "auto"    // Pick the method estimated to be faster
+	MATCH_METHOD_SPATIAL = "spatial" // Spatial NCC on a coarse grid, fine-tuned around the best results
+	MATCH_METHOD_FFT     = "fft"     // NCC at every position computed with FFT
+)
+
+// resolveMatchMethod resolves MATCH_METHOD_AUTO to the method estimated to be faster for the sizes and the mask
+func resolveMatchMethod(method string, hW, hH, nW, nH, step int, nMask *minicv.Mask) string {
+	if method != MATCH_METHOD_AUTO {
+		return method
+	}
+	spatial, fft := minicv.EstimateMatchCost(hW, hH, nW, nH, step, nMask)
+	if fft < spatial {
+		return MATCH_METHOD_FFT
+	}
+	return MATCH_METHOD_SPATIAL
+}
+
 func MatchTemplateOptimized(
 	hRGBA *image.RGBA,
 	hInt minicv.IntegralArray,
 	nRGBA *image.RGBA,
+	nMask *minicv.Mask,
 	nStats minicv.StatsResult,
 ) (int, int, float64) {
 	hW, hH, nW, nH := hRGBA.Rect.Dx(), hRGBA.Rect.Dy(), nRGBA.Rect.Dx(), nRGBA.Rect.Dy()
 	if nW > hW || nH > hH {
 		return 0, 0, 0.0
 	}
+	if resolveMatchMethod(MATCH_METHOD_AUTO, hW, hH, nW, nH, 3, nMask) == MATCH_METHOD_FFT {
+		return minicv.MatchTemplateMaskedFFT(hRGBA, hInt, nRGBA, nMask, nStats).Max()
+	}
 
 	// Calculate search bounds for the top-left corner (x, y)
 	minX, minY := 0, 0
@@ -41,7 +67,7 @@ func MatchTemplateOptimized(
 			lx, ly, lm := 0, 0, -1.0
 			for y := minY + id*step; y < minY+rows; y += numWorkers * step {
 				for x := minX; x <= maxX; x += step {
-					s := computeNCCFast(hRGBA, hInt, nRGBA, x, y, nStats)
+					s := minicv.ComputeMaskedNCC(hRGBA, hInt, nRGBA, nMask, x, y, nStats)
 					if s > lm {
 						lm, lx, ly = s, x, y
 					}
@@ -63,7 +89,7 @@ func MatchTemplateOptimized(
 	// Fine-tuning pass around the best result
 	for y := max(minY, bc.y-step+1); y < min(maxY+1, bc.y+step); y++ {
 		for x := max(minX, bc.x-step+1); x < min(maxX+1, bc.x+step); x++ {
-			s := computeNCCFast(hRGBA, hInt, nRGBA, x, y, nStats)
+			s := minicv.ComputeMaskedNCC(hRGBA, hInt, nRGBA, nMask, x, y, nStats)
 			if s > fm {
 				fm, fx, fy = s, x, y
 			}
@@ -76,6 +102,7 @@ func MatchTemplateAround(
 	hRGBA *image.RGBA,
 	hInt minicv.IntegralArray,
 	nRGBA *image.RGBA,
+	nMask *minicv.Mask,
 	nStats minicv.StatsResult,
 	cx, cy, radius int,
 ) (int, int, float64) {
@@ -114,7 +141,7 @@ func MatchTemplateAround(
 					break
 				}
 				for x := minX; x <= maxX; x += step {
-					s := computeNCCFast(hRGBA, hInt, nRGBA, x, y, nStats)
+					s := minicv.ComputeMaskedNCC(hRGBA, hInt, nRGBA, nMask, x, y, nStats)
 					if s > lm {
 						lm, lx, ly = s, x, y
 					}
@@ -136,7 +163,7 @@ func MatchTemplateAround(
 	// Fine-tuning pass around the best result
 	for y := max(minY, bc.y-step+1); y < min(maxY+1, bc.y+step); y++ {
 		for x := max(minX, bc.x-step+1); x < min(maxX+1, bc.x+step); x++ {
-			s := computeNCCFast(hRGBA, hInt, nRGBA, x, y, nStats)
+			s := minicv.ComputeMaskedNCC(hRGBA, hInt, nRGBA, nMask, x, y, nStats)
 			if s > fm {
 				fm, fx, fy = s, x, y
 			}
@@ -145,32 +172,168 @@ func MatchTemplateAround(
 	return fx, fy, fm
 }
 
-func computeNCCFast(hRGBA *image.RGBA, hInt minicv.IntegralArray, nRGBA *image.RGBA, ox, oy int, nStats minicv.StatsResult) float64 {
-	nW, nH := nRGBA.Rect.Dx(), nRGBA.Rect.Dy()
-	hp, np, hs, ns := hRGBA.Pix, nRGBA.Pix, hRGBA.Stride, nRGBA.Stride
-	var dot uint64
-	rb := oy*hs + ox*4
-	for y := 0; y < nH; y++ {
-		hi, ni := rb, y*ns
-		for x := 0; x < nW; x++ {
-			dot += uint64(hp[hi]) * uint64(np[ni])
-			dot += uint64(hp[hi+1]) * uint64(np[ni+1])
-			dot += uint64(hp[hi+2]) * uint64(np[ni+2])
-			hi += 4
-			ni += 4
+// MatchPeak is a local maximum of the template matching scores
+type MatchPeak struct {
+	X, Y  int
+	Score float64
+}
+
+// MatchTemplatePyramidTopK finds up to k distinct peaks like MatchTemplateTopK, coarse to fine:
+// the coarsest level is searched as a whole, and its best peaks are refined through the finer levels.
+// hays, needles and masks hold the haystack, the needle and its mask at each level from coarse to fine,
+// and masks may be nil. Returned peaks are at the finest level, and suppressRadius is in pixels of the finest level.
+func MatchTemplatePyramidTopK(hays []*minicv.PyramidLevel, needles []*image.RGBA, masks []*minicv.Mask, k, suppressRadius int) []MatchPeak {
+	coarse, fine := hays[0], hays[len(hays)-1]
+	coarseSuppress := max(int(float64(suppressRadius)*coarse.Scale/fine.Scale), 1)
+	var coarseMask *minicv.Mask
+	if masks != nil {
+		coarseMask = masks[0]
+	}
+	coarseStats := minicv.GetMaskedImageStats(needles[0], coarseMask)
+	peaks := MatchTemplateTopK(coarse.Img, coarse.Integral, needles[0], coarseMask, coarseStats, k*PYRAMID_COARSE_FACTOR, coarseSuppress)
+
+	candidates := make([]minicv.MatchCandidate, 0, len(peaks))
+	for _, p := range peaks {
+		candidates = append(candidates, minicv.MatchCandidate{X: p.X, Y: p.Y, Score: p.Score})
+	}
+	candidates = minicv.MatchTemplatePyramid(hays, needles, masks, candidates, PYRAMID_REFINE_RADIUS)
+	slices.SortFunc(candidates, func(a, b minicv.MatchCandidate) int { return cmp.Compare(b.Score, a.Score) })
+
+	// Candidates from different coarse peaks may converge to the same place
+	result := make([]MatchPeak, 0, k)
+	for _, c := range candidates {
+		if len(result) >= k {
+			break
+		}
+		suppressed := slices.ContainsFunc(result, func(q MatchPeak) bool {
+			return abs(q.X-c.X) <= suppressRadius && abs(q.Y-c.Y) <= suppressRadius
+		})
+		if !suppressed {
+			result = append(result, MatchPeak{c.X, c.Y, c.Score})
+		}
+	}
+	return result
+}
+
+// MatchTemplateTopK finds up to k distinct peaks of the matching scores, in descending order of score.
+// Peaks closer than suppressRadius to a better peak are suppressed. Only the pixels in the mask are matched,
+// and the mask may be nil.
+func MatchTemplateTopK(
+	hRGBA *image.RGBA,
+	hInt minicv.IntegralArray,
+	nRGBA *image.RGBA,
+	nMask *minicv.Mask,
+	nStats minicv.StatsResult,
+	k, suppressRadius int,
+) []MatchPeak {
+	return matchTemplateTopK(hRGBA, hInt, nRGBA, nMask, nStats, k, suppressRadius, MATCH_METHOD_AUTO)
+}
+
+// matchTemplateTopK implements MatchTemplateTopK with the given matching method
+func matchTemplateTopK(
+	hRGBA *image.RGBA,
+	hInt minicv.IntegralArray,
+	nRGBA *image.RGBA,
+	nMask *minicv.Mask,
+	nStats minicv.StatsResult,
+	k, suppressRadius int,
+	method string,
+) []MatchPeak {
+	hW, hH, nW, nH := hRGBA.Rect.Dx(), hRGBA.Rect.Dy(), nRGBA.Rect.Dx(), nRGBA.Rect.Dy()
+	if nW > hW || nH > hH || k <= 0 {
+		return nil
+	}
+
+	maxX, maxY := hW-nW, hH-nH
+	numWorkers, step := 4, 3
+	gridW, gridH := maxX/step+1, maxY/step+1
+
+	// Coarse pass, keeping the best position of every grid cell
+	grid := make([]MatchPeak, gridW*gridH)
+	exact := resolveMatchMethod(method, hW, hH, nW, nH, step, nMask) == MATCH_METHOD_FFT
+	if exact {
+		// Every position is scored, so each cell keeps the best of its step x step block
+		scores := minicv.MatchTemplateMaskedFFT(hRGBA, hInt, nRGBA, nMask, nStats)
+		for gy := 0; gy < gridH; gy++ {
+			for gx := 0; gx < gridW; gx++ {
+				best := MatchPeak{gx * step, gy * step, math.Inf(-1)}
+				for y := gy * step; y < min((gy+1)*step, maxY+1); y++ {
+					for x := gx * step; x < min((gx+1)*step, maxX+1); x++ {
+						if s := scores.At(x, y); s > best.Score {
+							best = MatchPeak{x, y, s}
+						}
+					}
+				}
+				grid[gy*gridW+gx] = best
+			}
+		}
+	} else {
+		var wg sync.WaitGroup
+		for i := 0; i < numWorkers; i++ {
+			wg.Add(1)
+			go func(id int) {
+				defer wg.Done()
+				for gy := id; gy < gridH; gy += numWorkers {
+					for gx := 0; gx < gridW; gx++ {
+						x, y := gx*step, gy*step
+						grid[gy*gridW+gx] = MatchPeak{x, y, minicv.ComputeMaskedNCC(hRGBA, hInt, nRGBA, nMask, x, y, nStats)}
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+	}
+
+	// Collect local maxima of the grid
+	peaks := make([]MatchPeak, 0)
+	for gy := 0; gy < gridH; gy++ {
+		for gx := 0; gx < gridW; gx++ {
+			s := grid[gy*gridW+gx].Score
+			isMax := true
+			for dy := -1; dy <= 1 && isMax; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					nx, ny := gx+dx, gy+dy
+					if (dx != 0 || dy != 0) && nx >= 0 && nx < gridW && ny >= 0 && ny < gridH && grid[ny*gridW+nx].Score > s {
+						isMax = false
+						break
+					}
+				}
+			}
+			if isMax {
+				peaks = append(peaks, grid[gy*gridW+gx])
+			}
 		}
-		rb += hs
 	}
-	shn := float64(dot)
-	sh, ssh := hInt.GetAreaIntegral(ox, oy, nW, nH)
-	cnt := float64(nW * nH * 3)
-	mh := sh / cnt
-	vh := ssh - cnt*mh*mh
-	if vh < 1e-3 {
-		return 0.0
+	slices.SortFunc(peaks, func(a, b MatchPeak) int { return cmp.Compare(b.Score, a.Score) })
+
+	// Non-maximum suppression and fine-tuning pass around each kept peak
+	result := make([]MatchPeak, 0, k)
+	for _, p := range peaks {
+		if len(result) >= k {
+			break
+		}
+		suppressed := slices.ContainsFunc(result, func(q MatchPeak) bool {
+			return abs(q.X-p.X) <= suppressRadius && abs(q.Y-p.Y) <= suppressRadius
+		})
+		if suppressed {
+			continue
+		}
+		best := p
+		if exact {
+			result = append(result, best)
+			continue
+		}
+		for y := max(0, p.Y-step+1); y < min(maxY+1, p.Y+step); y++ {
+			for x := max(0, p.X-step+1); x < min(maxX+1, p.X+step); x++ {
+				if s := minicv.ComputeMaskedNCC(hRGBA, hInt, nRGBA, nMask, x, y, nStats); s > best.Score {
+					best = MatchPeak{x, y, s}
+				}
+			}
+		}
+		result = append(result, best)
 	}
-	dh := math.Sqrt(vh)
-	return (shn - cnt*mh*nStats.Mean) / (dh * nStats.Std)
+	slices.SortFunc(result, func(a, b MatchPeak) int { return cmp.Compare(b.Score, a.Score) })
+	return result
 }
 
 /* ******** Actions ******** */
@@ -203,6 +366,34 @@ func (aw *ActionWrapper) SwipeSync(x, y, dx, dy int, delayMillis int) {
 	time.Sleep(time.Duration(delayMillis) * time.Millisecond)
 }
 
+// TouchDownSync presses a touch contact at (x, y)
+func (aw *ActionWrapper) TouchDownSync(contact, x, y int, delayMillis int) {
+	aw.ctrl.PostTouchDown(int32(contact), int32(x), int32(y), 1).Wait()
+	time.Sleep(time.Duration(delayMillis) * time.Millisecond)
+}
+
+// TouchMoveSync moves a pressed touch contact to (x, y)
+func (aw *ActionWrapper) TouchMoveSync(contact, x, y int, delayMillis int) {
+	aw.ctrl.PostTouchMove(int32(contact), int32(x), int32(y), 1).Wait()
+	time.Sleep(time.Duration(delayMillis) * time.Millisecond)
+}
+
+// TouchUpSync releases a touch contact
+func (aw *ActionWrapper) TouchUpSync(contact int, delayMillis int) {
+	aw.ctrl.PostTouchUp(int32(contact)).Wait()
+	time.Sleep(time.Duration(delayMillis) * time.Millisecond)
+}
+
+// TouchSwipeSync performs a touch swipe from (x1, y1) to (x2, y2) with the given contact
+func (aw *ActionWrapper) TouchSwipeSync(contact, x1, y1, x2, y2 int, durationMillis int) {
+	aw.ctrl.PostSwipeV2(int32(x1), int32(y1), int32(x2), int32(y2), time.Duration(durationMillis)*time.Millisecond, int32(contact), 1).Wait()
+}
+
+// Sleep waits for the given delay
+func (aw *ActionWrapper) Sleep(delayMillis int) {
+	time.Sleep(time.Duration(delayMillis) * time.Millisecond)
+}
+
 // KeyDownSync sends a key press
 func (aw *ActionWrapper) KeyDownSync(keyCode int, delayMillis int) {
 	aw.ctrl.PostKeyDown(int32(keyCode)).Wait()
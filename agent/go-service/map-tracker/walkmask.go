@@ -0,0 +1,107 @@
+// Copyright (c) 2026 Harry Huang
+package maptracker
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// WalkMaskGenOptions configures GenerateWalkMasks
+type WalkMaskGenOptions struct {
+	MapNameRegex string // Maps to generate masks for
+	OutDir       string // Output directory, defaults to WALKABLE_MASK_DIR in the resource directory
+	MinLuminance uint8  // Min luminance of a map pixel to be considered walkable
+	Overwrite    bool   // Whether to overwrite existing masks
+}
+
+// GenerateWalkMasks writes draft walkable masks of the maps, marking the pixels of the map images
+// brighter than the dark background as walkable. The drafts do not know about walls, water or cliffs,
+// so they are meant to be reviewed and painted over by hand before shipping.
+// Returns the paths of the written masks.
+func GenerateWalkMasks(opts WalkMaskGenOptions) ([]string, error) {
+	mapNameRegex, err := regexp.Compile(opts.MapNameRegex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid map name regex: %w", err)
+	}
+	mapDir := findResource(MAP_DIR)
+	if mapDir == "" {
+		return nil, fmt.Errorf("map directory not found (searched in cache and standard locations)")
+	}
+	outDir := opts.OutDir
+	if outDir == "" {
+		rel, err := filepath.Rel(MAP_DIR, WALKABLE_MASK_DIR)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve mask directory: %w", err)
+		}
+		outDir = filepath.Join(mapDir, rel)
+	}
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create mask directory: %w", err)
+	}
+
+	entries, err := os.ReadDir(mapDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read map directory: %w", err)
+	}
+	written := make([]string, 0)
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".png")
+		if entry.IsDir() || !ok || !mapNameRegex.MatchString(name) {
+			continue
+		}
+		outPath := filepath.Join(outDir, entry.Name())
+		if _, err := os.Stat(outPath); err == nil && !opts.Overwrite {
+			log.Info().Str("path", outPath).Msg("Walkable mask exists, skipped")
+			continue
+		}
+		if err := generateWalkMask(filepath.Join(mapDir, entry.Name()), outPath, opts.MinLuminance); err != nil {
+			return written, fmt.Errorf("failed to generate walkable mask of map %s: %w", name, err)
+		}
+		written = append(written, outPath)
+		log.Info().Str("map", name).Str("path", outPath).Msg("Draft walkable mask written")
+	}
+	return written, nil
+}
+
+// generateWalkMask writes the draft walkable mask of one map image
+func generateWalkMask(mapPath, outPath string, minLum uint8) error {
+	file, err := os.Open(mapPath)
+	if err != nil {
+		return fmt.Errorf("failed to open map image: %w", err)
+	}
+	img, _, err := image.Decode(file)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("failed to decode map image: %w", err)
+	}
+
+	b := img.Bounds()
+	mask := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := range b.Dy() {
+		for x := range b.Dx() {
+			r, g, bb, a := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			lum := (299*r + 587*g + 114*bb) / 1000 >> 8
+			if a >= 0x8000 && lum >= uint32(minLum) {
+				mask.SetGray(x, y, color.Gray{255})
+			}
+		}
+	}
+
+	out, err := os.Create(outPath)
+	if err != nil {
+		return fmt.Errorf("failed to create mask: %w", err)
+	}
+	defer out.Close()
+	if err := png.Encode(out, mask); err != nil {
+		return fmt.Errorf("failed to encode mask: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2026 Harry Huang
+package maptracker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Waypoint is a target point of a path.
+// In JSON, it is either [x, y] or an object like {"x": 688, "y": 350, "tier": "114", "actions": [...]}.
+type Waypoint struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	// Tier is the tier the point is on, "" for the base map.
+	// If omitted, the point is on the map given by the map name of the path.
+	Tier *string `json:"tier,omitempty"`
+	// Actions are performed in order after the point is reached.
+	Actions []WaypointAction `json:"actions,omitempty"`
+}
+
+// waypointObject is used to (un)marshal the object form of Waypoint without recursion
+type waypointObject Waypoint
+
+// newWaypoint creates a waypoint at the given point with an explicit tier
+func newWaypoint(p [2]int, tier string) Waypoint {
+	return Waypoint{X: p[0], Y: p[1], Tier: &tier}
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (w *Waypoint) UnmarshalJSON(data []byte) error {
+	if data = bytes.TrimSpace(data); len(data) > 0 && data[0] == '[' {
+		var p [2]int
+		if err := json.Unmarshal(data, &p); err != nil {
+			return fmt.Errorf("waypoint must be [x, y]: %w", err)
+		}
+		*w = Waypoint{X: p[0], Y: p[1]}
+		return nil
+	}
+	var obj waypointObject
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return fmt.Errorf("waypoint must be [x, y] or an object: %w", err)
+	}
+	*w = Waypoint(obj)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler, using the compact [x, y] form when possible
+func (w Waypoint) MarshalJSON() ([]byte, error) {
+	if w.Tier == nil && len(w.Actions) == 0 {
+		return json.Marshal([2]int{w.X, w.Y})
+	}
+	return json.Marshal(waypointObject(w))
+}
+
+// tier returns the tier of the waypoint, "" for the base map or if not specified
+func (w Waypoint) tier() string {
+	if w.Tier == nil {
+		return ""
+	}
+	return *w.Tier
+}
+
+// samePoint reports whether two waypoints refer to the same point on the same tier
+func (w Waypoint) samePoint(o Waypoint) bool {
+	return w.X == o.X && w.Y == o.Y && w.tier() == o.tier()
+}
+
+// resolveWaypoints makes the tier of each waypoint explicit, using the tier of mapName as default.
+// Returns the base map name and the resolved waypoints.
+func resolveWaypoints(mapName string, path []Waypoint) (string, []Waypoint) {
+	baseMap, defaultTier := splitMapName(mapName)
+	result := make([]Waypoint, len(path))
+	for i, w := range path {
+		tier := defaultTier
+		if w.Tier != nil {
+			tier = *w.Tier
+		}
+		result[i] = newWaypoint([2]int{w.X, w.Y}, tier)
+		result[i].Actions = w.Actions
+	}
+	return baseMap, result
+}
+
+// waypointsFromPoints converts plain points on the given tier to waypoints
+func waypointsFromPoints(points [][2]int, tier string) []Waypoint {
+	result := make([]Waypoint, len(points))
+	for i, p := range points {
+		result[i] = newWaypoint(p, tier)
+	}
+	return result
+}
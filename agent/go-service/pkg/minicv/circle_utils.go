@@ -0,0 +1,117 @@
+// Copyright (c) 2026 Harry Huang
+package minicv
+
+import (
+	"image"
+	"math"
+)
+
+// CircleResult describes a circle detected in an image
+type CircleResult struct {
+	X, Y   int     // Center, in the coordinates of the image
+	Radius int     // Radius in px
+	Score  float64 // Fraction of the circumference supported by edge pixels, in [0, 1]
+}
+
+// DetectCircle finds the most prominent circle with a radius in [minRadius, maxRadius] using a gradient Hough transform.
+// Pixels with a Sobel gradient magnitude below edgeThreshold are ignored.
+// The center is voted along the gradient direction of each edge pixel, then the radius is chosen
+// from the distances of edge pixels whose gradient points to (or away from) that center.
+func DetectCircle(img *image.RGBA, minRadius, maxRadius int, edgeThreshold float64) (CircleResult, bool) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	if w < 3 || h < 3 || minRadius < 1 || maxRadius < minRadius {
+		return CircleResult{}, false
+	}
+
+	// Grayscale
+	gray := make([]float64, w*h)
+	for y := range h {
+		off := img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y)
+		for x := range w {
+			gray[y*w+x] = 0.299*float64(img.Pix[off]) + 0.587*float64(img.Pix[off+1]) + 0.114*float64(img.Pix[off+2])
+			off += 4
+		}
+	}
+
+	// Sobel gradients of edge pixels
+	type edge struct {
+		x, y   int
+		ux, uy float64 // Unit gradient direction
+	}
+	edges := make([]edge, 0)
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			p := func(dx, dy int) float64 { return gray[(y+dy)*w+x+dx] }
+			gx := p(1, -1) + 2*p(1, 0) + p(1, 1) - p(-1, -1) - 2*p(-1, 0) - p(-1, 1)
+			gy := p(-1, 1) + 2*p(0, 1) + p(1, 1) - p(-1, -1) - 2*p(0, -1) - p(1, -1)
+			mag := math.Hypot(gx, gy)
+			if mag >= edgeThreshold {
+				edges = append(edges, edge{x, y, gx / mag, gy / mag})
+			}
+		}
+	}
+	if len(edges) == 0 {
+		return CircleResult{}, false
+	}
+
+	// Stage 1: vote for centers along both gradient directions
+	acc := make([]int32, w*h)
+	for _, e := range edges {
+		for r := minRadius; r <= maxRadius; r++ {
+			for _, sign := range [2]float64{1, -1} {
+				cx := e.x + int(math.Round(sign*float64(r)*e.ux))
+				cy := e.y + int(math.Round(sign*float64(r)*e.uy))
+				if cx >= 0 && cx < w && cy >= 0 && cy < h {
+					acc[cy*w+cx]++
+				}
+			}
+		}
+	}
+	bestX, bestY, bestVotes := 0, 0, int32(-1)
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			var votes int32
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					votes += acc[(y+dy)*w+x+dx]
+				}
+			}
+			if votes > bestVotes {
+				bestX, bestY, bestVotes = x, y, votes
+			}
+		}
+	}
+
+	// Stage 2: histogram of distances of edge pixels whose gradient is radial to the center
+	hist := make([]int, maxRadius+2)
+	for _, e := range edges {
+		dx, dy := float64(e.x-bestX), float64(e.y-bestY)
+		d := math.Hypot(dx, dy)
+		if d < float64(minRadius)-0.5 || d > float64(maxRadius)+0.5 {
+			continue
+		}
+		if math.Abs(dx*e.ux+dy*e.uy)/d < 0.9 {
+			continue
+		}
+		hist[int(math.Round(d))]++
+	}
+	bestR, bestScore := 0, 0.0
+	for r := minRadius; r <= maxRadius; r++ {
+		// Rings are usually a few px thick, so neighbouring radii count as well
+		support := float64(hist[r-1] + hist[r] + hist[r+1])
+		score := math.Min(support/(2*math.Pi*float64(r)), 1.0)
+		if score > bestScore {
+			bestR, bestScore = r, score
+		}
+	}
+	if bestR == 0 {
+		return CircleResult{}, false
+	}
+
+	return CircleResult{
+		X:      img.Rect.Min.X + bestX,
+		Y:      img.Rect.Min.Y + bestY,
+		Radius: bestR,
+		Score:  bestScore,
+	}, true
+}
@@ -0,0 +1,250 @@
+// Copyright (c) 2026 Harry Huang
+package minicv
+
+import (
+	"image"
+	"math"
+	"slices"
+)
+
+/* ******** Color Spaces ******** */
+
+// RGBToHSV converts RGB [0, 255] to HSV: Hue [0, 360), Saturation [0, 1], Value [0, 1]
+func RGBToHSV(r, g, b uint8) (float64, float64, float64) {
+	fr, fg, fb := float64(r)/255, float64(g)/255, float64(b)/255
+	maxC := max(fr, fg, fb)
+	minC := min(fr, fg, fb)
+	delta := maxC - minC
+
+	// Value
+	v := maxC
+
+	// Saturation
+	s := 0.0
+	if maxC != 0 {
+		s = delta / maxC
+	}
+
+	// Hue
+	h := 0.0
+	if delta != 0 {
+		switch maxC {
+		case fr:
+			h = (fg - fb) / delta
+			if fg < fb {
+				h += 6
+			}
+		case fg:
+			h = (fb-fr)/delta + 2
+		default:
+			h = (fr-fg)/delta + 4
+		}
+		h *= 60
+	}
+
+	return h, s, v
+}
+
+/* ******** Color Ranges ******** */
+
+// HSVRange is an inclusive range of HSV colors, in the scale of RGBToHSV.
+// The hue range wraps around 360 if the lower hue is greater than the upper hue.
+type HSVRange struct {
+	Lower [3]float64
+	Upper [3]float64
+}
+
+// HSVRangeFromCV converts an inclusive range in the 8-bit HSV scale of OpenCV (Hue [0, 180), Saturation and Value [0, 255]),
+// as used by the ColorMatch recognition of MAA, to an HSVRange. Bounds are widened by half a step,
+// so that colors rounded into the range by OpenCV are in it.
+func HSVRangeFromCV(lower, upper [3]int) HSVRange {
+	return HSVRange{
+		Lower: [3]float64{max(0, float64(lower[0])-0.5) * 2, max(0, float64(lower[1])-0.5) / 255, max(0, float64(lower[2])-0.5) / 255},
+		Upper: [3]float64{min(180, float64(upper[0])+0.5) * 2, min(255, float64(upper[1])+0.5) / 255, min(255, float64(upper[2])+0.5) / 255},
+	}
+}
+
+// Contains reports whether an HSV color is in the range
+func (r HSVRange) Contains(h, s, v float64) bool {
+	if s < r.Lower[1] || s > r.Upper[1] || v < r.Lower[2] || v > r.Upper[2] {
+		return false
+	}
+	if r.Lower[0] <= r.Upper[0] {
+		return h >= r.Lower[0] && h <= r.Upper[0]
+	}
+	return h >= r.Lower[0] || h <= r.Upper[0]
+}
+
+// BinaryImage is an image of boolean pixels, such as the pixels of an image in a color range
+type BinaryImage struct {
+	Pix  []bool
+	Rect image.Rectangle
+}
+
+// At reports whether pixel (x, y) is set, in the coordinates of Rect
+func (b *BinaryImage) At(x, y int) bool {
+	if !(image.Point{x, y}).In(b.Rect) {
+		return false
+	}
+	return b.Pix[(y-b.Rect.Min.Y)*b.Rect.Dx()+x-b.Rect.Min.X]
+}
+
+// Count returns the number of pixels set
+func (b *BinaryImage) Count() int {
+	n := 0
+	for _, p := range b.Pix {
+		if p {
+			n++
+		}
+	}
+	return n
+}
+
+// inRange returns the binary image of the pixels in rect of an image for which in returns true
+func inRange(img *image.RGBA, rect image.Rectangle, in func(r, g, b uint8) bool) *BinaryImage {
+	rect = rect.Intersect(img.Rect)
+	w := rect.Dx()
+	result := &BinaryImage{Pix: make([]bool, w*rect.Dy()), Rect: rect}
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		off := img.PixOffset(rect.Min.X, y)
+		row := result.Pix[(y-rect.Min.Y)*w : (y-rect.Min.Y+1)*w]
+		for x := range row {
+			row[x] = in(img.Pix[off], img.Pix[off+1], img.Pix[off+2])
+			off += 4
+		}
+	}
+	return result
+}
+
+// InRangeHSV returns the binary image of the pixels in rect of an image whose HSV is in the range
+func InRangeHSV(img *image.RGBA, rect image.Rectangle, rng HSVRange) *BinaryImage {
+	return inRange(img, rect, func(r, g, b uint8) bool {
+		return rng.Contains(RGBToHSV(r, g, b))
+	})
+}
+
+/* ******** Connected Components ******** */
+
+// Component is an 8-connected region of set pixels in a binary image
+type Component struct {
+	Rect   image.Rectangle // Bounding box
+	Area   int             // Number of pixels
+	CX, CY float64         // Centroid
+}
+
+// ConnectedComponents labels the 8-connected regions of set pixels in a binary image.
+// Returns the regions in descending order of area.
+func ConnectedComponents(b *BinaryImage) []Component {
+	w, h := b.Rect.Dx(), b.Rect.Dy()
+	visited := make([]bool, len(b.Pix))
+	result := make([]Component, 0)
+	stack := make([]int, 0)
+
+	for start, set := range b.Pix {
+		if !set || visited[start] {
+			continue
+		}
+		visited[start] = true
+		stack = append(stack[:0], start)
+		minX, minY, maxX, maxY := w, h, -1, -1
+		area, sumX, sumY := 0, 0, 0
+		for len(stack) > 0 {
+			i := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			x, y := i%w, i/w
+			minX, minY, maxX, maxY = min(minX, x), min(minY, y), max(maxX, x), max(maxY, y)
+			area++
+			sumX += x
+			sumY += y
+			for ny := max(0, y-1); ny <= min(h-1, y+1); ny++ {
+				for nx := max(0, x-1); nx <= min(w-1, x+1); nx++ {
+					if j := ny*w + nx; b.Pix[j] && !visited[j] {
+						visited[j] = true
+						stack = append(stack, j)
+					}
+				}
+			}
+		}
+		ox, oy := b.Rect.Min.X, b.Rect.Min.Y
+		result = append(result, Component{
+			Rect: image.Rect(ox+minX, oy+minY, ox+maxX+1, oy+maxY+1),
+			Area: area,
+			CX:   float64(ox) + float64(sumX)/float64(area),
+			CY:   float64(oy) + float64(sumY)/float64(area),
+		})
+	}
+	slices.SortStableFunc(result, func(a, b Component) int { return b.Area - a.Area })
+	return result
+}
+
+/* ******** Hue Statistics ******** */
+
+// HueDiff returns the smallest difference between two hues [0, 360)
+func HueDiff(h1, h2 float64) float64 {
+	diff := math.Abs(math.Mod(h1-h2, 360))
+	if diff > 180 {
+		diff = 360 - diff
+	}
+	return diff
+}
+
+// HueStats holds the circular statistics of a set of hues
+type HueStats struct {
+	Mean          float64 // Circular mean [0, 360)
+	Median        float64 // Median taken with the circle cut opposite to the mean [0, 360)
+	Concentration float64 // Length of the mean resultant vector [0, 1], 1 when all hues are equal
+}
+
+// GetHueStats computes the circular statistics of a set of hues [0, 360)
+func GetHueStats(hues []float64) HueStats {
+	if len(hues) == 0 {
+		return HueStats{}
+	}
+	var sumSin, sumCos float64
+	for _, h := range hues {
+		s, c := math.Sincos(h * math.Pi / 180)
+		sumSin += s
+		sumCos += c
+	}
+	n := float64(len(hues))
+	mean := math.Mod(math.Atan2(sumSin, sumCos)*180/math.Pi+360, 360)
+
+	// Unwrap the hues around the mean, so that the median is not split by the wrap at 360
+	cut := mean + 180
+	unwrapped := make([]float64, len(hues))
+	for i, h := range hues {
+		unwrapped[i] = math.Mod(h-cut+720, 360) + cut - 360
+	}
+	slices.Sort(unwrapped)
+	mid := len(unwrapped) / 2
+	median := unwrapped[mid]
+	if len(unwrapped)%2 == 0 {
+		median = (unwrapped[mid-1] + unwrapped[mid]) / 2
+	}
+
+	return HueStats{
+		Mean:          mean,
+		Median:        math.Mod(median+360, 360),
+		Concentration: math.Hypot(sumSin, sumCos) / n,
+	}
+}
+
+// ClusterHues groups hues in the order they are given. Each hue joins the first group whose first hue is within maxDiff,
+// or starts a new one if there is none. Repeated hues are only counted once.
+func ClusterHues(hues []float64, maxDiff float64) [][]float64 {
+	clusters := make([][]float64, 0)
+	seen := make(map[float64]bool)
+	for _, h := range hues {
+		if seen[h] {
+			continue
+		}
+		seen[h] = true
+		i := slices.IndexFunc(clusters, func(c []float64) bool { return HueDiff(h, c[0]) <= maxDiff })
+		if i < 0 {
+			clusters = append(clusters, []float64{h})
+		} else {
+			clusters[i] = append(clusters[i], h)
+		}
+	}
+	return clusters
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2026 Harry Huang
+package minicv
+
+import (
+	"image"
+	"math"
+	"sync"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// DiffResult holds metrics of the difference between two images
+type DiffResult struct {
+	Mean    float64 // Mean absolute difference of the color channels
+	Max     float64 // Max absolute difference of a pixel, averaged over its color channels
+	Changed float64 // Fraction of pixels whose difference exceeds the threshold
+}
+
+// ImageRegionDiff compares a region of two images of the same size.
+// The difference of a pixel is the mean absolute difference of its color channels.
+func ImageRegionDiff(a, b *image.RGBA, r image.Rectangle, threshold float64) DiffResult {
+	r = r.Intersect(image.Rect(0, 0, min(a.Rect.Dx(), b.Rect.Dx()), min(a.Rect.Dy(), b.Rect.Dy())))
+	if r.Empty() {
+		return DiffResult{}
+	}
+	var result DiffResult
+	changed := 0
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		offA, offB := y*a.Stride+r.Min.X*4, y*b.Stride+r.Min.X*4
+		for range r.Dx() {
+			d := 0.0
+			for c := range 3 {
+				d += math.Abs(float64(a.Pix[offA+c]) - float64(b.Pix[offB+c]))
+			}
+			d /= 3
+			result.Mean += d
+			result.Max = max(result.Max, d)
+			if d > threshold {
+				changed++
+			}
+			offA += 4
+			offB += 4
+		}
+	}
+	n := float64(r.Dx() * r.Dy())
+	result.Mean /= n
+	result.Changed = float64(changed) / n
+	return result
+}
+
+// ImageMeanAbsDiff returns the mean absolute difference of the color channels of two images of the same size,
+// or math.MaxFloat64 if their sizes differ
+func ImageMeanAbsDiff(a, b *image.RGBA) float64 {
+	if a.Rect.Dx() != b.Rect.Dx() || a.Rect.Dy() != b.Rect.Dy() {
+		return math.MaxFloat64
+	}
+	return ImageRegionDiff(a, b, image.Rect(0, 0, a.Rect.Dx(), a.Rect.Dy()), 0).Mean
+}
+
+// IsImageFlat reports whether the standard deviation of the color channels of an image is below maxStd,
+// as for blank screens and fade-outs
+func IsImageFlat(img *image.RGBA, maxStd float64) bool {
+	n := img.Rect.Dx() * img.Rect.Dy() * 3
+	if n == 0 {
+		return true
+	}
+	// GetImageStats gives the unnormalized deviation
+	return GetImageStats(img).Std/math.Sqrt(float64(n)) < maxStd
+}
+
+// FrameChangeDetector tells whether regions of captured frames changed since the frame they were last changed in.
+// Regions are compared on small thumbnails, so that capture noise does not count as a change.
+// Comparing against the last changed frame rather than the last seen one keeps slow changes, such as fade-ins, from going unnoticed.
+// It is safe for concurrent use.
+type FrameChangeDetector struct {
+	MaxDiff     float64 // Max difference of a thumbnail pixel for a region to count as unchanged
+	MaxHashDist int     // Max Hamming distance of the pHashes of the thumbnails for a region to count as unchanged, or -1 to skip the check
+	ThumbSize   int     // Max width and height of the thumbnails
+
+	mu     sync.Mutex
+	frames map[string]frameRegion
+}
+
+// frameRegion is the thumbnail of a region in the frame it last changed in
+type frameRegion struct {
+	rect  image.Rectangle
+	thumb *image.RGBA
+	hash  uint64
+}
+
+// NewFrameChangeDetector creates a detector that compares regions on thumbnails of at most 32x32 pixels, without pHashes
+func NewFrameChangeDetector(maxDiff float64) *FrameChangeDetector {
+	return &FrameChangeDetector{MaxDiff: maxDiff, MaxHashDist: -1, ThumbSize: 32, frames: make(map[string]frameRegion)}
+}
+
+// Changed reports whether the region roi of img changed since the last call with the same key that reported a change.
+// Only a changed region is remembered for the next call, so that callers can keep the results computed from it.
+// A region seen for the first time, or last seen at another rect, has changed.
+func (d *FrameChangeDetector) Changed(key string, img image.Image, roi image.Rectangle) bool {
+	roi = roi.Intersect(img.Bounds())
+	if roi.Empty() {
+		d.Forget(key)
+		return true
+	}
+	thumb := image.NewRGBA(image.Rect(0, 0, min(roi.Dx(), d.ThumbSize), min(roi.Dy(), d.ThumbSize)))
+	xdraw.BiLinear.Scale(thumb, thumb.Rect, img, roi, xdraw.Src, nil)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	current := frameRegion{rect: roi, thumb: thumb}
+	if d.MaxHashDist >= 0 {
+		current.hash = PHash(thumb)
+	}
+	last, ok := d.frames[key]
+	changed := !ok || last.rect != roi ||
+		ImageRegionDiff(last.thumb, thumb, thumb.Rect, d.MaxDiff).Changed > 0 ||
+		(d.MaxHashDist >= 0 && HammingDistance(last.hash, current.hash) > d.MaxHashDist)
+	if changed {
+		d.frames[key] = current
+	}
+	return changed
+}
+
+// Forget drops the region of a key, so that it counts as changed the next time
+func (d *FrameChangeDetector) Forget(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.frames, key)
+}
+
+// Reset drops all regions
+func (d *FrameChangeDetector) Reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	clear(d.frames)
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2026 Harry Huang
+package minicv
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// DEBUG_IMAGE_DIR is the directory where SaveDebugImage writes images
+const DEBUG_IMAGE_DIR = "debug"
+
+// ImageCopyRGBA returns an RGBA copy of an image with its origin at (0, 0), to draw annotations on
+func ImageCopyRGBA(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	return dst
+}
+
+// DrawLine draws a line of the given width from (x0, y0) to (x1, y1)
+func DrawLine(img *image.RGBA, x0, y0, x1, y1 int, c color.RGBA, width int) {
+	lo, hi := -(width-1)/2, width/2
+	steps := max(abs(x1-x0), abs(y1-y0), 1)
+	for i := 0; i <= steps; i++ {
+		x := x0 + int(math.Round(float64((x1-x0)*i)/float64(steps)))
+		y := y0 + int(math.Round(float64((y1-y0)*i)/float64(steps)))
+		for dy := lo; dy <= hi; dy++ {
+			for dx := lo; dx <= hi; dx++ {
+				img.SetRGBA(x+dx, y+dy, c)
+			}
+		}
+	}
+}
+
+// DrawPolyline draws lines of the given width through the points, back to the first one if closed
+func DrawPolyline(img *image.RGBA, points []image.Point, closed bool, c color.RGBA, width int) {
+	for i := 1; i < len(points); i++ {
+		DrawLine(img, points[i-1].X, points[i-1].Y, points[i].X, points[i].Y, c, width)
+	}
+	if closed && len(points) > 2 {
+		last := points[len(points)-1]
+		DrawLine(img, last.X, last.Y, points[0].X, points[0].Y, c, width)
+	}
+}
+
+// DrawRect draws the outline of a rectangle, the given width inwards from its border
+func DrawRect(img *image.RGBA, r image.Rectangle, c color.RGBA, width int) {
+	for i := range width {
+		in := r.Inset(i)
+		if in.Empty() {
+			return
+		}
+		for x := in.Min.X; x < in.Max.X; x++ {
+			img.SetRGBA(x, in.Min.Y, c)
+			img.SetRGBA(x, in.Max.Y-1, c)
+		}
+		for y := in.Min.Y; y < in.Max.Y; y++ {
+			img.SetRGBA(in.Min.X, y, c)
+			img.SetRGBA(in.Max.X-1, y, c)
+		}
+	}
+}
+
+// DrawCrosshair draws a cross of the given half length centered at (x, y)
+func DrawCrosshair(img *image.RGBA, x, y, size int, c color.RGBA) {
+	for d := -size; d <= size; d++ {
+		img.SetRGBA(x+d, y, c)
+		img.SetRGBA(x, y+d, c)
+	}
+}
+
+// DrawCircle draws the outline of a circle, the given width inwards from its radius
+func DrawCircle(img *image.RGBA, cx, cy, r int, c color.RGBA, width int) {
+	inner := float64(r-width) + 0.5
+	outer := float64(r) + 0.5
+	for dy := -r; dy <= r; dy++ {
+		for dx := -r; dx <= r; dx++ {
+			if d := math.Hypot(float64(dx), float64(dy)); d > inner && d < outer {
+				img.SetRGBA(cx+dx, cy+dy, c)
+			}
+		}
+	}
+}
+
+// FillCircle draws a filled circle
+func FillCircle(img *image.RGBA, cx, cy, r int, c color.RGBA) {
+	for dy := -r; dy <= r; dy++ {
+		for dx := -r; dx <= r; dx++ {
+			if dx*dx+dy*dy <= r*r {
+				img.SetRGBA(cx+dx, cy+dy, c)
+			}
+		}
+	}
+}
+
+// DrawLabel draws a line of text with its top-left corner at (x, y), over a dark background to keep it legible.
+// The font covers ASCII only, so other characters are drawn as boxes.
+func DrawLabel(img *image.RGBA, x, y int, text string, c color.RGBA) {
+	face := basicfont.Face7x13
+	w := font.MeasureString(face, text).Ceil()
+	bg := image.Rect(x, y, x+w+2, y+face.Height+1)
+	draw.Draw(img, bg, image.NewUniform(color.RGBA{0, 0, 0, 160}), image.Point{}, draw.Over)
+	d := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(c),
+		Face: face,
+		Dot:  fixed.P(x+1, y+face.Ascent),
+	}
+	d.DrawString(text)
+}
+
+// SaveDebugImage saves an image as a PNG named after a node and the current time in a subdirectory of the debug directory.
+// An empty subDir saves directly in the debug directory. Returns the path of the image.
+func SaveDebugImage(img image.Image, subDir, nodeName string) (string, error) {
+	dir := filepath.Join(DEBUG_IMAGE_DIR, subDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create debug directory: %w", err)
+	}
+	name := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`<>:"/\|?* `, r) {
+			return '_'
+		}
+		return r
+	}, nodeName)
+	path := filepath.Join(dir, fmt.Sprintf("%s_%s.png", name, time.Now().Format("20060102_150405.000")))
+	f, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to create debug image: %w", err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		return "", fmt.Errorf("failed to encode debug image: %w", err)
+	}
+	return path, nil
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
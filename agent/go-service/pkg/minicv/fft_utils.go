@@ -0,0 +1,321 @@
+// Copyright (c) 2026 Harry Huang
+package minicv
+
+import (
+	"image"
+	"math"
+	"math/bits"
+	"sync"
+)
+
+// Estimated costs of the template matching methods, in nanoseconds per operation
+const (
+	spatialCostPerMAC   = 0.35 // One multiply-accumulate of a spatial NCC
+	fftCostPerButterfly = 2.5  // One butterfly of an FFT, including the memory traffic of 2D transforms
+)
+
+// ScoreMap holds a matching score for every top-left position of a needle in a haystack
+type ScoreMap struct {
+	Scores []float64
+	W, H   int
+}
+
+// At returns the score of the needle at top-left position (x, y)
+func (s *ScoreMap) At(x, y int) float64 {
+	return s.Scores[y*s.W+x]
+}
+
+// Max returns the position and value of the best score
+func (s *ScoreMap) Max() (int, int, float64) {
+	bi, bv := 0, math.Inf(-1)
+	for i, v := range s.Scores {
+		if v > bv {
+			bi, bv = i, v
+		}
+	}
+	return bi % s.W, bi / s.W, bv
+}
+
+// EstimateMatchCost estimates the time in nanoseconds to match a needle over a whole haystack,
+// with the spatial method at the given step and with MatchTemplateMaskedFFT. The mask may be nil.
+func EstimateMatchCost(hW, hH, nW, nH, step int, nMask *Mask) (spatial, fft float64) {
+	if nW > hW || nH > hH {
+		return 0, 0
+	}
+	positions := float64(((hW-nW)/step + 1) * ((hH-nH)/step + 1))
+	macs := float64(nW * nH * 3)
+	if nMask != nil {
+		// Each run also costs a few lookups of the integral array
+		macs = float64(nMask.Count*3 + len(nMask.runs)*8)
+	}
+	spatial = positions * macs * spatialCostPerMAC
+
+	// Count the row and column transforms actually run by MatchTemplateMaskedFFT
+	pw, ph := fftSize(hW), fftSize(hH)
+	rows := float64(hH + nH + hH + ph)
+	cols := float64(3*pw + hW - nW + 1)
+	if nMask != nil {
+		rows += float64(hH + nH + ph)
+		cols += float64(2*pw + hW - nW + 1)
+	}
+	butterflies := rows*float64(pw)/2*math.Log2(float64(pw)) + cols*float64(ph)/2*math.Log2(float64(ph))
+	fft = butterflies * fftCostPerButterfly
+	return spatial, fft
+}
+
+// MatchTemplateFFT computes the normalized cross-correlation of a needle at every position in a haystack.
+// The correlation is computed with FFT over the RGB channels, and normalized with the integral array of the haystack,
+// giving the same scores as a spatial NCC.
+func MatchTemplateFFT(hRGBA *image.RGBA, hInt IntegralArray, nRGBA *image.RGBA, nStats StatsResult) *ScoreMap {
+	return MatchTemplateMaskedFFT(hRGBA, hInt, nRGBA, nil, nStats)
+}
+
+// MatchTemplateMaskedFFT is like MatchTemplateFFT, but only the pixels in the mask of the needle are matched,
+// giving the same scores as ComputeMaskedNCC. nStats must be computed with the same mask.
+// The sums of the haystack over the mask are correlated with FFT as well, since summing the integral array
+// row by row at every position would be slower.
+func MatchTemplateMaskedFFT(hRGBA *image.RGBA, hInt IntegralArray, nRGBA *image.RGBA, nMask *Mask, nStats StatsResult) *ScoreMap {
+	hW, hH, nW, nH := hRGBA.Rect.Dx(), hRGBA.Rect.Dy(), nRGBA.Rect.Dx(), nRGBA.Rect.Dy()
+	if nW > hW || nH > hH {
+		return nil
+	}
+
+	// Circular correlation does not wrap around for the valid positions as long as the haystack fits
+	pw, ph := fftSize(hW), fftSize(hH)
+
+	// Pack R and G into one complex signal, so that the real part of H * conj(N) is the correlation
+	// summed over both channels. The B channels of both images are packed into another signal,
+	// and separated in the frequency domain by symmetry.
+	hRG := make([]complex128, pw*ph)
+	nRG := make([]complex128, pw*ph)
+	bb := make([]complex128, pw*ph)
+	for y := range hH {
+		off := y * hRGBA.Stride
+		for x := range hW {
+			hRG[y*pw+x] = complex(float64(hRGBA.Pix[off]), float64(hRGBA.Pix[off+1]))
+			bb[y*pw+x] = complex(float64(hRGBA.Pix[off+2]), 0)
+			off += 4
+		}
+	}
+	packNeedle := func(x, y int) {
+		off := y*nRGBA.Stride + x*4
+		nRG[y*pw+x] = complex(float64(nRGBA.Pix[off]), float64(nRGBA.Pix[off+1]))
+		bb[y*pw+x] += complex(0, float64(nRGBA.Pix[off+2]))
+	}
+	if nMask == nil {
+		for y := range nH {
+			for x := range nW {
+				packNeedle(x, y)
+			}
+		}
+	} else {
+		// Pixels out of the mask are left zero, so that they add nothing to the correlation
+		for _, r := range nMask.runs {
+			for x := r.x0; x < r.x1; x++ {
+				packNeedle(x, r.y)
+			}
+		}
+	}
+	fft2D(hRG, pw, ph, hH, pw, false)
+	fft2D(nRG, pw, ph, nH, pw, false)
+	fft2D(bb, pw, ph, hH, pw, false)
+
+	// With Z = FFT(hB + i nB): HB(k) = (Z(k) + conj(Z(-k))) / 2 and NB(k) = (Z(k) - conj(Z(-k))) / 2i
+	for v := range ph {
+		nv := (ph - v) & (ph - 1)
+		for u := range pw {
+			nu := (pw - u) & (pw - 1)
+			z, zc := bb[v*pw+u], conj(bb[nv*pw+nu])
+			hb, nb := (z+zc)/2, (z-zc)/complex(0, 2)
+			hRG[v*pw+u] = hRG[v*pw+u]*conj(nRG[v*pw+u]) + hb*conj(nb)
+		}
+	}
+	fft2D(hRG, pw, ph, ph, hW-nW+1, true)
+
+	// With a mask, correlate the sum and the sum of squares of the haystack pixels, packed into one signal,
+	// with the mask. The buffers of the needle are reused, since the needle is no longer needed.
+	sums, mask := bb, nRG
+	if nMask != nil {
+		clear(sums)
+		clear(mask)
+		for y := range hH {
+			off := y * hRGBA.Stride
+			for x := range hW {
+				r, g, b := float64(hRGBA.Pix[off]), float64(hRGBA.Pix[off+1]), float64(hRGBA.Pix[off+2])
+				sums[y*pw+x] = complex(r+g+b, r*r+g*g+b*b)
+				off += 4
+			}
+		}
+		for _, r := range nMask.runs {
+			for x := r.x0; x < r.x1; x++ {
+				mask[r.y*pw+x] = 1
+			}
+		}
+		fft2D(sums, pw, ph, hH, pw, false)
+		fft2D(mask, pw, ph, nH, pw, false)
+		for k := range sums {
+			sums[k] *= conj(mask[k])
+		}
+		fft2D(sums, pw, ph, ph, hW-nW+1, true)
+	}
+
+	sw, sh := hW-nW+1, hH-nH+1
+	result := &ScoreMap{Scores: make([]float64, sw*sh), W: sw, H: sh}
+	cnt := float64(nW * nH * 3)
+	if nMask != nil {
+		cnt = float64(nMask.Count * 3)
+	}
+	norm := float64(pw * ph)
+	for y := range sh {
+		for x := range sw {
+			dot := real(hRG[y*pw+x]) / norm
+			var s, ss float64
+			if nMask == nil {
+				s, ss = hInt.GetAreaIntegral(x, y, nW, nH)
+			} else {
+				s, ss = real(sums[y*pw+x])/norm, imag(sums[y*pw+x])/norm
+			}
+			mh := s / cnt
+			vh := ss - cnt*mh*mh
+			if vh < 1e-3 {
+				continue
+			}
+			result.Scores[y*sw+x] = (dot - cnt*mh*nStats.Mean) / (math.Sqrt(vh) * nStats.Std)
+		}
+	}
+	return result
+}
+
+// fftSize returns the smallest power of 2 not less than n
+func fftSize(n int) int {
+	if n <= 1 {
+		return 1
+	}
+	return 1 << bits.Len(uint(n-1))
+}
+
+func conj(c complex128) complex128 {
+	return complex(real(c), -imag(c))
+}
+
+// fft2D computes the in-place 2D DFT of a w x h signal stored row by row. Both w and h must be powers of 2.
+// Only the first rows rows are transformed in the row pass, since the rest must be zero,
+// and only the first cols columns are transformed in the column pass, since the rest are not needed.
+// The inverse transform is not normalized.
+func fft2D(data []complex128, w, h, rows, cols int, inverse bool) {
+	numWorkers := 4
+	var wg sync.WaitGroup
+
+	plan := getFFTPlan(w, inverse)
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			for y := id; y < rows; y += numWorkers {
+				plan.transform(data[y*w : (y+1)*w])
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Columns are copied out in tiles of adjacent columns, so that each row is read in one go
+	plan = getFFTPlan(h, inverse)
+	tile := 8
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			buf := make([]complex128, tile*h)
+			for x0 := id * tile; x0 < cols; x0 += numWorkers * tile {
+				n := min(tile, w-x0)
+				for y := range h {
+					row := data[y*w+x0 : y*w+x0+n]
+					for j, v := range row {
+						buf[j*h+y] = v
+					}
+				}
+				for j := range n {
+					plan.transform(buf[j*h : (j+1)*h])
+				}
+				for y := range h {
+					row := data[y*w+x0 : y*w+x0+n]
+					for j := range row {
+						row[j] = buf[j*h+y]
+					}
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// fftPlan holds the precomputed tables of an n-point radix-2 transform
+type fftPlan struct {
+	twiddles []complex128
+	reversed []int // Bit-reversed index of each index
+}
+
+var (
+	fftPlansMu sync.Mutex
+	fftPlans   = make(map[[2]int]*fftPlan)
+)
+
+// getFFTPlan returns the cached plan of an n-point transform, computing it on first use
+func getFFTPlan(n int, inverse bool) *fftPlan {
+	sign := -1
+	if inverse {
+		sign = 1
+	}
+	key := [2]int{n, sign}
+
+	fftPlansMu.Lock()
+	defer fftPlansMu.Unlock()
+	if p, ok := fftPlans[key]; ok {
+		return p
+	}
+
+	p := &fftPlan{twiddles: make([]complex128, n/2), reversed: make([]int, n)}
+	for k := range p.twiddles {
+		s, c := math.Sincos(float64(sign) * 2 * math.Pi * float64(k) / float64(n))
+		p.twiddles[k] = complex(c, s)
+	}
+	shift := bits.UintSize - bits.Len(uint(n-1))
+	for i := range n {
+		if n > 1 {
+			p.reversed[i] = int(bits.Reverse(uint(i)) >> shift)
+		}
+	}
+	fftPlans[key] = p
+	return p
+}
+
+// transform computes the in-place iterative radix-2 DFT of a, whose length must match the plan
+func (p *fftPlan) transform(a []complex128) {
+	n := len(a)
+	if n <= 1 {
+		return
+	}
+	tw := p.twiddles
+
+	// Bit-reversal permutation
+	for i, j := range p.reversed {
+		if i < j {
+			a[i], a[j] = a[j], a[i]
+		}
+	}
+
+	// The first pass has only trivial twiddles
+	for i := 0; i < n; i += 2 {
+		a[i], a[i+1] = a[i]+a[i+1], a[i]-a[i+1]
+	}
+	for size := 4; size <= n; size <<= 1 {
+		half, step := size/2, n/size
+		for start := 0; start < n; start += size {
+			lo, hi := a[start:start+half], a[start+half:start+size]
+			for k := range lo {
+				t := tw[k*step] * hi[k]
+				lo[k], hi[k] = lo[k]+t, lo[k]-t
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2026 Harry Huang
+package minicv
+
+import (
+	"image"
+	"math"
+	"math/bits"
+	"slices"
+)
+
+// DHash computes the 64-bit difference hash of an image, from the gradients of its 9x8 gray thumbnail.
+// Bit i is set when pixel i of the thumbnail is brighter than its right neighbor.
+func DHash(img *image.RGBA) uint64 {
+	gray := imageGrayResize(img, 9, 8)
+	var hash uint64
+	for y := range 8 {
+		for x := range 8 {
+			if gray[y*9+x] > gray[y*9+x+1] {
+				hash |= 1 << (y*8 + x)
+			}
+		}
+	}
+	return hash
+}
+
+// PHash computes the 64-bit perceptual hash of an image, from the lowest 8x8 frequencies of the DCT of
+// its 32x32 gray thumbnail. Bit i is set when frequency i is above the median of them.
+func PHash(img *image.RGBA) uint64 {
+	const size, low = 32, 8
+	gray := imageGrayResize(img, size, size)
+
+	var cosTable [low][size]float64
+	for u := range low {
+		for x := range size {
+			cosTable[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * size))
+		}
+	}
+
+	// Separable DCT-II, keeping the low frequencies only
+	var rows [size][low]float64
+	for y := range size {
+		for u := range low {
+			sum := 0.0
+			for x := range size {
+				sum += gray[y*size+x] * cosTable[u][x]
+			}
+			rows[y][u] = sum
+		}
+	}
+	coeffs := make([]float64, low*low)
+	for v := range low {
+		for u := range low {
+			sum := 0.0
+			for y := range size {
+				sum += rows[y][u] * cosTable[v][y]
+			}
+			coeffs[v*low+u] = sum
+		}
+	}
+
+	sorted := slices.Clone(coeffs)
+	slices.Sort(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+
+	var hash uint64
+	for i, c := range coeffs {
+		if c > median {
+			hash |= 1 << i
+		}
+	}
+	return hash
+}
+
+// HammingDistance returns the number of differing bits of two hashes
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// imageGrayResize resizes an image to w x h gray levels by averaging the pixels covered by each cell
+func imageGrayResize(img *image.RGBA, w, h int) []float64 {
+	iw, ih := img.Rect.Dx(), img.Rect.Dy()
+	ipx, is := img.Pix, img.Stride
+	gray := make([]float64, w*h)
+	if iw == 0 || ih == 0 {
+		return gray
+	}
+	for y := range h {
+		y0 := y * ih / h
+		y1 := max(y0+1, (y+1)*ih/h)
+		for x := range w {
+			x0 := x * iw / w
+			x1 := max(x0+1, (x+1)*iw/w)
+			sum := 0.0
+			for sy := y0; sy < y1; sy++ {
+				off := sy*is + x0*4
+				for range x1 - x0 {
+					sum += 0.299*float64(ipx[off]) + 0.587*float64(ipx[off+1]) + 0.114*float64(ipx[off+2])
+					off += 4
+				}
+			}
+			gray[y*w+x] = sum / float64((x1-x0)*(y1-y0))
+		}
+	}
+	return gray
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2026 Harry Huang
+package minicv
+
+import (
+	"image"
+	"math"
+)
+
+// Mask marks the pixels of a needle that take part in matching. A nil mask means every pixel does.
+type Mask struct {
+	W, H  int
+	Count int       // Number of pixels in the mask
+	runs  []maskRun // Horizontal runs of pixels in the mask, row by row
+}
+
+// maskRun is a run of pixels [x0, x1) in row y of a mask
+type maskRun struct {
+	y, x0, x1 int
+}
+
+// newMask creates a w x h mask of the pixels for which in returns true
+func newMask(w, h int, in func(x, y int) bool) *Mask {
+	m := &Mask{W: w, H: h}
+	for y := range h {
+		for x := 0; x < w; {
+			if !in(x, y) {
+				x++
+				continue
+			}
+			x0 := x
+			for x < w && in(x, y) {
+				x++
+			}
+			m.runs = append(m.runs, maskRun{y, x0, x})
+			m.Count += x - x0
+		}
+	}
+	return m
+}
+
+// NewAlphaMask creates a mask of the pixels of an image that are at least half opaque
+func NewAlphaMask(img *image.RGBA) *Mask {
+	return newMask(img.Rect.Dx(), img.Rect.Dy(), func(x, y int) bool {
+		return img.Pix[y*img.Stride+x*4+3] >= 128
+	})
+}
+
+// NewCircleMask creates a w x h mask of the circle inscribed in it
+func NewCircleMask(w, h int) *Mask {
+	cx, cy := float64(w-1)/2, float64(h-1)/2
+	r := float64(min(w, h)) / 2
+	return newMask(w, h, func(x, y int) bool {
+		dx, dy := float64(x)-cx, float64(y)-cy
+		return dx*dx+dy*dy <= r*r
+	})
+}
+
+// IsFull reports whether every pixel is in the mask
+func (m *Mask) IsFull() bool {
+	return m.Count == m.W*m.H
+}
+
+// GetMaskedImageStats computes the mean and standard deviation of pixel values in the mask of an image
+func GetMaskedImageStats(img *image.RGBA, mask *Mask) StatsResult {
+	if mask == nil {
+		return GetImageStats(img)
+	}
+	ipx, is := img.Pix, img.Stride
+
+	sum := 0.0
+	sumSq := 0.0
+
+	for _, r := range mask.runs {
+		off := r.y*is + r.x0*4
+		for range r.x1 - r.x0 {
+			r, g, b := float64(ipx[off]), float64(ipx[off+1]), float64(ipx[off+2])
+			sum += r + g + b
+			sumSq += r*r + g*g + b*b
+			off += 4
+		}
+	}
+
+	count := float64(mask.Count * 3)
+	if count == 0 {
+		return StatsResult{}
+	}
+	mean := sum / count
+	variance := sumSq - count*(mean*mean)
+	if variance < 0 {
+		return StatsResult{Mean: mean, Std: 0}
+	}
+	return StatsResult{mean, math.Sqrt(variance)}
+}
+
+// ComputeMaskedNCC computes the normalized cross-correlation of a needle at top-left position (ox, oy) in a haystack,
+// over the pixels in the mask of the needle only. nStats must be computed with the same mask.
+func ComputeMaskedNCC(hRGBA *image.RGBA, hInt IntegralArray, nRGBA *image.RGBA, nMask *Mask, ox, oy int, nStats StatsResult) float64 {
+	if nMask == nil {
+		return ComputeNCC(hRGBA, hInt, nRGBA, ox, oy, nStats)
+	}
+	hp, np, hs, ns := hRGBA.Pix, nRGBA.Pix, hRGBA.Stride, nRGBA.Stride
+	var dot uint64
+	for _, r := range nMask.runs {
+		hi, ni := (oy+r.y)*hs+(ox+r.x0)*4, r.y*ns+r.x0*4
+		for range r.x1 - r.x0 {
+			dot += uint64(hp[hi]) * uint64(np[ni])
+			dot += uint64(hp[hi+1]) * uint64(np[ni+1])
+			dot += uint64(hp[hi+2]) * uint64(np[ni+2])
+			hi += 4
+			ni += 4
+		}
+	}
+	shn := float64(dot)
+	sh, ssh := hInt.GetMaskedAreaIntegral(ox, oy, nMask)
+	cnt := float64(nMask.Count * 3)
+	mh := sh / cnt
+	vh := ssh - cnt*mh*mh
+	if vh < 1e-3 {
+		return 0.0
+	}
+	dh := math.Sqrt(vh)
+	return (shn - cnt*mh*nStats.Mean) / (dh * nStats.Std)
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2026 Harry Huang
+package minicv
+
+import (
+	"image"
+	"math"
+)
+
+// PolarSignature holds an image sampled on a polar grid around a center, for finding rotations.
+// Angles are sampled clockwise from up, at Bins evenly spaced angles on each circle of Radii.
+type PolarSignature struct {
+	Bins  int
+	Radii []float64
+	rows  [][]complex128 // FFT over the angles of the circles and RGB channels, packed two per row
+	count int            // Number of samples
+	mean  float64        // Mean of all samples
+	std   float64        // Standard deviation of all samples (unnormalized)
+}
+
+// NewPolarSignature samples an image around center (cx, cy) with bilinear interpolation.
+// Coordinates are of pixel centers, and bins must be a power of 2.
+func NewPolarSignature(img *image.RGBA, cx, cy float64, radii []float64, bins int) *PolarSignature {
+	// The real part of the cross-correlation of two complex signals is the sum of the cross-correlations
+	// of their real parts and of their imaginary parts, so two real rows are packed into one complex row
+	// to halve the transforms
+	realRows := len(radii) * 3
+	sig := &PolarSignature{Bins: bins, Radii: radii, rows: make([][]complex128, (realRows+1)/2), count: bins * realRows}
+	for j := range sig.rows {
+		sig.rows[j] = make([]complex128, bins)
+	}
+	sins, coss := make([]float64, bins), make([]float64, bins)
+	for k := range bins {
+		sins[k], coss[k] = math.Sincos(2 * math.Pi * float64(k) / float64(bins))
+	}
+
+	var sum, sumSq float64
+	for ri, r := range radii {
+		for k := range bins {
+			px := imageSampleBilinear(img, cx+r*sins[k], cy-r*coss[k])
+			for c, v := range px {
+				j := ri*3 + c
+				if j%2 == 0 {
+					sig.rows[j/2][k] += complex(v, 0)
+				} else {
+					sig.rows[j/2][k] += complex(0, v)
+				}
+				sum += v
+				sumSq += v * v
+			}
+		}
+	}
+
+	n := float64(sig.count)
+	sig.mean = sum / n
+	sig.std = math.Sqrt(max(0, sumSq-n*sig.mean*sig.mean))
+
+	plan := getFFTPlan(bins, false)
+	for _, row := range sig.rows {
+		plan.transform(row)
+	}
+	return sig
+}
+
+// EstimateRotation finds the clockwise rotation in degrees [0, 360) of the signature s relative to the signature t,
+// with sub-bin precision, and the normalized cross-correlation of both signatures at that rotation.
+// Both signatures must be sampled with the same radii and bins.
+func EstimateRotation(s, t *PolarSignature) (float64, float64) {
+	bins := s.Bins
+	if s.std < 1e-6 || t.std < 1e-6 {
+		return 0, 0
+	}
+
+	// Circular cross-correlation over the angles, summed over all rows
+	corr := make([]complex128, bins)
+	for i, sr := range s.rows {
+		tr := t.rows[i]
+		for k := range corr {
+			corr[k] += sr[k] * conj(tr[k])
+		}
+	}
+	getFFTPlan(bins, true).transform(corr)
+
+	n := float64(s.count)
+	scores := make([]float64, bins)
+	best := 0
+	for k, v := range corr {
+		scores[k] = (real(v)/float64(bins) - n*s.mean*t.mean) / (s.std * t.std)
+		if scores[k] > scores[best] {
+			best = k
+		}
+	}
+
+	// Fit a parabola through the peak and its neighbors
+	prev, next := scores[(best+bins-1)%bins], scores[(best+1)%bins]
+	offset := 0.0
+	if d := prev - 2*scores[best] + next; d < 0 {
+		offset = max(-0.5, min(0.5, (prev-next)/(2*d)))
+	}
+	angle := (float64(best) + offset) * 360 / float64(bins)
+	return math.Mod(angle+360, 360), scores[best]
+}
+
+// imageSampleBilinear returns the RGB of an image at (x, y) in pixel-center coordinates with bilinear interpolation,
+// clamping to the image bounds
+func imageSampleBilinear(img *image.RGBA, x, y float64) [3]float64 {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	x, y = max(0, min(float64(w-1), x)), max(0, min(float64(h-1), y))
+	x0, y0 := int(x), int(y)
+	x1, y1 := min(x0+1, w-1), min(y0+1, h-1)
+	fx, fy := x-float64(x0), y-float64(y0)
+
+	var result [3]float64
+	o00, o01 := y0*img.Stride+x0*4, y0*img.Stride+x1*4
+	o10, o11 := y1*img.Stride+x0*4, y1*img.Stride+x1*4
+	for c := range result {
+		top := float64(img.Pix[o00+c])*(1-fx) + float64(img.Pix[o01+c])*fx
+		bottom := float64(img.Pix[o10+c])*(1-fx) + float64(img.Pix[o11+c])*fx
+		result[c] = top*(1-fy) + bottom*fy
+	}
+	return result
+}
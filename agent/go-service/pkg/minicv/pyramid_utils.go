@@ -0,0 +1,132 @@
+// Copyright (c) 2026 Harry Huang
+package minicv
+
+import (
+	"image"
+	"math"
+	"sync"
+)
+
+// PyramidLevel is an image at one scale of a pyramid, with its integral array
+type PyramidLevel struct {
+	Scale    float64
+	Img      *image.RGBA
+	Integral IntegralArray
+}
+
+// Pyramid holds an image at several scales. Levels are built on first use and stay cached,
+// so that switching between scales does not rebuild them.
+type Pyramid struct {
+	mu     sync.Mutex
+	base   *image.RGBA
+	levels map[float64]*PyramidLevel
+}
+
+// MatchCandidate is a top-left position of a needle in a haystack with its matching score
+type MatchCandidate struct {
+	X, Y  int
+	Score float64
+}
+
+// NewPyramid creates a pyramid of the base image without building any level
+func NewPyramid(base *image.RGBA) *Pyramid {
+	return &Pyramid{base: base, levels: make(map[float64]*PyramidLevel)}
+}
+
+// Level returns the level at the given scale, building it on first use
+func (p *Pyramid) Level(scale float64) *PyramidLevel {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if l, ok := p.levels[scale]; ok {
+		return l
+	}
+	img := p.base
+	if scale != 1.0 {
+		img = ImageScale(p.base, scale)
+	}
+	l := &PyramidLevel{Scale: scale, Img: img, Integral: GetIntegralArray(img)}
+	p.levels[scale] = l
+	return l
+}
+
+// PyramidScales returns the scales of a coarse-to-fine pyramid ending at finest,
+// halving the scale for each coarser level as long as it stays at least coarsest
+func PyramidScales(finest, coarsest float64) []float64 {
+	scales := []float64{finest}
+	for s := finest / 2; s >= coarsest; s /= 2 {
+		scales = append([]float64{s}, scales...)
+	}
+	return scales
+}
+
+// ComputeNCC computes the normalized cross-correlation of a needle at top-left position (ox, oy) in a haystack
+func ComputeNCC(hRGBA *image.RGBA, hInt IntegralArray, nRGBA *image.RGBA, ox, oy int, nStats StatsResult) float64 {
+	nW, nH := nRGBA.Rect.Dx(), nRGBA.Rect.Dy()
+	hp, np, hs, ns := hRGBA.Pix, nRGBA.Pix, hRGBA.Stride, nRGBA.Stride
+	var dot uint64
+	rb := oy*hs + ox*4
+	for y := 0; y < nH; y++ {
+		hi, ni := rb, y*ns
+		for x := 0; x < nW; x++ {
+			dot += uint64(hp[hi]) * uint64(np[ni])
+			dot += uint64(hp[hi+1]) * uint64(np[ni+1])
+			dot += uint64(hp[hi+2]) * uint64(np[ni+2])
+			hi += 4
+			ni += 4
+		}
+		rb += hs
+	}
+	shn := float64(dot)
+	sh, ssh := hInt.GetAreaIntegral(ox, oy, nW, nH)
+	cnt := float64(nW * nH * 3)
+	mh := sh / cnt
+	vh := ssh - cnt*mh*mh
+	if vh < 1e-3 {
+		return 0.0
+	}
+	dh := math.Sqrt(vh)
+	return (shn - cnt*mh*nStats.Mean) / (dh * nStats.Std)
+}
+
+// RefineMatch finds the best position of a needle within radius of top-left position (x, y), checking every position.
+// The mask may be nil.
+func RefineMatch(hRGBA *image.RGBA, hInt IntegralArray, nRGBA *image.RGBA, nMask *Mask, nStats StatsResult, x, y, radius int) MatchCandidate {
+	maxX, maxY := hRGBA.Rect.Dx()-nRGBA.Rect.Dx(), hRGBA.Rect.Dy()-nRGBA.Rect.Dy()
+	best := MatchCandidate{max(0, min(x, maxX)), max(0, min(y, maxY)), math.Inf(-1)}
+	for cy := max(0, y-radius); cy <= min(maxY, y+radius); cy++ {
+		for cx := max(0, x-radius); cx <= min(maxX, x+radius); cx++ {
+			if s := ComputeMaskedNCC(hRGBA, hInt, nRGBA, nMask, cx, cy, nStats); s > best.Score {
+				best = MatchCandidate{cx, cy, s}
+			}
+		}
+	}
+	return best
+}
+
+// MatchTemplatePyramid refines candidates found at the coarsest level of a pyramid through the finer levels.
+// hays, needles and masks hold the haystack, the needle and its mask at each level from coarse to fine,
+// and candidates are top-left positions at the coarsest level. masks may be nil to match every pixel. At each finer level, a candidate is moved by the
+// scale ratio around the center of the needle and refined within radius. Returns the candidates at the finest level.
+func MatchTemplatePyramid(hays []*PyramidLevel, needles []*image.RGBA, masks []*Mask, candidates []MatchCandidate, radius int) []MatchCandidate {
+	result := make([]MatchCandidate, len(candidates))
+	copy(result, candidates)
+
+	for l := 1; l < len(hays); l++ {
+		hay, needle, prev := hays[l], needles[l], needles[l-1]
+		var mask *Mask
+		if masks != nil {
+			mask = masks[l]
+		}
+		ratio := hay.Scale / hays[l-1].Scale
+		nStats := GetMaskedImageStats(needle, mask)
+		pw, ph := prev.Rect.Dx(), prev.Rect.Dy()
+		nw, nh := needle.Rect.Dx(), needle.Rect.Dy()
+		for i, c := range result {
+			x := int(math.Round((float64(c.X)+float64(pw)/2)*ratio - float64(nw)/2))
+			y := int(math.Round((float64(c.Y)+float64(ph)/2)*ratio - float64(nh)/2))
+			result[i] = RefineMatch(hay.Img, hay.Integral, needle, mask, nStats, x, y, radius)
+		}
+	}
+	return result
+}
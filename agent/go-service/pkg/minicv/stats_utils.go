@@ -84,3 +84,17 @@ func (ia *IntegralArray) GetAreaIntegral(x, y, w, h int) (float64, float64) {
 	sumSq := ia.SumSq[idx22] - ia.SumSq[idx12] - ia.SumSq[idx21] + ia.SumSq[idx11]
 	return sum, sumSq
 }
+
+// GetMaskedAreaIntegral returns (sum, sumSq) over the pixels of a mask placed at top-left position (x, y),
+// summing the integral array row by row
+func (ia *IntegralArray) GetMaskedAreaIntegral(x, y int, mask *Mask) (float64, float64) {
+	stride := ia.W + 1
+	var sum, sumSq float64
+	for _, r := range mask.runs {
+		idx11, idx12 := (y+r.y)*stride+x+r.x0, (y+r.y)*stride+x+r.x1
+		idx21, idx22 := idx11+stride, idx12+stride
+		sum += ia.Sum[idx22] - ia.Sum[idx12] - ia.Sum[idx21] + ia.Sum[idx11]
+		sumSq += ia.SumSq[idx22] - ia.SumSq[idx12] - ia.SumSq[idx21] + ia.SumSq[idx11]
+	}
+	return sum, sumSq
+}
@@ -3,10 +3,12 @@ package puzzle
 
 import (
 	"encoding/json"
+	"fmt"
 	"image"
 	"math"
 	"time"
 
+	"github.com/MaaXYZ/MaaEnd/agent/go-service/pkg/minicv"
 	"github.com/MaaXYZ/maa-framework-go/v4"
 	"github.com/rs/zerolog/log"
 )
@@ -47,18 +49,15 @@ type Recognition struct{}
 // Known color hues: 77 (green), 206(blue), 169(cyan), 33(orange)
 
 func getPossibleHues(puzzles []*PuzzleDesc) []int {
-	hues := make([]int, 0, len(puzzles))
+	hues := make([]float64, 0, len(puzzles))
 	for _, p := range puzzles {
-		hues = append(hues, p.Hue)
+		hues = append(hues, float64(p.Hue))
 	}
-	clusters := clusterHues(hues, PUZZLE_HUE_DIFF_GRT)
+	clusters := minicv.ClusterHues(hues, float64(PUZZLE_HUE_DIFF_GRT))
 
 	results := make([]int, 0, len(clusters))
 	for _, members := range clusters {
-		if len(members) == 0 {
-			continue
-		}
-		results = append(results, meanHue(members))
+		results = append(results, int(math.Round(minicv.GetHueStats(members).Mean)))
 	}
 	return results
 }
@@ -515,6 +514,7 @@ func (r *Recognition) Run(ctx *maa.Context, arg *maa.CustomRecognitionArg) (*maa
 	boardSize := getPossibleBoardSize(ctx, img)
 	if boardSize[0] == 0 || boardSize[1] == 0 {
 		log.Error().Msg("Failed to determine board size")
+		saveBoardDebugImage(img, arg.CurrentTaskName, boardSize[0], boardSize[1], nil, nil, "board size unknown")
 		return nil, false
 	}
 	log.Info().Int("boardW", boardSize[0]).Int("boardH", boardSize[1]).Msg("Determined possible board size")
@@ -543,6 +543,7 @@ func (r *Recognition) Run(ctx *maa.Context, arg *maa.CustomRecognitionArg) (*maa
 				Int("XProjLen", len(projDesc.XProjList)).Int("YProjLen", len(projDesc.YProjList)).
 				Int("boardW", boardSize[0]).Int("boardH", boardSize[1]).
 				Msg("Projection list length mismatch with board dimensions")
+			saveBoardDebugImage(img, arg.CurrentTaskName, boardSize[0], boardSize[1], banned, locked, fmt.Sprintf("projection mismatch for hue %d", hue))
 			return nil, false
 		}
 
@@ -3,12 +3,14 @@ package puzzle
 
 import (
 	"encoding/json"
+	"fmt"
 	"image"
 	"image/color"
 	"math"
 	"sort"
 	"time"
 
+	"github.com/MaaXYZ/MaaEnd/agent/go-service/pkg/minicv"
 	"github.com/MaaXYZ/maa-framework-go/v4"
 	"github.com/rs/zerolog/log"
 )
@@ -114,41 +116,6 @@ func getAreaVariance(img image.Image, rect image.Rectangle) float64 {
 	return (math.Sqrt(varR/count) + math.Sqrt(varG/count) + math.Sqrt(varB/count)) / 3.0
 }
 
-// rgbToHSV converts normalized RGB [0, 1] to HSV: Hue[0, 360), Saturation[0, 1], Value[0, 1]
-func rgbToHSV(fr, fg, fb float64) (float64, float64, float64) {
-	maxC := math.Max(fr, math.Max(fg, fb))
-	minC := math.Min(fr, math.Min(fg, fb))
-	delta := maxC - minC
-
-	// Value
-	v := maxC
-
-	// Saturation
-	s := 0.0
-	if maxC != 0 {
-		s = delta / maxC
-	}
-
-	// Hue
-	h := 0.0
-	if delta != 0 {
-		switch maxC {
-		case fr:
-			h = (fg - fb) / delta
-			if fg < fb {
-				h += 6
-			}
-		case fg:
-			h = (fb-fr)/delta + 2
-		default:
-			h = (fr-fg)/delta + 4
-		}
-		h *= 60
-	}
-
-	return h, s, v
-}
-
 // getAreaHSV calculates the Hue (Median), Saturation (Mean), and Value (Mean) of an area.
 // Hue is [0, 360), Saturation is [0, 1], Value is [0, 1].
 func getAreaHSV(img image.Image, rect image.Rectangle) (float64, float64, float64) {
@@ -159,9 +126,8 @@ func getAreaHSV(img image.Image, rect image.Rectangle) (float64, float64, float6
 	for y := rect.Min.Y; y < rect.Max.Y; y++ {
 		for x := rect.Min.X; x < rect.Max.X; x++ {
 			r, g, b, _ := img.At(x, y).RGBA()
-			fr, fg, fb := float64(r>>8)/255.0, float64(g>>8)/255.0, float64(b>>8)/255.0
 
-			h, s, v := rgbToHSV(fr, fg, fb)
+			h, s, v := minicv.RGBToHSV(uint8(r>>8), uint8(g>>8), uint8(b>>8))
 			hues = append(hues, h)
 			sumSat += s
 			sumVal += v
@@ -187,9 +153,8 @@ func getAreaHSV(img image.Image, rect image.Rectangle) (float64, float64, float6
 // getPixelHSV returns the Hue[0, 360), Saturation[0, 1], Value[0, 1] of a pixel
 func getPixelHSV(img image.Image, x, y int, targetHue int, targetHueAllowance int) (float64, float64, float64) {
 	r, g, b, _ := img.At(x, y).RGBA()
-	fr, fg, fb := float64(r>>8)/255.0, float64(g>>8)/255.0, float64(b>>8)/255.0
 
-	h, s, v := rgbToHSV(fr, fg, fb)
+	h, s, v := minicv.RGBToHSV(uint8(r>>8), uint8(g>>8), uint8(b>>8))
 
 	if targetHue >= 0 {
 		if diffHue(int(h), targetHue) > targetHueAllowance {
@@ -207,9 +172,8 @@ func getSVGBImage(img image.Image) image.Image {
 	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
 		for x := bounds.Min.X; x < bounds.Max.X; x++ {
 			r, g, b, _ := img.At(x, y).RGBA()
-			fr, fg, fb := float64(r>>8)/255.0, float64(g>>8)/255.0, float64(b>>8)/255.0
 
-			_, s, v := rgbToHSV(fr, fg, fb)
+			_, s, v := minicv.RGBToHSV(uint8(r>>8), uint8(g>>8), uint8(b>>8))
 
 			newImg.SetRGBA(x, y, color.RGBA{0, uint8(s * 255), uint8(v * 255), 255})
 		}
@@ -219,60 +183,7 @@ func getSVGBImage(img image.Image) image.Image {
 
 // diffHue returns the smallest difference between two hues [0, 360)
 func diffHue(h1, h2 int) int {
-	diff := int(math.Abs(float64(h1 - h2)))
-	if diff > 180 {
-		diff = 360 - diff
-	}
-	return diff
-}
-
-// meanHue calculates the circular mean of a slice of hues [0, 360)
-func meanHue(hues []int) int {
-	if len(hues) == 0 {
-		return 0
-	}
-	var sumSin, sumCos float64
-	for _, h := range hues {
-		rad := float64(h) * math.Pi / 180.0
-		sumSin += math.Sin(rad)
-		sumCos += math.Cos(rad)
-	}
-	avgRad := math.Atan2(sumSin, sumCos)
-	avgDeg := avgRad * 180.0 / math.Pi
-	if avgDeg < 0 {
-		avgDeg += 360
-	}
-	return int(math.Round(avgDeg))
-}
-
-// clusterHues groups hues that are close to each other within maxDiff
-func clusterHues(hues []int, maxDiff int) map[int][]int {
-	clusters := make(map[int][]int)
-	processed := make(map[int]bool)
-
-	for _, h1 := range hues {
-		if processed[h1] {
-			continue
-		}
-
-		clusterID := h1
-		// Check if h1 belongs to an existing cluster
-		foundCluster := false
-		for center := range clusters {
-			if diffHue(h1, center) <= maxDiff {
-				clusterID = center
-				foundCluster = true
-				break
-			}
-		}
-
-		if !foundCluster {
-			clusters[clusterID] = []int{}
-		}
-		clusters[clusterID] = append(clusters[clusterID], h1)
-		processed[h1] = true
-	}
-	return clusters
+	return int(minicv.HueDiff(float64(h1), float64(h2)))
 }
 
 /* ******** Coordinate Conversions ******** */
@@ -336,3 +247,49 @@ func (aw *ActionWrapper) TypeKeySync(keyCode int, delayMillis int) {
 	aw.ctrl.PostClickKey(int32(keyCode)).Wait()
 	time.Sleep(time.Duration(delayMillis) * time.Millisecond)
 }
+
+/* ******** Debug Images ******** */
+
+// saveBoardDebugImage saves the image annotated with the board area, the grid of the board size,
+// and the banned and locked blocks, to see what the recognition thought the board was.
+// The image is named after the node. Failures are logged only.
+func saveBoardDebugImage(img image.Image, nodeName string, boardW, boardH int, banned [][2]int, locked []*LockedBlockDesc, reason string) {
+	annotated := minicv.ImageCopyRGBA(img)
+	boundsColor := color.RGBA{0, 160, 255, 255}
+	gridColor := color.RGBA{255, 255, 255, 255}
+	bannedColor := color.RGBA{255, 0, 0, 255}
+	lockedColor := color.RGBA{255, 220, 0, 255}
+
+	bounds := image.Rect(int(BOARD_X_LOWER_BOUND), int(BOARD_Y_LOWER_BOUND), int(BOARD_X_UPPER_BOUND), int(BOARD_Y_UPPER_BOUND))
+	minicv.DrawRect(annotated, bounds, boundsColor, 1)
+	minicv.DrawLabel(annotated, bounds.Min.X, bounds.Min.Y-14, fmt.Sprintf("board %dx%d: %s", boardW, boardH, reason), boundsColor)
+
+	blockRect := func(ltX, ltY int) image.Rectangle {
+		return image.Rect(ltX, ltY, ltX+int(BOARD_BLOCK_W), ltY+int(BOARD_BLOCK_H))
+	}
+	for gridY := range boardH {
+		for gridX := range boardW {
+			minicv.DrawRect(annotated, blockRect(convertBoardCoordToLTCoord(gridX, gridY, boardW, boardH)), gridColor, 1)
+		}
+	}
+	for _, b := range banned {
+		r := blockRect(b[0], b[1])
+		minicv.DrawRect(annotated, r, bannedColor, 2)
+		minicv.DrawLabel(annotated, r.Min.X+2, r.Min.Y+2, "X", bannedColor)
+	}
+	for _, lb := range locked {
+		if lb == nil {
+			continue
+		}
+		r := blockRect(lb.RawLoc[0], lb.RawLoc[1])
+		minicv.DrawRect(annotated, r, lockedColor, 2)
+		minicv.DrawLabel(annotated, r.Min.X+2, r.Min.Y+2, fmt.Sprintf("H%d", lb.Hue), lockedColor)
+	}
+
+	path, err := minicv.SaveDebugImage(annotated, "", nodeName)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to save board debug image")
+		return
+	}
+	log.Info().Str("path", path).Str("reason", reason).Msg("Saved board debug image")
+}
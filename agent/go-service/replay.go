@@ -0,0 +1,330 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/MaaXYZ/MaaEnd/agent/go-service/autofight"
+	"github.com/MaaXYZ/MaaEnd/agent/go-service/dailyrewards"
+	maptracker "github.com/MaaXYZ/MaaEnd/agent/go-service/map-tracker"
+	puzzle "github.com/MaaXYZ/MaaEnd/agent/go-service/puzzle-solver"
+	"github.com/MaaXYZ/MaaEnd/agent/go-service/resell"
+	"github.com/MaaXYZ/maa-framework-go/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// replayComponent is a custom recognition that can be replayed offline
+type replayComponent struct {
+	Name   string
+	Runner maa.CustomRecognitionRunner
+}
+
+// replayComponents lists all recognitions supported by the replay mode, in execution order.
+// Components that drive the controller (e.g. PuzzleRecognition) run against a carousel
+// controller serving the same screenshots, so their actions have no effect.
+func replayComponents() []replayComponent {
+	return []replayComponent{
+		{"MapTrackerInfer", &maptracker.MapTrackerInfer{}},
+		{"PuzzleRecognition", &puzzle.Recognition{}},
+		{"ResellCheckQuotaRecognition", &resell.ResellCheckQuotaRecognition{}},
+		{"AutoFightEntryRecognition", &autofight.AutoFightEntryRecognition{}},
+		{"AutoFightExitRecognition", &autofight.AutoFightExitRecognition{}},
+		{"AutoFightPauseRecognition", &autofight.AutoFightPauseRecognition{}},
+		{"AutoFightExecuteRecognition", &autofight.AutoFightExecuteRecognition{}},
+		{"DailyEventUnreadItemInitRecognition", &dailyrewards.DailyEventUnreadItemInitRecognition{}},
+		{"DailyEventUnreadItemSwitchRecognition", &dailyrewards.DailyEventUnreadItemSwitchRecognition{}},
+		{"DailyEventUnreadDetailInitRecognition", &dailyrewards.DailyEventUnreadDetailInitRecognition{}},
+		{"DailyEventUnreadDetailPickRecognition", &dailyrewards.DailyEventUnreadDetailPickRecognition{}},
+		{"DailyEventRewardsPopupRecognition", &dailyrewards.DailyEventRewardsPopupRecognition{}},
+	}
+}
+
+// replayRecord is one line of the replay JSONL report
+type replayRecord struct {
+	Image     string          `json:"image"`     // Screenshot file name
+	Component string          `json:"component"` // Custom recognition name
+	Hit       bool            `json:"hit"`       // Whether the recognition hit
+	Box       [4]int          `json:"box"`       // Hit box [x, y, w, h]
+	Detail    json.RawMessage `json:"detail"`    // Detail JSON returned by the component
+	ElapsedMs int64           `json:"elapsedMs"` // Recognition time in ms
+	Error     string          `json:"error,omitempty"`
+}
+
+// runReplay runs the offline replay mode.
+// Usage: go-service replay <dir> [-resource a,b] [-components a,b] [-params file] [-out file]
+func runReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	resourceFlag := fs.String("resource", "resource_fast,resource", "comma-separated resource bundle paths, loaded in order")
+	componentsFlag := fs.String("components", "", "comma-separated component names to replay (default: all)")
+	paramsFlag := fs.String("params", "", "JSON file mapping component name to its custom_recognition_param")
+	outFlag := fs.String("out", "", "JSONL report path (default: debug/replay_<time>.jsonl)")
+
+	// Allow the screenshot directory to be given either before or after the flags
+	var dir string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		dir, args = args[0], args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if dir == "" {
+		dir = fs.Arg(0)
+	}
+	if dir == "" {
+		return fmt.Errorf("screenshot directory is required")
+	}
+
+	components, err := selectReplayComponents(*componentsFlag)
+	if err != nil {
+		return err
+	}
+	params, err := loadReplayParams(*paramsFlag)
+	if err != nil {
+		return err
+	}
+	images, err := listReplayImages(dir)
+	if err != nil {
+		return err
+	}
+
+	outPath := *outFlag
+	if outPath == "" {
+		outPath = filepath.Join("debug", fmt.Sprintf("replay_%s.jsonl", time.Now().Format("20060102_150405")))
+	}
+	if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+		return fmt.Errorf("failed to create report directory: %w", err)
+	}
+	outFile, err := os.Create(outPath)
+	if err != nil {
+		return fmt.Errorf("failed to create report file: %w", err)
+	}
+	defer outFile.Close()
+
+	initMaaFramework()
+	defer maa.Release()
+
+	tasker, err := newReplayTasker(dir, strings.Split(*resourceFlag, ","), components)
+	if err != nil {
+		return err
+	}
+	defer tasker.Destroy()
+
+	log.Info().
+		Str("dir", dir).
+		Int("images", len(images)).
+		Int("components", len(components)).
+		Str("out", outPath).
+		Msg("Starting replay")
+
+	encoder := json.NewEncoder(outFile)
+	hitCount := make(map[string]int)
+	for _, imgPath := range images {
+		img, err := loadReplayImage(imgPath)
+		if err != nil {
+			log.Warn().Err(err).Str("path", imgPath).Msg("Failed to load replay image, skipping")
+			continue
+		}
+		for _, c := range components {
+			record := replayRecognition(tasker, c.Name, params[c.Name], img)
+			record.Image = filepath.Base(imgPath)
+			if record.Hit {
+				hitCount[c.Name]++
+			}
+			if err := encoder.Encode(record); err != nil {
+				return fmt.Errorf("failed to write report: %w", err)
+			}
+		}
+	}
+
+	for _, c := range components {
+		log.Info().
+			Str("component", c.Name).
+			Int("hit", hitCount[c.Name]).
+			Int("total", len(images)).
+			Msg("Replay component summary")
+	}
+	log.Info().Str("out", outPath).Msg("Replay finished")
+	return nil
+}
+
+// selectReplayComponents filters replay components by a comma-separated name list
+func selectReplayComponents(names string) ([]replayComponent, error) {
+	all := replayComponents()
+	if strings.TrimSpace(names) == "" {
+		return all, nil
+	}
+
+	byName := make(map[string]replayComponent, len(all))
+	for _, c := range all {
+		byName[c.Name] = c
+	}
+
+	selected := make([]replayComponent, 0)
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		c, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown replay component: %s", name)
+		}
+		selected = append(selected, c)
+	}
+	return selected, nil
+}
+
+// loadReplayParams loads per-component custom_recognition_param from a JSON file
+func loadReplayParams(path string) (map[string]json.RawMessage, error) {
+	params := make(map[string]json.RawMessage)
+	if path == "" {
+		return params, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read params file: %w", err)
+	}
+	if err := json.Unmarshal(data, &params); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal params file: %w", err)
+	}
+	return params, nil
+}
+
+// listReplayImages returns all PNG files in dir, sorted by file name
+func listReplayImages(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read screenshot directory: %w", err)
+	}
+
+	images := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(strings.ToLower(entry.Name()), ".png") {
+			continue
+		}
+		images = append(images, filepath.Join(dir, entry.Name()))
+	}
+	if len(images) == 0 {
+		return nil, fmt.Errorf("no PNG screenshots found in %s", dir)
+	}
+	sort.Strings(images)
+	return images, nil
+}
+
+func loadReplayImage(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	return img, err
+}
+
+// newReplayTasker creates a tasker with the given resource bundles and a carousel controller
+func newReplayTasker(dir string, bundles []string, components []replayComponent) (*maa.Tasker, error) {
+	res, err := maa.NewResource()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resource: %w", err)
+	}
+	maptracker.AddResourcePathSink(res)
+
+	for _, bundle := range bundles {
+		bundle = strings.TrimSpace(bundle)
+		if bundle == "" {
+			continue
+		}
+		if !res.PostBundle(bundle).Wait().Success() {
+			return nil, fmt.Errorf("failed to load resource bundle: %s", bundle)
+		}
+		log.Info().Str("bundle", bundle).Msg("Resource bundle loaded for replay")
+	}
+
+	for _, c := range components {
+		if err := res.RegisterCustomRecognition(c.Name, c.Runner); err != nil {
+			return nil, fmt.Errorf("failed to register %s: %w", c.Name, err)
+		}
+	}
+
+	ctrl, err := maa.NewCarouselImageController(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create carousel controller: %w", err)
+	}
+	if !ctrl.PostConnect().Wait().Success() {
+		return nil, fmt.Errorf("failed to connect carousel controller")
+	}
+
+	tasker, err := maa.NewTasker()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tasker: %w", err)
+	}
+	if err := tasker.BindResource(res); err != nil {
+		return nil, fmt.Errorf("failed to bind resource: %w", err)
+	}
+	if err := tasker.BindController(ctrl); err != nil {
+		return nil, fmt.Errorf("failed to bind controller: %w", err)
+	}
+	if !tasker.Initialized() {
+		return nil, fmt.Errorf("tasker not initialized")
+	}
+	return tasker, nil
+}
+
+// replayRecognition runs one custom recognition on one image and builds its report record
+func replayRecognition(tasker *maa.Tasker, name string, param json.RawMessage, img image.Image) replayRecord {
+	record := replayRecord{Component: name, Detail: json.RawMessage("null")}
+
+	recParam := &maa.CustomRecognitionParam{CustomRecognition: name}
+	if len(param) > 0 {
+		recParam.CustomRecognitionParam = param
+	}
+
+	t0 := time.Now()
+	job := tasker.PostRecognition(maa.RecognitionTypeCustom, recParam, img).Wait()
+	record.ElapsedMs = time.Since(t0).Milliseconds()
+
+	detail, err := job.GetDetail()
+	if err != nil {
+		record.Error = err.Error()
+		return record
+	}
+	if detail == nil || len(detail.NodeDetails) == 0 || detail.NodeDetails[0] == nil || detail.NodeDetails[0].Recognition == nil {
+		record.Error = "recognition detail is empty"
+		return record
+	}
+
+	reco := detail.NodeDetails[0].Recognition
+	record.Hit = reco.Hit
+	record.Box = [4]int{reco.Box.X(), reco.Box.Y(), reco.Box.Width(), reco.Box.Height()}
+	record.Detail = extractReplayDetail(reco.DetailJson)
+	return record
+}
+
+// extractReplayDetail unwraps the custom recognition Detail JSON from "best.detail" if present
+func extractReplayDetail(detailJson string) json.RawMessage {
+	if detailJson == "" {
+		return json.RawMessage("null")
+	}
+	var wrapped struct {
+		Best struct {
+			Detail json.RawMessage `json:"detail"`
+		} `json:"best"`
+	}
+	if err := json.Unmarshal([]byte(detailJson), &wrapped); err == nil && len(wrapped.Best.Detail) > 0 {
+		return wrapped.Best.Detail
+	}
+	if json.Valid([]byte(detailJson)) {
+		return json.RawMessage(detailJson)
+	}
+	quoted, _ := json.Marshal(detailJson)
+	return quoted
+}
@@ -1,9 +1,13 @@
 package resell
 
 import (
+	"encoding/json"
 	"fmt"
+	"image"
+	"image/color"
 
 	"github.com/MaaXYZ/MaaEnd/agent/go-service/pkg/maafocus"
+	"github.com/MaaXYZ/MaaEnd/agent/go-service/pkg/minicv"
 	"github.com/MaaXYZ/maa-framework-go/v4"
 	"github.com/rs/zerolog/log"
 )
@@ -13,6 +17,7 @@ type ResellDecideAction struct{}
 
 func (a *ResellDecideAction) Run(ctx *maa.Context, arg *maa.CustomActionArg) bool {
 	records, overflowAmount, MinimumProfit := getState()
+	saveScanDebugImage(ctx, records, arg.CurrentTaskName)
 
 	if len(records) == 0 {
 		log.Info().Msg("[Resell]库存已售罄，无可购买商品")
@@ -68,3 +73,67 @@ func (a *ResellDecideAction) Run(ctx *maa.Context, arg *maa.CustomActionArg) boo
 	ctx.OverrideNext(arg.CurrentTaskName, []maa.NextItem{{Name: "ChangeNextRegionPrepare"}})
 	return true
 }
+
+// saveScanDebugImage 本轮扫描有好友出售价识别失败时，在被扫描的列表画面上标注已扫描商品格的价格区域后保存到 debug 目录。
+// 有利润记录的格子为绿色并标注利润，识别失败为红色，无商品为灰色
+func saveScanDebugImage(ctx *maa.Context, records []ProfitRecord, nodeName string) {
+	frame, outcomes := getScanResult()
+	failed := false
+	for _, outcome := range outcomes {
+		failed = failed || outcome == scanOutcomeFailed
+	}
+	if !failed {
+		return
+	}
+	if frame == nil {
+		log.Warn().Msg("[Resell]无列表画面，跳过保存调试图")
+		return
+	}
+	profits := make(map[[2]int]int, len(records))
+	for _, r := range records {
+		profits[[2]int{r.Row, r.Col}] = r.Profit
+	}
+
+	annotated := minicv.ImageCopyRGBA(frame)
+	for pos, outcome := range outcomes {
+		roi, ok := getNodeROI(ctx, fmt.Sprintf("ResellROIProductRow%dCol%dPrice", pos[0], pos[1]))
+		if !ok {
+			continue
+		}
+		c, label := color.RGBA{128, 128, 128, 255}, fmt.Sprintf("%d-%d", pos[0], pos[1])
+		switch outcome {
+		case scanOutcomeProfit:
+			c, label = color.RGBA{0, 255, 0, 255}, fmt.Sprintf("%d-%d: %d", pos[0], pos[1], profits[pos])
+		case scanOutcomeFailed:
+			c = color.RGBA{255, 0, 0, 255}
+		}
+		minicv.DrawRect(annotated, roi, c, 1)
+		minicv.DrawLabel(annotated, roi.Min.X+2, roi.Min.Y+2, label, c)
+	}
+	path, err := minicv.SaveDebugImage(annotated, "", nodeName)
+	if err != nil {
+		log.Warn().Err(err).Msg("[Resell]保存调试图失败")
+		return
+	}
+	log.Info().Str("path", path).Msg("[Resell]好友出售价识别失败，已保存商品格调试图")
+}
+
+// getNodeROI 读取 Pipeline 节点识别参数中的 roi
+func getNodeROI(ctx *maa.Context, nodeName string) (image.Rectangle, bool) {
+	raw, err := ctx.GetNodeJSON(nodeName)
+	if err != nil {
+		return image.Rectangle{}, false
+	}
+	var node struct {
+		Recognition struct {
+			Param struct {
+				ROI []int `json:"roi"`
+			} `json:"param"`
+		} `json:"recognition"`
+	}
+	if err := json.Unmarshal([]byte(raw), &node); err != nil || len(node.Recognition.Param.ROI) != 4 {
+		return image.Rectangle{}, false
+	}
+	r := node.Recognition.Param.ROI
+	return image.Rect(r[0], r[1], r[0]+r[2], r[1]+r[3]), true
+}
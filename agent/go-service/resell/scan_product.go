@@ -36,6 +36,15 @@ func (a *ResellScanAction) Run(ctx *maa.Context, arg *maa.CustomActionArg) bool
 	})
 	if controller := ctx.GetTasker().GetController(); controller != nil {
 		MoveMouseSafe(controller) // 为 Step1 的 OCR 识别挪开鼠标
+		if rowIdx == 1 && col == 1 {
+			// 扫描期间列表不变，保留首格扫描时的画面供识别失败时标注
+			controller.PostScreencap().Wait()
+			img, err := controller.CacheImage()
+			if err != nil {
+				log.Warn().Err(err).Msg("[Resell]获取列表画面失败")
+			}
+			resetScanFrame(img)
+		}
 	}
 	return true
 }
@@ -67,12 +76,14 @@ func (a *ResellScanFriendPriceAction) Run(ctx *maa.Context, arg *maa.CustomActio
 	text := extractOCRText(arg.RecognitionDetail)
 	if text == "" {
 		log.Info().Msg("[Resell]未能识别好友出售价")
+		setScanOutcome(rowIdx, col, scanOutcomeFailed)
 		resellScanOverrideNext(ctx, arg.CurrentTaskName, rowIdx, col, false)
 		return true
 	}
 	salePrice, ok := extractNumbersFromText(text)
 	if !ok {
 		log.Info().Str("text", text).Msg("[Resell]好友出售价区域无有效数字")
+		setScanOutcome(rowIdx, col, scanOutcomeFailed)
 		resellScanOverrideNext(ctx, arg.CurrentTaskName, rowIdx, col, false)
 		return true
 	}
@@ -82,6 +93,7 @@ func (a *ResellScanFriendPriceAction) Run(ctx *maa.Context, arg *maa.CustomActio
 	profit := salePrice - costPrice
 	record := ProfitRecord{Row: rowIdx, Col: col, CostPrice: costPrice, SalePrice: salePrice, Profit: profit}
 	appendRecord(record)
+	setScanOutcome(rowIdx, col, scanOutcomeProfit)
 	return true
 }
 
@@ -91,6 +103,7 @@ type ResellScanSkipEmptyAction struct{}
 func (a *ResellScanSkipEmptyAction) Run(ctx *maa.Context, arg *maa.CustomActionArg) bool {
 	rowIdx, col := getScanPos()
 	log.Info().Int("行", rowIdx).Int("列", col).Msg("[Resell]位置无数字，无商品，跳下一格")
+	setScanOutcome(rowIdx, col, scanOutcomeEmpty)
 	resellScanOverrideNext(ctx, arg.CurrentTaskName, rowIdx, col, true)
 	return true
 }
@@ -1,6 +1,9 @@
 package resell
 
-import "sync"
+import (
+	"image"
+	"sync"
+)
 
 var (
 	stateMu         sync.Mutex
@@ -10,6 +13,17 @@ var (
 	scanCostPrice   int
 	scanRow         int
 	scanCol         int
+	scanFrame       image.Image
+	scanOutcomes    = map[[2]int]scanOutcome{}
+)
+
+// scanOutcome 单个商品格的识别结果，用于调试图着色
+type scanOutcome int
+
+const (
+	scanOutcomeEmpty  scanOutcome = iota // 列表价格区域无数字（无商品）
+	scanOutcomeProfit                    // 已记录利润
+	scanOutcomeFailed                    // 好友出售价识别失败
 )
 
 func getState() ([]ProfitRecord, int, int) {
@@ -67,3 +81,27 @@ func getScanPos() (int, int) {
 	defer stateMu.Unlock()
 	return scanRow, scanCol
 }
+
+// resetScanFrame 开始新一轮扫描：记录被扫描的列表画面并清空各格结果
+func resetScanFrame(img image.Image) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	scanFrame = img
+	scanOutcomes = map[[2]int]scanOutcome{}
+}
+
+func setScanOutcome(row, col int, outcome scanOutcome) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	scanOutcomes[[2]int{row, col}] = outcome
+}
+
+func getScanResult() (image.Image, map[[2]int]scanOutcome) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	outcomes := make(map[[2]int]scanOutcome, len(scanOutcomes))
+	for k, v := range scanOutcomes {
+		outcomes[k] = v
+	}
+	return scanFrame, outcomes
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+
+	maptracker "github.com/MaaXYZ/MaaEnd/agent/go-service/map-tracker"
+	"github.com/rs/zerolog/log"
+)
+
+// runWalkMask writes draft walkable masks of the maps, to be reviewed by hand before shipping.
+// Usage: go-service walkmask [-resource dir] [-regex r] [-min-luminance v] [-out dir] [-overwrite]
+func runWalkMask(args []string) error {
+	fs := flag.NewFlagSet("walkmask", flag.ContinueOnError)
+	resourceFlag := fs.String("resource", "resource", "resource directory containing image/MapTracker")
+	regexFlag := fs.String("regex", maptracker.DEFAULT_INFERENCE_PARAM.MapNameRegex, "map name regex of the maps to generate masks for")
+	lumFlag := fs.Uint("min-luminance", 40, "min luminance (0-255) of a map pixel to be considered walkable")
+	outFlag := fs.String("out", "", "output directory (default: image/MapTracker/map/walkable in the resource directory)")
+	overwriteFlag := fs.Bool("overwrite", false, "overwrite existing masks")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	maptracker.SetResourcePath(*resourceFlag)
+
+	written, err := maptracker.GenerateWalkMasks(maptracker.WalkMaskGenOptions{
+		MapNameRegex: *regexFlag,
+		OutDir:       *outFlag,
+		MinLuminance: uint8(min(*lumFlag, 255)),
+		Overwrite:    *overwriteFlag,
+	})
+	if err != nil {
+		return err
+	}
+	log.Info().Int("count", len(written)).Msg("Draft walkable masks written, review them before shipping")
+	return nil
+}